@@ -0,0 +1,179 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/fsnotify/fsnotify"
+)
+
+// cacheTTL is how long a valid key is cached; argon2id keys are expensive to verify
+const cacheTTL = 2 * time.Minute
+
+// User is the identity of a valid key, available to policies as input.user
+type User struct {
+	Name    string     `json:"name"`
+	Owner   string     `json:"owner,omitempty"`
+	Scopes  []string   `json:"scopes"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// APIKeyProvider authenticates requests by a static API key sent in a header,
+// checked against a hot-reloaded file of hashed keys.
+type APIKeyProvider struct {
+	filePath   string
+	header     string
+	keys       atomic.Pointer[keyStore]
+	permissive bool
+	watcher    *fsnotify.Watcher
+	cache      *ristretto.Cache[uint64, cachedKey]
+	seed       maphash.Seed
+}
+
+// cachedKey is a valid key in the cache, with the SHA-256 digest of the key
+// to rule out hash collisions on the cache key
+type cachedKey struct {
+	digest [sha256.Size]byte
+	entry  *keyEntry
+}
+
+// New creates an APIKeyProvider reading keys from filePath, expecting them
+// in the given header. Returns nil if the file cannot be loaded or contains
+// no valid keys.
+func New(filePath, header string, permissive bool) *APIKeyProvider {
+	store, err := loadFile(filePath)
+	if err != nil {
+		slog.Error("apikey: failed to load keys", "file", filePath, "error", err)
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("apikey: failed to create file watcher", "error", err)
+		return nil
+	}
+
+	p := &APIKeyProvider{
+		filePath:   filepath.Clean(filePath),
+		header:     http.CanonicalHeaderKey(header),
+		permissive: permissive,
+		watcher:    w,
+		seed:       maphash.MakeSeed(),
+	}
+	p.keys.Store(store)
+
+	// watch the directory, so a replaced file (e.g. a Kubernetes Secret
+	// volume swapping a symlink) is noticed as well
+	if err := w.Add(filepath.Dir(p.filePath)); err != nil {
+		slog.Warn("apikey: failed to watch file, hot-reload disabled", "file", filePath, "error", err)
+	} else {
+		go startWatcher(p)
+	}
+
+	cache, err := ristretto.NewCache(&ristretto.Config[uint64, cachedKey]{
+		NumCounters: 10000, // number of keys to track frequency of.
+		MaxCost:     1000,  // maximum cost of cache (no-of-entries since we use cost=1).
+		BufferItems: 64,    // number of keys per Get buffer.
+	})
+	if err != nil {
+		slog.Warn("apikey: failed to create cache, authentication performance may be degraded", "error", err)
+	}
+	p.cache = cache
+
+	return p
+}
+
+// Authenticate implements types.AuthProvider.
+// Missing header → anonymous (nil error).
+// Unknown key → ErrAuthenticationFailed in strict mode, anonymous in permissive mode.
+// Expired key → always ErrAuthenticationFailed, even in permissive mode.
+func (p *APIKeyProvider) Authenticate(info *types.Info, r *http.Request) error {
+	key := r.Header.Get(p.header)
+	if key == "" {
+		return nil // anonymous passthrough
+	}
+
+	// The key never reaches the policy engine or the decision log.
+	delete(info.Request.Headers, p.header)
+
+	e := p.lookup(key)
+	if e == nil {
+		metrics.IncrementAPIKey("", "unknown")
+		return p.reject("unknown key")
+	}
+	if e.Expires != nil && time.Now().After(*e.Expires) {
+		metrics.IncrementAPIKey(e.Name, "expired")
+		slog.Warn("apikey: expired key used", "name", e.Name, "expires", e.Expires)
+		return types.ErrAuthenticationFailed
+	}
+
+	metrics.IncrementAPIKey(e.Name, "success")
+	scopes := e.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	info.User = &User{Name: e.Name, Owner: e.Owner, Scopes: scopes, Expires: e.Expires}
+	info.Request.Principal = e.Name
+	return nil
+}
+
+// lookup returns the entry of a key, using the cache when possible.
+// Unknown keys are not cached, so random keys cannot evict valid ones.
+func (p *APIKeyProvider) lookup(key string) *keyEntry {
+	digest := sha256.Sum256([]byte(key))
+	cacheKey := maphash.Bytes(p.seed, digest[:])
+	if p.cache != nil {
+		if c, found := p.cache.Get(cacheKey); found && c.digest == digest {
+			return c.entry
+		}
+	}
+
+	e := p.keys.Load().lookup(key)
+	if e != nil && p.cache != nil {
+		p.cache.SetWithTTL(cacheKey, cachedKey{digest: digest, entry: e}, 1, cacheTTL)
+	}
+	return e
+}
+
+// reject returns ErrAuthenticationFailed in strict mode, or nil (anonymous) in permissive mode.
+func (p *APIKeyProvider) reject(reason string) error {
+	if !p.permissive {
+		slog.Warn("apikey: key rejected", "reason", reason)
+		return types.ErrAuthenticationFailed
+	}
+	slog.Debug(fmt.Sprintf("apikey: treating %s as anonymous (permissive mode)", reason))
+	return nil
+}
+
+// Close stops watching the key file and releases the cache.
+func (p *APIKeyProvider) Close() error {
+	err := p.watcher.Close()
+	if p.cache != nil {
+		p.cache.Close()
+	}
+	return err
+}
+
+// Header returns the header carrying the key.
+func (p *APIKeyProvider) Header() string {
+	return p.header
+}
+
+// WWWAuthenticate implements the optional types.AuthChallenger interface.
+func (p *APIKeyProvider) WWWAuthenticate() string {
+	return fmt.Sprintf(`ApiKey realm="rest-rego", header=%q`, p.header)
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (p *APIKeyProvider) Name() string {
+	return "apikey"
+}
@@ -0,0 +1,305 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+func sha256Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// argon2idHash hashes with small parameters to keep the tests fast
+func argon2idHash(key string) string {
+	salt := []byte("0123456789abcdef")
+	sum := argon2.IDKey([]byte(key), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum))
+}
+
+func writeKeyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testKeyFile(t *testing.T) string {
+	return writeKeyFile(t, fmt.Sprintf(`
+keys:
+  - name: acme-orders
+    hash: %q
+    owner: ACME Corp
+    scopes: ["orders:read", "orders:write"]
+    expires: %s
+  - name: globex
+    hash: %q
+  - name: retired
+    hash: %q
+    expires: 2020-01-01T00:00:00Z
+`, sha256Hash("acme-secret"), time.Now().Add(24*time.Hour).UTC().Format(time.RFC3339),
+		argon2idHash("globex.globex-secret"), sha256Hash("retired-secret")))
+}
+
+func newTestProvider(t *testing.T, permissive bool) *APIKeyProvider {
+	t.Helper()
+	p := New(testKeyFile(t), "X-Api-Key", permissive)
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func keyRequest(key string) (*types.Info, *http.Request) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if key != "" {
+		r.Header.Set("X-Api-Key", key)
+	}
+	return types.NewInfo(r, "Authorization", 0), r
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_ValidKeys(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	for key, want := range map[string]string{"acme-secret": "acme-orders", "globex.globex-secret": "globex"} {
+		t.Run(want, func(t *testing.T) {
+			info, r := keyRequest(key)
+			if err := p.Authenticate(info, r); err != nil {
+				t.Fatalf("Expected success, got %v", err)
+			}
+			user, ok := info.User.(*User)
+			if !ok || user.Name != want || info.Request.Principal != want {
+				t.Fatalf("Expected user %s, got %+v", want, info.User)
+			}
+			if _, ok := info.Request.Headers["X-Api-Key"]; ok {
+				t.Error("Expected the key to be removed from the policy input")
+			}
+		})
+	}
+
+	info, r := keyRequest("acme-secret")
+	p.Authenticate(info, r)
+	user := info.User.(*User)
+	if user.Owner != "ACME Corp" || len(user.Scopes) != 2 || user.Scopes[0] != "orders:read" || user.Expires == nil {
+		t.Errorf("Expected key attributes, got %+v", user)
+	}
+}
+
+func TestAuthenticate_NoKey_Anonymous(t *testing.T) {
+	p := newTestProvider(t, false)
+	info, r := keyRequest("")
+	if err := p.Authenticate(info, r); err != nil || info.User != nil {
+		t.Errorf("Expected anonymous, got %v %v", info.User, err)
+	}
+}
+
+func TestAuthenticate_UnknownKey(t *testing.T) {
+	info, r := keyRequest("guessed")
+	if err := newTestProvider(t, false).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+
+	info, r = keyRequest("guessed")
+	if err := newTestProvider(t, true).Authenticate(info, r); err != nil || info.User != nil {
+		t.Errorf("Expected anonymous in permissive mode, got %v %v", info.User, err)
+	}
+}
+
+func TestAuthenticate_ArgonKeyNeedsName(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	// the argon2id hash is only tried for the key named by the prefix
+	for _, key := range []string{"globex-secret", "acme-orders.globex.globex-secret", "other.globex-secret", "globex.wrong"} {
+		info, r := keyRequest(key)
+		if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+			t.Errorf("%s: expected ErrAuthenticationFailed, got %v", key, err)
+		}
+	}
+}
+
+func TestLookup_UnknownKeysNotCached(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	for _, key := range []string{"guessed", "globex.wrong", "globex.globex-secret"} {
+		p.lookup(key)
+	}
+	p.cache.Wait()
+
+	for key, cached := range map[string]bool{"guessed": false, "globex.wrong": false, "globex.globex-secret": true} {
+		digest := sha256.Sum256([]byte(key))
+		if _, found := p.cache.Get(maphash.Bytes(p.seed, digest[:])); found != cached {
+			t.Errorf("%s: expected cached=%v, got %v", key, cached, found)
+		}
+	}
+}
+
+func TestAuthenticate_ExpiredKey(t *testing.T) {
+	for _, permissive := range []bool{false, true} {
+		info, r := keyRequest("retired-secret")
+		err := newTestProvider(t, permissive).Authenticate(info, r)
+		if !errors.Is(err, types.ErrAuthenticationFailed) {
+			t.Errorf("permissive=%v: expected ErrAuthenticationFailed, got %v", permissive, err)
+		}
+		if info.User != nil {
+			t.Errorf("permissive=%v: expected no user, got %v", permissive, info.User)
+		}
+	}
+}
+
+// --- loadFile tests ---
+
+func TestLoadFile_SkipsInvalidEntries(t *testing.T) {
+	path := writeKeyFile(t, fmt.Sprintf(`
+keys:
+  - name: valid
+    hash: %q
+  - hash: %q
+  - name: valid
+    hash: %q
+  - name: plain
+    hash: acme-secret
+  - name: short
+    hash: sha256:abcd
+  - name: broken-argon
+    hash: $argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA
+  - name: dotted.argon
+    hash: %q
+`, sha256Hash("a"), sha256Hash("b"), sha256Hash("c"), argon2idHash("dotted.argon.secret")))
+
+	store, err := loadFile(path)
+	if err != nil {
+		t.Fatalf("Expected file to load, got %v", err)
+	}
+	if store.count() != 1 || store.lookup("a") == nil {
+		t.Errorf("Expected only the first valid entry, got %d keys", store.count())
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"no valid keys": "keys:\n  - name: plain\n    hash: secret\n",
+		"unknown field": "keys:\n  - name: a\n    hash: " + sha256Hash("a") + "\n    scope: read\n",
+		"not yaml":      "keys: [",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadFile(writeKeyFile(t, content)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+	if _, err := loadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestReload(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	// rotate: acme's key is replaced
+	content := fmt.Sprintf("keys:\n  - name: acme-orders\n    hash: %q\n", sha256Hash("acme-rotated"))
+	if err := os.WriteFile(p.filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, r := keyRequest("acme-rotated")
+		if p.Authenticate(info, r) == nil && info.User != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Keys were not reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	info, r := keyRequest("acme-secret")
+	if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the old key to be rejected after reload, got %v", err)
+	}
+}
+
+// TestReload_KubernetesVolume tests a Secret update, which swaps the "..data"
+// symlink the mounted file points through
+func TestReload_KubernetesVolume(t *testing.T) {
+	dir := t.TempDir()
+	writeVersion := func(version, key string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := fmt.Sprintf("keys:\n  - name: acme-orders\n    hash: %q\n", sha256Hash(key))
+		if err := os.WriteFile(filepath.Join(dir, version, "keys.yaml"), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, kubernetesDataLink)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("..version_1", "acme-secret")
+	if err := os.Symlink(filepath.Join(kubernetesDataLink, "keys.yaml"), filepath.Join(dir, "keys.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(filepath.Join(dir, "keys.yaml"), "X-Api-Key", false)
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	t.Cleanup(func() { p.Close() })
+
+	writeVersion("..version_2", "acme-rotated")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, r := keyRequest("acme-rotated")
+		if p.Authenticate(info, r) == nil && info.User != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Keys were not reloaded after the volume update")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	info, r := keyRequest("acme-secret")
+	if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := New(testKeyFile(t), "X-Api-Key", false)
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if _, ok := <-p.watcher.Events; ok {
+		t.Error("Expected the watcher to be closed")
+	}
+}
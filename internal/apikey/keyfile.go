@@ -0,0 +1,179 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrNoValidKeys is returned when a file contains no usable keys.
+var ErrNoValidKeys = errors.New("apikey: no valid keys found in file")
+
+const sha256Prefix = "sha256:"
+
+// keyEntry is one key in the key file
+type keyEntry struct {
+	Name    string     `json:"name"`
+	Hash    string     `json:"hash"` // "sha256:<hex>" or an argon2id PHC string of "<name>.<secret>"
+	Owner   string     `json:"owner,omitempty"`
+	Scopes  []string   `json:"scopes,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	argon *argonHash
+}
+
+// keyFile is the format of API_KEY_FILE
+type keyFile struct {
+	Keys []*keyEntry `json:"keys"`
+}
+
+// keyStore holds the loaded keys: SHA-256 keys by their hex digest, and
+// argon2id keys by their name, which prefixes the key as "<name>.<secret>"
+// so that at most one argon2id hash is computed per lookup
+type keyStore struct {
+	sha256 map[string]*keyEntry
+	argon  map[string]*keyEntry
+}
+
+func (s *keyStore) count() int {
+	return len(s.sha256) + len(s.argon)
+}
+
+// lookup returns the entry matching a presented key, or nil
+func (s *keyStore) lookup(key string) *keyEntry {
+	sum := sha256.Sum256([]byte(key))
+	if e, ok := s.sha256[hex.EncodeToString(sum[:])]; ok {
+		return e
+	}
+	name, _, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil
+	}
+	if e, ok := s.argon[name]; ok && e.argon.verify(key) {
+		return e
+	}
+	return nil
+}
+
+// loadFile parses a YAML (or JSON) key file. Entries without a name or with
+// an unsupported hash are logged at WARN level and skipped. Returns
+// ErrNoValidKeys if no entry is usable.
+func loadFile(filePath string) (*keyStore, error) {
+	data, err := os.ReadFile(filePath) // #nosec G304 — path comes from operator-controlled config
+	if err != nil {
+		return nil, fmt.Errorf("apikey: cannot read file %q: %w", filePath, err)
+	}
+
+	var f keyFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("apikey: invalid file %q: %w", filePath, err)
+	}
+
+	store := &keyStore{sha256: make(map[string]*keyEntry), argon: make(map[string]*keyEntry)}
+	names := make(map[string]bool)
+	for i, e := range f.Keys {
+		if e == nil || e.Name == "" {
+			slog.Warn("apikey: skipping key without name", "file", filePath, "index", i)
+			continue
+		}
+		if names[e.Name] {
+			slog.Warn("apikey: skipping duplicate key name", "file", filePath, "name", e.Name)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(e.Hash, sha256Prefix):
+			digest := strings.ToLower(strings.TrimPrefix(e.Hash, sha256Prefix))
+			if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+				slog.Warn("apikey: skipping key with invalid sha256 hash", "file", filePath, "name", e.Name)
+				continue
+			}
+			store.sha256[digest] = e
+
+		case strings.HasPrefix(e.Hash, "$argon2id$"):
+			if strings.Contains(e.Name, ".") {
+				slog.Warn("apikey: skipping argon2id key with a '.' in its name", "file", filePath, "name", e.Name)
+				continue
+			}
+			h, err := parseArgon2id(e.Hash)
+			if err != nil {
+				slog.Warn("apikey: skipping key with invalid argon2id hash", "file", filePath, "name", e.Name, "error", err)
+				continue
+			}
+			e.argon = h
+			store.argon[e.Name] = e
+
+		default:
+			slog.Warn("apikey: skipping key with unsupported hash format (sha256 or argon2id)", "file", filePath, "name", e.Name)
+			continue
+		}
+		names[e.Name] = true
+
+		if e.Expires != nil && time.Now().After(*e.Expires) {
+			slog.Warn("apikey: key has expired", "file", filePath, "name", e.Name, "expires", e.Expires)
+		}
+	}
+
+	if store.count() == 0 {
+		return nil, ErrNoValidKeys
+	}
+
+	slog.Info("apikey: loaded keys", "count", store.count(), "file", filePath)
+	return store, nil
+}
+
+// argonHash is a parsed argon2id hash
+type argonHash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+// parseArgon2id parses the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash> (unpadded base64)
+func parseArgon2id(s string) (*argonHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("expected $argon2id$v=..$m=..,t=..,p=..$salt$hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported version %q", parts[2])
+	}
+
+	h := &argonHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid parameters %q", parts[3])
+	}
+	if h.memory == 0 || h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid parameters %q", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.hash) == 0 {
+		return nil, fmt.Errorf("invalid hash: %v", err)
+	}
+	return h, nil
+}
+
+// verify checks a key against the hash in constant time
+func (h *argonHash) verify(key string) bool {
+	sum := argon2.IDKey([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	return subtle.ConstantTimeCompare(sum, h.hash) == 1
+}
@@ -0,0 +1,51 @@
+package apikey
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// kubernetesDataLink is the symlink Kubernetes swaps to update a Secret or
+// ConfigMap volume at once, the files in the volume are symlinks through it
+const kubernetesDataLink = "..data"
+
+// startWatcher listens for file-system events in the directory of p.filePath
+// and atomically replaces the key store when the file, or the "..data" link
+// of a Kubernetes volume, is written or created.
+// On a load error the existing keys are retained unchanged.
+// This function is intended to run in its own goroutine.
+func startWatcher(p *APIKeyProvider) {
+	for {
+		select {
+		case event, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if name != p.filePath && filepath.Base(name) != kubernetesDataLink {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+				store, err := loadFile(p.filePath)
+				if err != nil {
+					slog.Error("apikey: failed to reload keys, retaining last valid set",
+						"file", p.filePath, "error", err)
+					continue
+				}
+				p.keys.Store(store)
+				if p.cache != nil {
+					p.cache.Clear() // Clear cache so removed keys stop working immediately.
+				}
+				slog.Info("apikey: reloaded keys", "count", store.count(), "file", p.filePath)
+			}
+
+		case err, ok := <-p.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("apikey: file watcher error", "file", p.filePath, "error", err)
+		}
+	}
+}
@@ -2,28 +2,70 @@ package application
 
 import (
 	"context"
+	"crypto/tls"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/AB-Lindex/rest-rego/internal/apikey"
+	"github.com/AB-Lindex/rest-rego/internal/authchain"
 	"github.com/AB-Lindex/rest-rego/internal/azure"
 	"github.com/AB-Lindex/rest-rego/internal/basicauth"
+	"github.com/AB-Lindex/rest-rego/internal/certauth"
 	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/decisionlog"
+	"github.com/AB-Lindex/rest-rego/internal/extauthz"
+	"github.com/AB-Lindex/rest-rego/internal/hmacauth"
+	"github.com/AB-Lindex/rest-rego/internal/introspection"
 	"github.com/AB-Lindex/rest-rego/internal/jwtsupport"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
 	"github.com/AB-Lindex/rest-rego/internal/noauth"
 	"github.com/AB-Lindex/rest-rego/internal/router"
+	"github.com/AB-Lindex/rest-rego/internal/tokenreview"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
 	"github.com/AB-Lindex/rest-rego/pkg/regocache"
 )
 
+// logMask returns the input fields removed before logging: the credentials
+// and the headers carrying them (unless LOG_MASK_HASH hashes them instead),
+// followed by LOG_MASK.
+func logMask(cfg *config.Fields) []string {
+	defaults := []string{
+		"/request/auth/token",
+		"/request/auth/password",
+		"/request/headers/Authorization",
+		"/request/headers/Proxy-Authorization",
+		"/request/headers/Cookie",
+		"/request/headers/" + cfg.AuthHeader,
+	}
+	if cfg.APIKeyFile != "" {
+		defaults = append(defaults, "/request/headers/"+cfg.APIKeyHeader)
+	}
+
+	var remove []string
+	for _, ptr := range defaults {
+		if !slices.Contains(remove, ptr) && !slices.Contains(cfg.LogMaskHash, ptr) {
+			remove = append(remove, ptr)
+		}
+	}
+	return append(remove, cfg.LogMask...)
+}
+
 // AppData is the main application data structure and coordinates the business-logic
 type AppData struct {
 	config *config.Fields
 	regos  *regocache.RegoCache
 	router *router.Proxy
 	auth   types.AuthProvider
+	dlog   *decisionlog.Logger
+	authz  *extauthz.Server
+
+	shutdownTracing func(context.Context) error
 }
 
 // New creates a new instance of the application
@@ -35,34 +77,59 @@ func New() (*AppData, bool) {
 	app.startMgmt()
 	startPprof()
 
-	// create file-cache
-	slog.Debug("application: creating policy cache", "dir", app.config.PolicyDir)
-	c, err := regocache.New(app.config.PolicyDir, app.config.FilePattern, app.config.Debug, app.config.RequestRego)
+	if app.config.OTLPEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+			Endpoint:    app.config.OTLPEndpoint,
+			Protocol:    app.config.OTLPProtocol,
+			SampleRatio: app.config.TraceSampleRatio,
+			Version:     types.Version(),
+		})
+		if err != nil {
+			slog.Error("application: failed to set up tracing", "error", err)
+			return nil, false
+		}
+		app.shutdownTracing = shutdown
+	}
+
+	// create policy-cache, from a remote bundle or the policy folder
+	var c *regocache.RegoCache
+	var err error
+	if len(app.config.BundleURL) > 0 {
+		slog.Debug("application: creating remote policy cache", "url", app.config.BundleURL)
+		c, err = regocache.NewRemote(regocache.RemoteConfig{
+			URL:           app.config.BundleURL,
+			PollInterval:  app.config.BundlePollInterval,
+			MaxSize:       app.config.BundleMaxSize,
+			PublicKeyFile: app.config.BundlePublicKey,
+			KeyID:         app.config.BundleKeyID,
+			Algorithm:     app.config.BundleSigningAlg,
+		}, app.config.Debug, app.config.RequestRego)
+	} else {
+		slog.Debug("application: creating policy cache", "dir", app.config.PolicyDir)
+		c, err = regocache.New(app.config.PolicyDir, app.config.FilePattern, app.config.Debug, app.config.RequestRego)
+	}
 	if err != nil || c == nil {
+		slog.Error("application: failed to create policy cache", "error", err)
 		return nil, false
 	}
 	app.regos = c
+	c.SetReloadHook(metrics.ObservePolicyReload)
 
-	switch {
-	case len(app.config.AzureTenant) > 0:
-		slog.Debug("application: creating auth provider", "tenant", app.config.AzureTenant)
-		app.auth = azure.New(app.config.AzureTenant, app.config.AuthHeader, app.config.PermissiveAuth)
-
-	case len(app.config.WellKnownURL) > 0:
-		slog.Debug("application: creating jwt-auth-provider", "well-knowns", len(app.config.WellKnownURL))
-		app.auth = jwtsupport.New(app.config.WellKnownURL, app.config.AudienceKey, app.config.Audiences, app.config.AuthKind, app.config.PermissiveAuth)
-
-	case len(app.config.BasicAuthFile) > 0:
-		slog.Debug("application: creating basic-auth-provider", "file", app.config.BasicAuthFile)
-		app.auth = basicauth.New(app.config.BasicAuthFile, app.config.PermissiveAuth)
-
-	case app.config.NoAuth:
-		app.auth = noauth.New(app.config.PermissiveAuth)
-
-	default:
-		slog.Error("application: no auth-provider configured")
+	mask, err := regocache.NewMask(logMask(app.config), app.config.LogMaskHash)
+	if err != nil {
+		slog.Error("application: invalid log mask", "error", err)
 		return nil, false
 	}
+	c.SetMask(mask)
+
+	if app.config.DecisionLogEnabled() {
+		if app.dlog = newDecisionLogger(app.config); app.dlog == nil {
+			return nil, false
+		}
+		c.SetDecisionLogger(app.dlog)
+	}
+
+	app.auth = newAuthProvider(app.config)
 	if app.auth == nil {
 		return nil, false
 	}
@@ -73,6 +140,14 @@ func New() (*AppData, bool) {
 		return nil, false
 	}
 
+	if app.config.ExtAuthzAddr != "" {
+		var tlsConfig *tls.Config
+		if app.config.ExtAuthzTLS {
+			tlsConfig = app.router.TLSConfig()
+		}
+		app.authz = extauthz.New(app.router.CheckHandler(), app.config.RequestIDHeader, tlsConfig)
+	}
+
 	return app, true
 }
 
@@ -87,8 +162,30 @@ func (app *AppData) Close() {
 
 	slog.Debug("closing router...")
 	app.router.Close()
+	if closer, ok := app.auth.(io.Closer); ok {
+		slog.Debug("closing auth providers...")
+		if err := closer.Close(); err != nil {
+			slog.Warn("application: failed to close auth providers", "error", err)
+		}
+	}
+	if app.authz != nil {
+		slog.Debug("closing ext_authz server...")
+		app.authz.Close()
+	}
 	slog.Debug("closing regos...")
 	app.regos.Close()
+	if app.dlog != nil {
+		slog.Debug("flushing decision log...")
+		app.dlog.Close()
+	}
+	if app.shutdownTracing != nil {
+		slog.Debug("flushing traces...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := app.shutdownTracing(ctx); err != nil {
+			slog.Warn("application: failed to flush traces", "error", err)
+		}
+		cancel()
+	}
 	slog.Info("all closed - exiting")
 }
 
@@ -99,6 +196,12 @@ func (app *AppData) Run() bool {
 
 	app.regos.Watch()
 	app.router.ListenAndServe()
+	if app.authz != nil {
+		if err := app.authz.ListenAndServe(app.config.ExtAuthzAddr); err != nil {
+			slog.Error("application: failed to start ext_authz server", "error", err)
+			return false
+		}
+	}
 
 	if !app.regos.Ready() {
 		slog.Warn("application: waiting for regos to be ready")
@@ -108,3 +211,147 @@ func (app *AppData) Run() bool {
 	slog.Warn("application: caught signal", "signal", sig)
 	return app.regos.Ready()
 }
+
+// newAuthProvider creates the configured auth providers, chaining them
+// when more than one is configured (client certificates first, then the
+// bearer token providers, signed requests, API keys and basic auth). Returns nil on errors.
+func newAuthProvider(cfg *config.Fields) types.AuthProvider {
+	if cfg.NoAuth {
+		if na := noauth.New(cfg.PermissiveAuth); na != nil {
+			return na
+		}
+		return nil
+	}
+
+	var entries []authchain.Entry
+	if cfg.ClientCertAuth {
+		slog.Debug("application: creating client-certificate auth-provider", "client-ca", cfg.TLSClientCAFile)
+		entries = append(entries, authchain.Entry{Provider: certauth.New(cfg.PermissiveAuth), Scheme: authchain.SchemeTLS})
+	}
+
+	if len(cfg.AzureTenant) > 0 {
+		slog.Debug("application: creating auth provider", "tenant", cfg.AzureTenant)
+		az := azure.New(cfg.AzureTenant, cfg.AuthHeader, cfg.Audiences, cfg.AzureGraphTokens, cfg.PermissiveAuth)
+		if az == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: az, Scheme: "bearer"})
+	}
+
+	if len(cfg.WellKnownURL) > 0 {
+		slog.Debug("application: creating jwt-auth-provider", "well-knowns", len(cfg.WellKnownURL))
+		j := jwtsupport.New(cfg.WellKnownURL, cfg.AudienceKey, cfg.Audiences, cfg.AuthKind, cfg.PermissiveAuth, jwtsupport.Validation{
+			RequiredClaims: cfg.JWTRequiredClaims,
+			ClockSkew:      cfg.JWTClockSkew,
+			MaxAge:         cfg.JWTMaxTokenAge,
+		}, cfg.JWTCacheTTL)
+		if j == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: j, Scheme: cfg.AuthKind})
+	}
+
+	if cfg.TokenReview {
+		slog.Debug("application: creating tokenreview auth-provider", "kubeconfig", cfg.Kubeconfig)
+		tr := tokenreview.New(tokenreview.Config{
+			Kubeconfig:  cfg.Kubeconfig,
+			AuthHeader:  cfg.AuthHeader,
+			Audiences:   cfg.TokenReviewAudiences,
+			CacheTTL:    cfg.TokenReviewCacheTTL,
+			NegativeTTL: cfg.TokenReviewNegativeTTL,
+			Timeout:     cfg.TokenReviewTimeout,
+			Permissive:  cfg.PermissiveAuth,
+		})
+		if tr == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: tr, Scheme: "bearer"})
+	}
+
+	if len(cfg.IntrospectionURL) > 0 {
+		slog.Debug("application: creating introspection auth-provider", "url", cfg.IntrospectionURL)
+		ip := introspection.New(introspection.Config{
+			URL:          cfg.IntrospectionURL,
+			ClientID:     cfg.IntrospectionClientID,
+			ClientSecret: cfg.IntrospectionClientSecret,
+			AuthHeader:   cfg.AuthHeader,
+			Audiences:    cfg.Audiences,
+			CacheTTL:     cfg.IntrospectionCacheTTL,
+			NegativeTTL:  cfg.IntrospectionNegativeTTL,
+			Timeout:      cfg.IntrospectionTimeout,
+			Permissive:   cfg.PermissiveAuth,
+		})
+		if ip == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: ip, Scheme: "bearer"})
+	}
+
+	if len(cfg.HMACSecretsFile) > 0 {
+		slog.Debug("application: creating hmac-auth-provider", "file", cfg.HMACSecretsFile, "signed-headers", cfg.HMACSignedHeaders)
+		h := hmacauth.New(hmacauth.Config{
+			SecretsFile:   cfg.HMACSecretsFile,
+			SignedHeaders: cfg.HMACSignedHeaders,
+			MaxSkew:       cfg.HMACMaxSkew,
+			MaxBodySize:   cfg.HMACMaxBodySize,
+			Permissive:    cfg.PermissiveAuth,
+		})
+		if h == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: h, Scheme: hmacauth.Scheme})
+	}
+
+	if len(cfg.APIKeyFile) > 0 {
+		slog.Debug("application: creating api-key-provider", "file", cfg.APIKeyFile, "header", cfg.APIKeyHeader)
+		k := apikey.New(cfg.APIKeyFile, cfg.APIKeyHeader, cfg.PermissiveAuth)
+		if k == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: k, Header: cfg.APIKeyHeader})
+	}
+
+	if len(cfg.BasicAuthFile) > 0 {
+		slog.Debug("application: creating basic-auth-provider", "file", cfg.BasicAuthFile)
+		b := basicauth.New(cfg.BasicAuthFile, cfg.PermissiveAuth)
+		if b == nil {
+			return nil
+		}
+		entries = append(entries, authchain.Entry{Provider: b, Scheme: "basic"})
+	}
+
+	switch len(entries) {
+	case 0:
+		slog.Error("application: no auth-provider configured")
+		return nil
+	case 1:
+		return entries[0].Provider
+	default:
+		return authchain.New(entries...)
+	}
+}
+
+// newDecisionLogger creates the decision logger with the configured sinks
+func newDecisionLogger(cfg *config.Fields) *decisionlog.Logger {
+	var sinks []decisionlog.Sink
+	if cfg.DecisionLogStdout {
+		sinks = append(sinks, decisionlog.NewStdoutSink())
+	}
+	if cfg.DecisionLogFile != "" {
+		fs, err := decisionlog.NewFileSink(cfg.DecisionLogFile, int64(cfg.DecisionLogFileMaxSize)*1024*1024, cfg.DecisionLogFileBackups)
+		if err != nil {
+			slog.Error("application: failed to create decision log file", "error", err)
+			return nil
+		}
+		sinks = append(sinks, fs)
+	}
+	if cfg.DecisionLogURL != "" {
+		sinks = append(sinks, decisionlog.NewHTTPSink(cfg.DecisionLogURL))
+	}
+
+	return decisionlog.New(decisionlog.Config{
+		BufferSize:    cfg.DecisionLogBufferSize,
+		BatchSize:     cfg.DecisionLogBatchSize,
+		FlushInterval: cfg.DecisionLogFlushInterval,
+	}, sinks...)
+}
@@ -0,0 +1,166 @@
+// Package authchain combines several auth providers in one instance, e.g. JWT
+// for new clients and basic auth for legacy scripts on the same API.
+package authchain
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SchemeTLS selects a provider by the client certificate of the connection
+// instead of the Authorization scheme.
+const SchemeTLS = "tls"
+
+// Entry is a provider and the credentials it handles: an Authorization
+// scheme (e.g. "bearer", "basic"), SchemeTLS, or a header of its own
+type Entry struct {
+	Provider types.AuthProvider
+	Scheme   string
+	Header   string // e.g. X-Api-Key, used instead of Scheme
+}
+
+// Chain is a types.AuthProvider trying its providers in order
+type Chain struct {
+	entries []Entry
+}
+
+// New creates a Chain of the providers, in order of preference
+func New(entries ...Entry) *Chain {
+	names := make([]string, 0, len(entries))
+	for i := range entries {
+		entries[i].Scheme = strings.ToLower(entries[i].Scheme)
+		names = append(names, name(entries[i].Provider))
+	}
+	slog.Info("authchain: chaining auth providers", "providers", names)
+	return &Chain{entries: entries}
+}
+
+// Authenticate implements types.AuthProvider.
+// Only the providers handling the presented credentials are tried, in order;
+// the first one authenticating the request wins and is recorded as
+// input.request.auth.provider.
+// Without matching credentials → anonymous.
+// A provider reporting ErrAuthenticationUnavailable ends the chain (fail closed).
+// Otherwise ErrAuthenticationFailed if any provider rejected the credentials.
+// The outcome is counted once, under the provider that decided it ("chain"
+// if no provider handled the credentials).
+func (c *Chain) Authenticate(info *types.Info, r *http.Request) error {
+	var failed error
+	failedBy, anonymousBy := "", "chain"
+	for _, e := range c.entries {
+		if !e.matches(info, r) {
+			continue
+		}
+
+		n := name(e.Provider)
+		ctx, span := tracing.Start(r.Context(), "auth "+n, attribute.String("auth.provider", n))
+		ar := r.WithContext(ctx)
+		err := e.Provider.Authenticate(info, ar)
+		r.Body = ar.Body // keep a body buffered by the provider
+		tracing.End(span, err)
+
+		switch {
+		case err == nil && (info.JWT != nil || info.User != nil):
+			metrics.IncrementAuth(n, "success")
+			if info.Request.Auth == nil {
+				info.Request.Auth = &types.RequestAuth{Kind: e.Scheme}
+				if e.Header != "" {
+					info.Request.Auth.Kind = e.Header
+				}
+			}
+			info.Request.Auth.Provider = n
+			slog.Debug("authchain: authenticated", "provider", n)
+			return nil
+
+		case err == nil:
+			// rejected in permissive mode, another provider may still accept the credentials
+			if anonymousBy == "chain" {
+				anonymousBy = n
+			}
+
+		case errors.Is(err, types.ErrAuthenticationUnavailable):
+			metrics.IncrementAuth(n, "unavailable")
+			slog.Warn("authchain: provider unavailable, failing closed", "provider", n)
+			return err
+
+		case errors.Is(err, types.ErrAuthenticationFailed):
+			slog.Debug("authchain: provider rejected credentials", "provider", n)
+			failed, failedBy = err, n
+
+		default:
+			metrics.IncrementAuth(n, "error")
+			return err
+		}
+		info.Request.Principal = ""
+	}
+	if failed != nil {
+		metrics.IncrementAuth(failedBy, "failed")
+	} else {
+		metrics.IncrementAuth(anonymousBy, "anonymous")
+	}
+	return failed
+}
+
+// matches reports if the request has credentials for the provider
+func (e Entry) matches(info *types.Info, r *http.Request) bool {
+	if e.Header != "" {
+		return r.Header.Get(e.Header) != ""
+	}
+	if e.Scheme == SchemeTLS {
+		return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+	}
+	return info.Request.Auth != nil && strings.EqualFold(info.Request.Auth.Kind, e.Scheme)
+}
+
+// WWWAuthenticate implements the optional types.AuthChallenger interface,
+// offering the challenges of all header based providers.
+func (c *Chain) WWWAuthenticate() string {
+	var challenges []string
+	for _, e := range c.entries {
+		if e.Scheme == SchemeTLS {
+			continue
+		}
+		challenge := "Bearer"
+		if ch, ok := e.Provider.(types.AuthChallenger); ok {
+			challenge = ch.WWWAuthenticate()
+		}
+		if !slices.Contains(challenges, challenge) {
+			challenges = append(challenges, challenge)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// Close implements io.Closer, closing the providers that hold resources
+// like file watchers.
+func (c *Chain) Close() error {
+	var errs []error
+	for _, e := range c.entries {
+		if closer, ok := e.Provider.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (c *Chain) Name() string {
+	return "chain"
+}
+
+// name returns the name of a provider, for logs, traces and metrics
+func name(p types.AuthProvider) string {
+	if n, ok := p.(types.AuthNamer); ok {
+		return n.Name()
+	}
+	return "unknown"
+}
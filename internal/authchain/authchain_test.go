@@ -0,0 +1,240 @@
+package authchain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/apikey"
+	"github.com/AB-Lindex/rest-rego/internal/basicauth"
+	"github.com/AB-Lindex/rest-rego/internal/hmacauth"
+	"github.com/AB-Lindex/rest-rego/internal/jwtsupport"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+// stubProvider authenticates with a fixed result and counts its calls
+type stubProvider struct {
+	name      string
+	err       error
+	user      interface{}
+	challenge string
+	calls     int
+}
+
+func (s *stubProvider) Authenticate(info *types.Info, _ *http.Request) error {
+	s.calls++
+	if s.err == nil && s.user != nil {
+		info.User = s.user
+		info.Request.Principal = s.name + "-user"
+	}
+	return s.err
+}
+
+func (s *stubProvider) Name() string { return s.name }
+
+type challengeProvider struct{ stubProvider }
+
+func (c *challengeProvider) WWWAuthenticate() string { return c.challenge }
+
+type closeProvider struct {
+	stubProvider
+	closed bool
+}
+
+func (c *closeProvider) Close() error {
+	c.closed = true
+	return nil
+}
+
+func request(authorization string) (*types.Info, *http.Request) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return types.NewInfo(r, "Authorization", 0), r
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_DispatchByScheme(t *testing.T) {
+	jwt := &stubProvider{name: "jwt", user: "token-user"}
+	basic := &stubProvider{name: "basic", user: "alice"}
+	chain := New(Entry{Provider: jwt, Scheme: "Bearer"}, Entry{Provider: basic, Scheme: "basic"})
+
+	info, r := request("Basic YWxpY2U6c2VjcmV0")
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if jwt.calls != 0 || basic.calls != 1 {
+		t.Errorf("Expected only basic to be tried, got jwt=%d basic=%d", jwt.calls, basic.calls)
+	}
+	if info.Request.Auth.Provider != "basic" || info.User != "alice" {
+		t.Errorf("Expected basic to authenticate, got %q %v", info.Request.Auth.Provider, info.User)
+	}
+}
+
+func TestAuthenticate_TriesInOrder(t *testing.T) {
+	jwt := &stubProvider{name: "jwt", err: types.ErrAuthenticationFailed}
+	introspection := &stubProvider{name: "introspection", user: "opaque-user"}
+	chain := New(Entry{Provider: jwt, Scheme: "bearer"}, Entry{Provider: introspection, Scheme: "bearer"})
+
+	info, r := request("Bearer opaque")
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if jwt.calls != 1 || introspection.calls != 1 {
+		t.Errorf("Expected both providers to be tried, got jwt=%d introspection=%d", jwt.calls, introspection.calls)
+	}
+	if info.Request.Auth.Provider != "introspection" || info.Request.Principal != "introspection-user" {
+		t.Errorf("Expected introspection to authenticate, got %q %q", info.Request.Auth.Provider, info.Request.Principal)
+	}
+}
+
+func TestAuthenticate_FirstMatchWins(t *testing.T) {
+	first := &stubProvider{name: "azure", user: "app"}
+	second := &stubProvider{name: "jwt", user: "token-user"}
+	chain := New(Entry{Provider: first, Scheme: "bearer"}, Entry{Provider: second, Scheme: "bearer"})
+
+	info, r := request("Bearer token")
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if second.calls != 0 || info.Request.Auth.Provider != "azure" {
+		t.Errorf("Expected the first provider to win, got %q (second called %d times)", info.Request.Auth.Provider, second.calls)
+	}
+}
+
+func TestAuthenticate_Failures(t *testing.T) {
+	testCases := []struct {
+		name   string
+		first  error
+		second error
+		want   error
+	}{
+		{"all rejected", types.ErrAuthenticationFailed, types.ErrAuthenticationFailed, types.ErrAuthenticationFailed},
+		{"unavailable fails closed", types.ErrAuthenticationUnavailable, nil, types.ErrAuthenticationUnavailable},
+		{"unavailable after rejection", types.ErrAuthenticationFailed, types.ErrAuthenticationUnavailable, types.ErrAuthenticationUnavailable},
+		{"permissive rejections are anonymous", nil, nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			first := &stubProvider{name: "jwt", err: tc.first}
+			second := &stubProvider{name: "introspection", err: tc.second}
+			if tc.second == nil && tc.first != nil {
+				second.user = "would-succeed"
+			}
+			chain := New(Entry{Provider: first, Scheme: "bearer"}, Entry{Provider: second, Scheme: "bearer"})
+
+			info, r := request("Bearer token")
+			err := chain.Authenticate(info, r)
+			if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+			if errors.Is(tc.first, types.ErrAuthenticationUnavailable) && second.calls != 0 {
+				t.Error("Expected the chain to stop at an unavailable provider")
+			}
+			if tc.want != nil && info.User != nil {
+				t.Errorf("Expected no user, got %v", info.User)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_NoCredentials_Anonymous(t *testing.T) {
+	cert := &stubProvider{name: "mtls", err: types.ErrAuthenticationFailed}
+	basic := &stubProvider{name: "basic", err: types.ErrAuthenticationFailed}
+	chain := New(Entry{Provider: cert, Scheme: SchemeTLS}, Entry{Provider: basic, Scheme: "basic"})
+
+	info, r := request("")
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Errorf("Expected anonymous, got %v", err)
+	}
+	if cert.calls+basic.calls != 0 {
+		t.Error("Expected no provider to be tried")
+	}
+	if info.Request.Auth != nil {
+		t.Errorf("Expected no auth, got %+v", info.Request.Auth)
+	}
+}
+
+func TestAuthenticate_ClientCertificate(t *testing.T) {
+	cert := &stubProvider{name: "mtls", user: "spiffe://cluster.local/ns/shop/sa/frontend"}
+	jwt := &stubProvider{name: "jwt", user: "token-user"}
+	chain := New(Entry{Provider: cert, Scheme: SchemeTLS}, Entry{Provider: jwt, Scheme: "bearer"})
+
+	info, r := request("")
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if info.Request.Auth == nil || info.Request.Auth.Provider != "mtls" || info.Request.Auth.Kind != SchemeTLS {
+		t.Errorf("Expected mtls to be recorded, got %+v", info.Request.Auth)
+	}
+}
+
+func TestAuthenticate_HeaderEntry(t *testing.T) {
+	jwt := &stubProvider{name: "jwt", user: "token-user"}
+	key := &stubProvider{name: "apikey", user: "acme-orders"}
+	chain := New(Entry{Provider: jwt, Scheme: "bearer"}, Entry{Provider: key, Header: "X-Api-Key"})
+
+	info, r := request("")
+	r.Header.Set("X-Api-Key", "secret")
+	if err := chain.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if jwt.calls != 0 || key.calls != 1 {
+		t.Errorf("Expected only apikey to be tried, got jwt=%d apikey=%d", jwt.calls, key.calls)
+	}
+	if info.Request.Auth == nil || info.Request.Auth.Provider != "apikey" || info.Request.Auth.Kind != "X-Api-Key" {
+		t.Errorf("Expected apikey to be recorded, got %+v", info.Request.Auth)
+	}
+}
+
+func TestWWWAuthenticate(t *testing.T) {
+	basic := &challengeProvider{stubProvider{name: "basic", challenge: `Basic realm="rest-rego"`}}
+	chain := New(
+		Entry{Provider: &stubProvider{name: "mtls"}, Scheme: SchemeTLS},
+		Entry{Provider: &stubProvider{name: "azure"}, Scheme: "bearer"},
+		Entry{Provider: &stubProvider{name: "jwt"}, Scheme: "bearer"},
+		Entry{Provider: basic, Scheme: "basic"},
+	)
+	if got := chain.WWWAuthenticate(); got != `Bearer, Basic realm="rest-rego"` {
+		t.Errorf("Unexpected challenge %q", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	jwt := &closeProvider{stubProvider: stubProvider{name: "jwt"}}
+	chain := New(
+		Entry{Provider: &stubProvider{name: "azure"}, Scheme: "bearer"},
+		Entry{Provider: jwt, Scheme: "bearer"},
+	)
+	if err := chain.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !jwt.closed {
+		t.Error("Expected the provider to be closed")
+	}
+}
+
+// TestClose_FileWatchingProviders tests that the providers watching files can
+// be closed on shutdown, alone or in a chain
+func TestClose_FileWatchingProviders(t *testing.T) {
+	for _, p := range []types.AuthProvider{
+		&apikey.APIKeyProvider{},
+		&basicauth.BasicAuthProvider{},
+		&hmacauth.HMACProvider{},
+		&jwtsupport.JWTSupport{},
+	} {
+		if _, ok := p.(io.Closer); !ok {
+			t.Errorf("%T does not implement io.Closer", p)
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package azure
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/AB-Lindex/go-resthelp"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
+	"github.com/AB-Lindex/rest-rego/internal/types"
 	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // GET https://graph.microsoft.com/v1.0/servicePrincipals(appId='{{appid}}')
@@ -19,32 +26,54 @@ var base = resthelp.New(
 	resthelp.WithBaseURL("https://graph.microsoft.com/v1.0"),
 )
 
-func getApp(appId, token string) map[string]string {
+// getApp returns the service principal of appId, as seen with token. A token
+// or app Graph refuses gives ErrAuthenticationFailed, any other failure
+// ErrAuthenticationUnavailable.
+func getApp(ctx context.Context, appId, token string) (map[string]string, error) {
 	key := fmt.Sprintf("%s:%s", appId, token)
 
+	_, span := tracing.Start(ctx, "graph servicePrincipals", attribute.String("azure.appid", appId))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
 	if x, found := userCache.Get(key); found {
 		slog.Debug("azure: reusing app from cache", "appId", appId)
-		return x.(map[string]string)
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		metrics.IncrementGraphCache(true)
+		return x.(map[string]string), nil
 	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	metrics.IncrementGraphCache(false)
 
 	slog.Debug("azure: fetching app from ms-graph", "appId", appId)
 
 	graph, err := base.Get(fmt.Sprintf("servicePrincipals(appId='%s')", appId))
 	if err != nil {
-		return nil
+		return nil, errors.Join(types.ErrAuthenticationUnavailable, err)
 	}
 
 	graph.AddHeader("Authorization", "Bearer "+token)
 	graph.AddQuery("$select", "id,displayName,appId,appOwnerOrganizationId,servicePrincipalType")
 	resp, err := graph.Do()
 	if err != nil {
-		return nil
+		return nil, errors.Join(types.ErrAuthenticationUnavailable, err)
+	}
+	defer resp.Close()
+
+	switch resp.Status() {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		// the token is forged, revoked or lacks access, or the app is unknown
+		err = fmt.Errorf("graph: %s", resp.Error())
+		return nil, errors.Join(types.ErrAuthenticationFailed, err)
+	default:
+		err = fmt.Errorf("graph: %s", resp.Error())
+		return nil, errors.Join(types.ErrAuthenticationUnavailable, err)
 	}
 
 	result := make(map[string]string)
-	err = resp.ParseJSON(&result)
-	if err != nil {
-		return nil
+	if err = resp.ParseJSON(&result); err != nil {
+		return nil, errors.Join(types.ErrAuthenticationUnavailable, err)
 	}
 
 	var toDelete []string
@@ -58,5 +87,5 @@ func getApp(appId, token string) map[string]string {
 	}
 
 	userCache.SetDefault(key, result)
-	return result
+	return result, nil
 }
@@ -1,29 +1,78 @@
 package azure
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/AB-Lindex/rest-rego/internal/jwtsupport"
 	"github.com/AB-Lindex/rest-rego/internal/types"
 
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
+// authority is the Microsoft identity platform host used to discover the
+// tenant's OIDC metadata and signing keys.
+const authority = "https://login.microsoftonline.com"
+
+// graphAudiences identify Microsoft Graph tokens. Graph signs its tokens over a
+// payload transformed by the nonce header, so nobody but Graph can verify them.
+var graphAudiences = []string{
+	"https://graph.microsoft.com",
+	"00000003-0000-0000-c000-000000000000",
+}
+
 // AzureAuthProvider is an implementation of the AuthProvider interface for Azure
 type AzureAuthProvider struct {
-	tenant     string
-	header     string
-	permissive bool // true = treat auth failures as anonymous
+	tenant      string
+	header      string
+	audiences   []string
+	issuers     []string
+	keys        *jwtsupport.KeySource
+	graphTokens bool // true = accept Graph tokens and let Graph verify them
+	permissive  bool // true = treat auth failures as anonymous
 }
 
-// New creates a new instance of the AzureAuthProvider
-func New(tenant, authHeader string, permissive bool) *AzureAuthProvider {
-	slog.Info("azure: creating auth provider", "tenant", tenant)
+// New creates a new instance of the AzureAuthProvider.
+// Tokens for one of the audiences are verified locally, Graph tokens are only
+// accepted with graphTokens. Returns nil if neither is configured, or if the
+// tenant's OIDC metadata or signing keys cannot be loaded.
+func New(tenant, authHeader string, audiences []string, graphTokens, permissive bool) *AzureAuthProvider {
+	wellKnown := fmt.Sprintf("%s/%s/v2.0/.well-known/openid-configuration", authority, tenant)
+	return newProvider(tenant, authHeader, wellKnown, audiences, graphTokens, permissive)
+}
+
+func newProvider(tenant, authHeader, wellKnown string, audiences []string, graphTokens, permissive bool) *AzureAuthProvider {
+	slog.Info("azure: creating auth provider", "tenant", tenant, "graph-tokens", graphTokens)
+
+	if len(audiences) == 0 && !graphTokens {
+		slog.Error("azure: no audience configured, set JWT_AUDIENCES to your API's audience or AZURE_GRAPH_TOKENS to accept Microsoft Graph tokens")
+		return nil
+	}
+	if slices.ContainsFunc(audiences, isGraphAudience) {
+		slog.Error("azure: Microsoft Graph tokens cannot be verified locally, remove the Graph audience from JWT_AUDIENCES and set AZURE_GRAPH_TOKENS instead")
+		return nil
+	}
+
+	keys, err := jwtsupport.NewKeySource(context.Background(), wellKnown)
+	if err != nil {
+		slog.Error("azure: failed to load tenant signing keys", "tenant", tenant, "url", wellKnown, "error", err)
+		return nil
+	}
+
 	return &AzureAuthProvider{
-		tenant:     tenant,
-		header:     authHeader,
-		permissive: permissive,
+		tenant:    tenant,
+		header:    authHeader,
+		audiences: audiences,
+		// v1 tokens are issued by sts.windows.net, v2 tokens by the metadata issuer
+		issuers:     []string{keys.Issuer(), fmt.Sprintf("https://sts.windows.net/%s/", tenant)},
+		keys:        keys,
+		graphTokens: graphTokens,
+		permissive:  permissive,
 	}
 }
 
@@ -36,59 +85,106 @@ func (az *AzureAuthProvider) Authenticate(info *types.Info, r *http.Request) err
 		return nil
 	}
 
-	// Case 2: Token malformed
-	token, err := jwt.Parse(bearerToken, jwt.WithVerify(false))
+	// Case 2: Token malformed, badly signed, expired or not intended for us
+	token, err := az.parse(r.Context(), bearerToken)
+	if errors.Is(err, types.ErrAuthenticationUnavailable) {
+		return err
+	}
 	if err != nil {
-		slog.Warn("azure: failed to parse JWT", "error", err)
-
-		if !az.permissive {
-			return types.ErrAuthenticationFailed
-		}
+		slog.Warn("azure: failed to verify JWT", "error", err)
+		return az.reject("invalid token")
+	}
 
-		slog.Debug("azure: treating malformed token as anonymous (permissive mode)")
-		return nil
+	// Case 3: Not issued by the tenant
+	if !slices.Contains(az.issuers, token.Issuer()) {
+		slog.Warn("azure: issuer mismatch", "expected", az.issuers, "got", token.Issuer())
+		return az.reject("wrong issuer")
 	}
 
 	appid := getTokenString(token, "appid")
 	tid := getTokenString(token, "tid")
 
-	// Case 3: Missing required claims
+	// Case 4: Missing required claims
 	if appid == "" {
 		slog.Warn("azure: missing appid claim")
-
-		if !az.permissive {
-			return types.ErrAuthenticationFailed
-		}
-
-		slog.Debug("azure: treating token without appid as anonymous (permissive mode)")
-		return nil
+		return az.reject("token without appid")
 	}
 
-	// Case 4: Wrong tenant
+	// Case 5: Wrong tenant
 	if !strings.EqualFold(tid, az.tenant) {
 		slog.Warn("azure: tenant mismatch", "expected", az.tenant, "got", tid)
-
-		if !az.permissive {
-			return types.ErrAuthenticationFailed
-		}
-
-		slog.Debug("azure: treating wrong tenant as anonymous (permissive mode)")
-		return nil
+		return az.reject("wrong tenant")
 	}
 
-	// Case 5: Fetch app from Graph API
-	info.Request.ID = appid
-	user := getApp(appid, string(bearerToken))
-
-	if user == nil {
-		slog.Error("azure: failed to fetch app from Graph API", "appid", appid)
+	// Case 6: Enrich with app from Graph API (which verifies Graph tokens)
+	user, err := getApp(r.Context(), appid, string(bearerToken))
+	if errors.Is(err, types.ErrAuthenticationFailed) {
+		slog.Warn("azure: Graph API refused the token or app", "appid", appid, "error", err)
+		return az.reject("token refused by Graph")
+	}
+	if err != nil {
+		slog.Error("azure: failed to fetch app from Graph API", "appid", appid, "error", err)
 
-		// Always fail on Graph API errors (system unavailable)
+		// Always fail when Graph is unreachable (system unavailable)
 		// Don't fail open even in permissive mode
 		return types.ErrAuthenticationUnavailable
 	}
 
+	info.Request.Principal = appid
 	info.User = user
 	slog.Info("azure: authentication successful", "appid", appid, "tenant", tid)
 	return nil
 }
+
+// parse returns the token once it is fit to pass on to Graph. Tokens for one of
+// the audiences are verified against the tenant keys. Graph tokens, accepted
+// with graphTokens, are only checked for expiry here: Graph rejects forgeries.
+func (az *AzureAuthProvider) parse(ctx context.Context, bearerToken []byte) (jwt.Token, error) {
+	if az.graphTokens {
+		token, err := jwt.Parse(bearerToken, jwt.WithVerify(false), jwt.WithValidate(true))
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(token.Audience(), isGraphAudience) {
+			return token, nil
+		}
+	}
+
+	ks, err := az.keys.KeySet(ctx)
+	if err != nil {
+		// Without keys nothing can be verified - fail closed even in permissive mode
+		slog.Error("azure: failed to fetch tenant signing keys", "error", err)
+		return nil, types.ErrAuthenticationUnavailable
+	}
+
+	token, err := jwt.Parse(bearerToken,
+		jwt.WithKeySet(ks),
+		jwt.WithVerify(true),
+		jwt.WithValidate(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(token.Audience(), func(aud string) bool { return slices.Contains(az.audiences, aud) }) {
+		return nil, fmt.Errorf("audience mismatch: expected %v, got %v", az.audiences, token.Audience())
+	}
+	return token, nil
+}
+
+func isGraphAudience(aud string) bool {
+	return slices.Contains(graphAudiences, aud)
+}
+
+// reject returns ErrAuthenticationFailed in strict mode, or nil (anonymous) in permissive mode.
+func (az *AzureAuthProvider) reject(reason string) error {
+	if !az.permissive {
+		return types.ErrAuthenticationFailed
+	}
+	slog.Debug(fmt.Sprintf("azure: treating %s as anonymous (permissive mode)", reason))
+	return nil
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (az *AzureAuthProvider) Name() string {
+	return "azure"
+}
@@ -0,0 +1,358 @@
+package azure
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/go-resthelp"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	testTenant   = "11111111-2222-3333-4444-555555555555"
+	testAudience = "api://rest-rego"
+)
+
+// testIdP is a local stand-in for the tenant's OIDC metadata, JWKS and Graph.
+type testIdP struct {
+	server      *httptest.Server
+	key         jwk.Key
+	issuer      string
+	graphStatus int // status Graph answers with, if not 200
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	key, err := jwk.FromRaw(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create private JWK: %v", err)
+	}
+	_ = key.Set(jwk.KeyIDKey, "test-key")
+	_ = key.Set(jwk.AlgorithmKey, jwa.RS256)
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to derive public JWK: %v", err)
+	}
+	set := jwk.NewSet()
+	_ = set.AddKey(publicKey)
+
+	idp := &testIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":   idp.issuer,
+			"jwks_uri": idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Graph: servicePrincipals(appId='...')
+		if idp.graphStatus != 0 {
+			w.WriteHeader(idp.graphStatus)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"code": "InvalidAuthenticationToken"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"@odata.context": "ignored",
+			"appId":          "test-app",
+			"displayName":    "Test App",
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	idp.issuer = "https://login.microsoftonline.com/" + testTenant + "/v2.0"
+	t.Cleanup(idp.server.Close)
+
+	oldBase := base
+	base = resthelp.New(resthelp.WithBaseURL(idp.server.URL))
+	t.Cleanup(func() { base = oldBase })
+
+	return idp
+}
+
+func (idp *testIdP) provider(t *testing.T, permissive bool) *AzureAuthProvider {
+	t.Helper()
+	return idp.newProvider(t, []string{testAudience}, false, permissive)
+}
+
+func (idp *testIdP) newProvider(t *testing.T, audiences []string, graphTokens, permissive bool) *AzureAuthProvider {
+	t.Helper()
+	az := newProvider(testTenant, "Authorization", idp.server.URL+"/.well-known/openid-configuration", audiences, graphTokens, permissive)
+	if az == nil {
+		t.Fatal("Expected provider to be created")
+	}
+	return az
+}
+
+func (idp *testIdP) sign(t *testing.T, key jwk.Key, mutate func(jwt.Token)) string {
+	t.Helper()
+	token := jwt.New()
+	_ = token.Set(jwt.IssuerKey, idp.issuer)
+	_ = token.Set(jwt.AudienceKey, testAudience)
+	_ = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	_ = token.Set("appid", "test-app")
+	_ = token.Set("tid", testTenant)
+	if mutate != nil {
+		mutate(token)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return string(signed)
+}
+
+func authenticate(az *AzureAuthProvider, token string) (*types.Info, error) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	info := &types.Info{}
+	return info, az.Authenticate(info, r)
+}
+
+func TestAuthenticate_ValidToken(t *testing.T) {
+	idp := newTestIdP(t)
+	az := idp.provider(t, false)
+
+	info, err := authenticate(az, idp.sign(t, idp.key, nil))
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	user, ok := info.User.(map[string]string)
+	if !ok {
+		t.Fatalf("Expected user map, got %T", info.User)
+	}
+	if user["displayName"] != "Test App" {
+		t.Errorf("Expected displayName from Graph, got %q", user["displayName"])
+	}
+	if _, found := user["@odata.context"]; found {
+		t.Error("Expected @-prefixed Graph fields to be removed")
+	}
+	if info.Request.Principal != "test-app" {
+		t.Errorf("Expected principal to be the appid, got %q", info.Request.Principal)
+	}
+	if info.Request.ID != "" {
+		t.Errorf("Expected request id to be left alone, got %q", info.Request.ID)
+	}
+}
+
+func TestAuthenticate_V1Issuer(t *testing.T) {
+	idp := newTestIdP(t)
+	az := idp.provider(t, false)
+
+	token := idp.sign(t, idp.key, func(tok jwt.Token) {
+		_ = tok.Set(jwt.IssuerKey, "https://sts.windows.net/"+testTenant+"/")
+	})
+	if _, err := authenticate(az, token); err != nil {
+		t.Fatalf("Expected v1 issuer to be accepted, got %v", err)
+	}
+}
+
+func TestAuthenticate_Rejected(t *testing.T) {
+	idp := newTestIdP(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	forged, _ := jwk.FromRaw(otherKey)
+	_ = forged.Set(jwk.KeyIDKey, "test-key")
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{"malformed", "not-a-jwt"},
+		{"forged signature", idp.sign(t, forged, nil)},
+		{"expired", idp.sign(t, idp.key, func(tok jwt.Token) {
+			_ = tok.Set(jwt.ExpirationKey, time.Now().Add(-time.Hour).Unix())
+		})},
+		{"wrong issuer", idp.sign(t, idp.key, func(tok jwt.Token) {
+			_ = tok.Set(jwt.IssuerKey, "https://evil.example.com")
+		})},
+		{"wrong audience", idp.sign(t, idp.key, func(tok jwt.Token) {
+			_ = tok.Set(jwt.AudienceKey, "api://someone-else")
+		})},
+		{"missing appid", idp.sign(t, idp.key, func(tok jwt.Token) {
+			_ = tok.Remove("appid")
+		})},
+		{"wrong tenant", idp.sign(t, idp.key, func(tok jwt.Token) {
+			_ = tok.Set("tid", "other-tenant")
+		})},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := authenticate(idp.provider(t, false), tc.token)
+			if !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("strict: expected ErrAuthenticationFailed, got %v", err)
+			}
+			if info.User != nil {
+				t.Error("strict: expected no user on failure")
+			}
+
+			info, err = authenticate(idp.provider(t, true), tc.token)
+			if err != nil {
+				t.Errorf("permissive: expected anonymous, got %v", err)
+			}
+			if info.User != nil {
+				t.Error("permissive: expected no user on failure")
+			}
+		})
+	}
+}
+
+// graphToken mimics a Microsoft Graph token: the nonce in its header is not the
+// one it was signed with, so its signature never verifies outside of Graph.
+func (idp *testIdP) graphToken(t *testing.T, mutate func(jwt.Token)) string {
+	t.Helper()
+	token := jwt.New()
+	_ = token.Set(jwt.IssuerKey, "https://sts.windows.net/"+testTenant+"/")
+	_ = token.Set(jwt.AudienceKey, "00000003-0000-0000-c000-000000000000")
+	_ = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	_ = token.Set("appid", "test-app")
+	_ = token.Set("tid", testTenant)
+	if mutate != nil {
+		mutate(token)
+	}
+
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set("nonce", "hashed-nonce")
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, idp.key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	parts := strings.Split(string(signed), ".")
+	header := map[string]any{}
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	_ = json.Unmarshal(raw, &header)
+	header["nonce"] = "raw-nonce"
+	raw, _ = json.Marshal(header)
+	parts[0] = base64.RawURLEncoding.EncodeToString(raw)
+	return strings.Join(parts, ".")
+}
+
+func TestAuthenticate_GraphToken(t *testing.T) {
+	idp := newTestIdP(t)
+	token := idp.graphToken(t, nil)
+
+	publicKey, _ := idp.key.PublicKey()
+	if _, err := jws.Verify([]byte(token), jws.WithKey(jwa.RS256, publicKey)); err == nil {
+		t.Fatal("Expected the Graph token signature not to verify with the tenant key")
+	}
+
+	// Without AZURE_GRAPH_TOKENS the token is verified locally, and fails
+	if _, err := authenticate(idp.provider(t, false), token); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected Graph token to fail local verification, got %v", err)
+	}
+
+	az := idp.newProvider(t, []string{testAudience}, true, false)
+	info, err := authenticate(az, token)
+	if err != nil {
+		t.Fatalf("Expected Graph token to be accepted with graph tokens, got %v", err)
+	}
+	if info.Request.Principal != "test-app" {
+		t.Errorf("Expected principal to be the appid, got %q", info.Request.Principal)
+	}
+
+	// Claims are still checked before the token is passed on to Graph
+	expired := idp.graphToken(t, func(tok jwt.Token) {
+		_ = tok.Set(jwt.ExpirationKey, time.Now().Add(-time.Hour).Unix())
+	})
+	wrongTenant := idp.graphToken(t, func(tok jwt.Token) {
+		_ = tok.Set("tid", "other-tenant")
+	})
+	for _, token := range []string{expired, wrongTenant} {
+		if _, err := authenticate(az, token); !errors.Is(err, types.ErrAuthenticationFailed) {
+			t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+		}
+	}
+
+	// Other tokens are still verified locally
+	if _, err := authenticate(az, idp.sign(t, idp.key, nil)); err != nil {
+		t.Errorf("Expected API token to be accepted, got %v", err)
+	}
+}
+
+func TestNewProvider_Audiences(t *testing.T) {
+	idp := newTestIdP(t)
+	wellKnown := idp.server.URL + "/.well-known/openid-configuration"
+
+	if az := newProvider(testTenant, "Authorization", wellKnown, nil, false, false); az != nil {
+		t.Error("Expected nil provider without audiences or graph tokens")
+	}
+	if az := newProvider(testTenant, "Authorization", wellKnown, []string{"https://graph.microsoft.com"}, false, false); az != nil {
+		t.Error("Expected nil provider with a Graph audience")
+	}
+	if az := newProvider(testTenant, "Authorization", wellKnown, nil, true, false); az == nil {
+		t.Error("Expected provider with graph tokens only")
+	}
+}
+
+func TestAuthenticate_NoToken_Anonymous(t *testing.T) {
+	idp := newTestIdP(t)
+	az := idp.provider(t, false)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	info := &types.Info{}
+	if err := az.Authenticate(info, r); err != nil {
+		t.Errorf("Expected anonymous, got %v", err)
+	}
+}
+
+func TestNewProvider_MetadataUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if az := newProvider(testTenant, "Authorization", server.URL+"/.well-known/openid-configuration", []string{testAudience}, false, false); az != nil {
+		t.Error("Expected nil provider when metadata cannot be loaded")
+	}
+}
+
+func TestAuthenticate_GraphRefusal(t *testing.T) {
+	idp := newTestIdP(t)
+	az := idp.newProvider(t, nil, true, false)
+
+	testCases := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, types.ErrAuthenticationFailed},
+		{http.StatusForbidden, types.ErrAuthenticationFailed},
+		{http.StatusNotFound, types.ErrAuthenticationFailed},
+		{http.StatusServiceUnavailable, types.ErrAuthenticationUnavailable},
+	}
+	for _, tc := range testCases {
+		idp.graphStatus = tc.status
+		// Graph tokens are not verified locally, so only Graph can refuse them
+		info, err := authenticate(az, idp.graphToken(t, func(tok jwt.Token) {
+			_ = tok.Set(jwt.JwtIDKey, http.StatusText(tc.status))
+		}))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("Graph %d: expected %v, got %v", tc.status, tc.want, err)
+		}
+		if info.User != nil || info.Request.Principal != "" {
+			t.Errorf("Graph %d: expected no identity, got %v %q", tc.status, info.User, info.Request.Principal)
+		}
+	}
+}
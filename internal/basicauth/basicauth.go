@@ -68,6 +68,17 @@ func New(filePath string, permissive bool) *BasicAuthProvider {
 	return b
 }
 
+// Close stops watching the credentials file and releases the cache.
+func (b *BasicAuthProvider) Close() error {
+	if b.cache != nil {
+		b.cache.Close()
+	}
+	if b.watcher == nil {
+		return nil
+	}
+	return b.watcher.Close()
+}
+
 // Authenticate implements types.AuthProvider.
 // Missing or non-Basic Authorization header → anonymous (nil error).
 // Wrong password → always ErrAuthenticationFailed, even in permissive mode.
@@ -97,6 +108,7 @@ func (b *BasicAuthProvider) Authenticate(info *types.Info, _ *http.Request) erro
 
 	// Set authenticated user info for logging and policy evaluation
 	info.User = auth.User
+	info.Request.Principal = auth.User
 
 	return nil
 }
@@ -113,3 +125,8 @@ func handleFailure(permissive bool) error {
 	}
 	return types.ErrAuthenticationFailed
 }
+
+// Name implements the optional types.AuthNamer interface.
+func (b *BasicAuthProvider) Name() string {
+	return "basic"
+}
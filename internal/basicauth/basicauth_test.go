@@ -91,6 +91,9 @@ func TestAuthenticate_ValidCredentials_SuccessAndPasswordCleared(t *testing.T) {
 	if info.Request.Auth.Password != "" {
 		t.Errorf("expected password to be cleared after success, got %q", info.Request.Auth.Password)
 	}
+	if info.Request.Principal != "alice" {
+		t.Errorf("expected principal alice, got %q", info.Request.Principal)
+	}
 }
 
 func TestAuthenticate_WrongPassword_FailsEvenWhenPermissive(t *testing.T) {
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
 	"github.com/AB-Lindex/rest-rego/internal/types"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -111,7 +112,9 @@ func (b *BasicAuthProvider) createCacheKey(user, password string) uint64 {
 func (b *BasicAuthProvider) verifyPassword(user, hash, password string) error {
 	cacheKey := b.createCacheKey(user, password)
 	if b.cache != nil {
-		if valid, found := b.cache.Get(cacheKey); found {
+		valid, found := b.cache.Get(cacheKey)
+		metrics.IncrementBasicAuthCache(found)
+		if found {
 			// Cache hit for invalid credentials, treat as authentication failure.
 			if !valid {
 				return types.ErrAuthenticationFailed
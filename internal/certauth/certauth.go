@@ -0,0 +1,55 @@
+package certauth
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// CertAuthProvider authenticates requests by the client certificate verified
+// on the TLS listener (mTLS), e.g. SPIFFE workload certificates in a mesh.
+type CertAuthProvider struct {
+	permissive bool
+}
+
+// New creates a CertAuthProvider. The listener must verify client
+// certificates (TLS_CLIENT_CA_FILE), which is checked by the configuration.
+func New(permissive bool) *CertAuthProvider {
+	slog.Info("certauth: authenticating by verified client certificate")
+	return &CertAuthProvider{permissive: permissive}
+}
+
+// Authenticate implements types.AuthProvider.
+// Verified certificate → info.User is the certificate identity.
+// No certificate, or one that was not verified → ErrAuthenticationFailed
+// in strict mode, anonymous in permissive mode.
+func (c *CertAuthProvider) Authenticate(info *types.Info, r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			slog.Debug("certauth: client certificate not verified", "subject", r.TLS.PeerCertificates[0].Subject.String())
+		}
+		return handleFailure(c.permissive)
+	}
+
+	cert := types.NewClientCert(r.TLS.VerifiedChains[0][0])
+	info.User = cert
+	info.Request.Principal = cert.SPIFFEID
+	if info.Request.Principal == "" {
+		info.Request.Principal = cert.Subject
+	}
+	return nil
+}
+
+// handleFailure returns nil in permissive mode, ErrAuthenticationFailed in strict mode.
+func handleFailure(permissive bool) error {
+	if permissive {
+		return nil
+	}
+	return types.ErrAuthenticationFailed
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (c *CertAuthProvider) Name() string {
+	return "mtls"
+}
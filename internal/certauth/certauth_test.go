@@ -0,0 +1,127 @@
+package certauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+// newCert returns a self-signed certificate with the given URI SANs
+func newCert(t *testing.T, cn string, uris ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn + ".svc"},
+	}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// tlsRequest returns a request whose connection presented cert
+func tlsRequest(cert *x509.Certificate, verified bool) *http.Request {
+	cs := &tls.ConnectionState{HandshakeComplete: true}
+	if cert != nil {
+		cs.PeerCertificates = []*x509.Certificate{cert}
+		if verified {
+			cs.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+	}
+	return &http.Request{TLS: cs}
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_VerifiedSPIFFE(t *testing.T) {
+	provider := New(false)
+	info := &types.Info{}
+
+	err := provider.Authenticate(info, tlsRequest(newCert(t, "frontend", "spiffe://cluster.local/ns/shop/sa/frontend"), true))
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+
+	user, ok := info.User.(*types.ClientCert)
+	if !ok {
+		t.Fatalf("Expected client certificate identity, got %T", info.User)
+	}
+	if user.SPIFFEID != "spiffe://cluster.local/ns/shop/sa/frontend" || user.Serial != "42" || user.DNSNames[0] != "frontend.svc" {
+		t.Errorf("Unexpected identity %+v", user)
+	}
+	if info.Request.Principal != "spiffe://cluster.local/ns/shop/sa/frontend" {
+		t.Errorf("Expected SPIFFE id as principal, got %q", info.Request.Principal)
+	}
+}
+
+func TestAuthenticate_VerifiedWithoutSPIFFE(t *testing.T) {
+	provider := New(false)
+	info := &types.Info{}
+
+	if err := provider.Authenticate(info, tlsRequest(newCert(t, "batch-job"), true)); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if info.Request.Principal != "CN=batch-job" {
+		t.Errorf("Expected subject as principal, got %q", info.Request.Principal)
+	}
+}
+
+func TestAuthenticate_Failures(t *testing.T) {
+	cert := newCert(t, "stranger")
+	testCases := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"plain http", &http.Request{}},
+		{"no client certificate", tlsRequest(nil, false)},
+		{"unverified certificate", tlsRequest(cert, false)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" strict", func(t *testing.T) {
+			info := &types.Info{}
+			err := New(false).Authenticate(info, tc.req)
+			if !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+			}
+			if info.User != nil {
+				t.Errorf("Expected no user, got %v", info.User)
+			}
+		})
+		t.Run(tc.name+" permissive", func(t *testing.T) {
+			info := &types.Info{}
+			if err := New(true).Authenticate(info, tc.req); err != nil {
+				t.Errorf("Expected anonymous, got %v", err)
+			}
+			if info.User != nil || info.Request.Principal != "" {
+				t.Errorf("Expected anonymous, got %v %q", info.User, info.Request.Principal)
+			}
+		})
+	}
+}
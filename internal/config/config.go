@@ -2,8 +2,10 @@ package config
 
 import (
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/AB-Lindex/rest-rego/internal/types"
@@ -21,22 +23,97 @@ type Fields struct {
 	ListenAddr           string   `arg:"-l,--listen,env:LISTEN_ADDR" default:":8181" help:"port for to listen on for proxy" placeholder:"ADDR"`
 	MgmtAddr             string   `arg:"-m,--management,env:MGMT_ADDR" default:":8182" help:"port to listen on for management (probes)" placeholder:"ADDR"`
 	AzureTenant          string   `arg:"-t,--azure-tenant,env:AZURE_TENANT" help:"azure tenant id" placeholder:"ID"`
+	AzureGraphTokens     bool     `arg:"--azure-graph-tokens,env:AZURE_GRAPH_TOKENS" default:"false" help:"azure: accept Microsoft Graph tokens, which cannot be verified locally and are verified by Graph itself"`
 	AuthHeader           string   `arg:"-a,--auth-header,env:AUTH_HEADER" default:"Authorization" placeholder:"HEADER"`
 	AuthKind             string   `arg:"-k,--auth-kind,env:AUTH_KIND" default:"bearer" placeholder:"KIND"`
 	BackendScheme        string   `arg:"-s,--backend-scheme,env:BACKEND_SCHEME" default:"http" help:"scheme for backend" placeholder:"SCHEME"`
 	BackendHost          string   `arg:"-h,--backend-host,env:BACKEND_HOST" default:"localhost" help:"host for backend" placeholder:"HOST"`
 	BackendPort          int      `arg:"-p,--backend-port,env:BACKEND_PORT" default:"8080" help:"port for backend" placeholder:"PORT"`
 	WellKnownURL         []string `arg:"-w,--well-known,env:WELLKNOWN_OIDC" help:"well-known URL for JWK verifications" placeholder:"URL"`
-	Audiences            []string `arg:"-u,--audience,env:JWT_AUDIENCES" help:"audience for JWT verification" placeholder:"AUDIENCE"`
+	Audiences            []string `arg:"-u,--audience,env:JWT_AUDIENCES" help:"audience for JWT verification (azure: your API's audience, required unless AZURE_GRAPH_TOKENS is set)" placeholder:"AUDIENCE"`
 	AudienceKey          string   `arg:"--audience-key,env:JWT_AUDIENCE_KEY" default:"aud" help:"claim key to use for audience check" placeholder:"KEY"`
 	PermissiveAuth       bool     `arg:"--permissive-auth,env:PERMISSIVE_AUTH" default:"false" help:"allow invalid tokens to be treated as anonymous (default: false, strict mode)"`
 	BasicAuthFile        string   `arg:"--basic-auth-file,env:BASIC_AUTH_FILE" help:"path to Apache 2.4 htpasswd file (bcrypt only)" placeholder:"FILE"`
+	APIKeyFile           string   `arg:"--api-key-file,env:API_KEY_FILE" help:"path to a YAML file of hashed API keys (sha256 or argon2id)" placeholder:"FILE"`
+	APIKeyHeader         string   `arg:"--api-key-header,env:API_KEY_HEADER" default:"X-Api-Key" help:"header carrying the API key" placeholder:"HEADER"`
+	HMACSecretsFile      string   `arg:"--hmac-secrets-file,env:HMAC_SECRETS_FILE" help:"path to a YAML file of client secrets for HMAC-SHA256 signed requests" placeholder:"FILE"`
+	TokenReview          bool     `arg:"--token-review,env:TOKEN_REVIEW" default:"false" help:"authenticate Kubernetes service-account tokens with the TokenReview API"`
+	IntrospectionURL     string   `arg:"--introspection-url,env:INTROSPECTION_URL" help:"OAuth2 token introspection endpoint (RFC 7662) for opaque bearer tokens" placeholder:"URL"`
+	ClientCertAuth       bool     `arg:"--client-cert-auth,env:CLIENT_CERT_AUTH" default:"false" help:"authenticate by the client certificate verified on the TLS listener (requires TLS_CLIENT_CA_FILE)"`
 	NoAuth               bool     `arg:"--no-auth,env:NO_AUTH" default:"false" help:"disable authentication — policy is the sole access control (requires explicit opt-in)"`
 	ExposeBlockedHeaders bool     `arg:"--expose-blocked-headers,env:EXPOSE_BLOCKED_HEADERS" default:"false" help:"expose X-Restrego-* headers to policy as blocked_headers (security: headers still removed from backend)"`
 	EnvsubstPrefix       string   `arg:"--envsubst-prefix,env:ENVSUBST_PREFIX" default:"$" help:"prefix character for env var expansion in policies (one of: $ % & #)" placeholder:"CHAR"`
 	EnvsubstWrapper      string   `arg:"--envsubst-wrapper,env:ENVSUBST_WRAPPER" default:"{" help:"wrapper character for env var expansion in policies (one of: { ( [ <)" placeholder:"CHAR"`
+	RequestIDHeader      string   `arg:"--request-id-header,env:REQUEST_ID_HEADER" default:"X-Request-Id" help:"header carrying the request id (accepted if valid, otherwise generated)" placeholder:"HEADER"`
+	TrustedProxies       []string `arg:"--trusted-proxy,env:TRUSTED_PROXIES" help:"CIDR or IP of a proxy whose X-Forwarded-For/Forwarded headers are trusted" placeholder:"CIDR"`
+	ClientIPHeader       string   `arg:"--client-ip-header,env:CLIENT_IP_HEADER" default:"x-forwarded-for" help:"header the trusted proxies maintain to give the client IP: x-forwarded-for or forwarded (the other is removed)" placeholder:"HEADER"`
+	ForwardAuth          bool     `arg:"--forward-auth,env:FORWARD_AUTH" default:"false" help:"answer ingress authorization requests (NGINX auth_request, Traefik ForwardAuth, Envoy ext_authz) instead of proxying to a backend"`
+	ForwardAuthPrefix    string   `arg:"--forward-auth-prefix,env:FORWARD_AUTH_PREFIX" help:"path prefix to strip in forward-auth mode when the original URI is not in a header (Envoy ext_authz path_prefix)" placeholder:"PREFIX"`
+	ExtAuthzAddr         string   `arg:"--extauthz-addr,env:EXTAUTHZ_ADDR" help:"address for the Envoy ext_authz gRPC server, e.g. :9191 (disabled if empty)" placeholder:"ADDR"`
+	ExtAuthzTLS          bool     `arg:"--extauthz-tls,env:EXTAUTHZ_TLS" default:"false" help:"serve the ext_authz gRPC server over TLS with the proxy listener's certificate and client CA (requires TLS_CERT_FILE)"`
+	ProblemJSON          bool     `arg:"--problem-json,env:PROBLEM_JSON" default:"false" help:"render rest-rego errors as RFC 7807 application/problem+json when the client accepts JSON"`
 	URLMetricsLevel      int      `arg:"--url-metrics-level,env:URL_METRICS_LEVEL" default:"0" help:"level of URL detail to include in metrics (<0=full path, 0=none, >0=up to N segments)"`
 
+	// Remote policy bundle (replaces POLICY_DIR when set)
+	BundleURL          string        `arg:"--bundle-url,env:BUNDLE_URL" help:"download policies as an OPA bundle (.tar.gz) from URL instead of reading POLICY_DIR" placeholder:"URL"`
+	BundlePollInterval time.Duration `arg:"--bundle-poll-interval,env:BUNDLE_POLL_INTERVAL" default:"60s" help:"how often to poll the bundle URL for changes"`
+	BundlePublicKey    string        `arg:"--bundle-public-key,env:BUNDLE_PUBLIC_KEY" help:"PEM file with the public key (or HMAC secret) to verify bundle signatures" placeholder:"FILE"`
+	BundleKeyID        string        `arg:"--bundle-key-id,env:BUNDLE_KEY_ID" default:"default" help:"key id the bundle must be signed with" placeholder:"ID"`
+	BundleSigningAlg   string        `arg:"--bundle-signing-alg,env:BUNDLE_SIGNING_ALG" default:"RS256" help:"algorithm of the bundle signing key" placeholder:"ALG"`
+	BundleMaxSize      int64         `arg:"--bundle-max-size,env:BUNDLE_MAX_SIZE" default:"104857600" help:"max size in bytes of the bundle tarball and of each file in it"`
+
+	// Decision log (any combination of sinks may be enabled)
+	DecisionLogStdout        bool          `arg:"--decision-log-stdout,env:DECISION_LOG_STDOUT" default:"false" help:"write decision logs as JSON lines to stdout"`
+	DecisionLogFile          string        `arg:"--decision-log-file,env:DECISION_LOG_FILE" help:"write decision logs as JSON lines to FILE (rotated by size)" placeholder:"FILE"`
+	DecisionLogFileMaxSize   int           `arg:"--decision-log-file-max-size,env:DECISION_LOG_FILE_MAX_SIZE" default:"100" help:"size in MB at which the decision log file is rotated"`
+	DecisionLogFileBackups   int           `arg:"--decision-log-file-backups,env:DECISION_LOG_FILE_BACKUPS" default:"3" help:"number of rotated decision log files to keep"`
+	DecisionLogURL           string        `arg:"--decision-log-url,env:DECISION_LOG_URL" help:"POST decision logs in batches to a webhook URL" placeholder:"URL"`
+	DecisionLogBatchSize     int           `arg:"--decision-log-batch-size,env:DECISION_LOG_BATCH_SIZE" default:"100" help:"max decision log events per write"`
+	DecisionLogFlushInterval time.Duration `arg:"--decision-log-flush-interval,env:DECISION_LOG_FLUSH_INTERVAL" default:"5s" help:"max time a decision log event waits before being written"`
+	DecisionLogBufferSize    int           `arg:"--decision-log-buffer-size,env:DECISION_LOG_BUFFER_SIZE" default:"10000" help:"decision log events buffered per sink before new events are dropped"`
+
+	// Masking of decision logs and debug output (JSON pointers into the policy input)
+	LogMask     []string `arg:"--log-mask,env:LOG_MASK" help:"JSON pointer of an input field to remove before logging, in addition to the credentials, e.g. /request/headers/X-Session-Id" placeholder:"POINTER"`
+	LogMaskHash []string `arg:"--log-mask-hash,env:LOG_MASK_HASH" help:"JSON pointer of an input field to replace with its SHA-256 hash before logging" placeholder:"POINTER"`
+
+	// OpenTelemetry tracing (disabled unless an endpoint is set)
+	OTLPEndpoint     string  `arg:"--otlp-endpoint,env:OTEL_EXPORTER_OTLP_ENDPOINT" help:"OTLP collector URL to export traces to, e.g. http://otel-collector:4318" placeholder:"URL"`
+	OTLPProtocol     string  `arg:"--otlp-protocol,env:OTEL_EXPORTER_OTLP_PROTOCOL" default:"http/protobuf" help:"OTLP protocol (http/protobuf or grpc)" placeholder:"PROTOCOL"`
+	TraceSampleRatio float64 `arg:"--trace-sample-ratio,env:TRACE_SAMPLE_RATIO" default:"1" help:"ratio of new traces to sample (0-1), sampled incoming traces are always continued" placeholder:"RATIO"`
+
+	// JWT validation (used when WELLKNOWN_OIDC is set)
+	JWTRequiredClaims []string      `arg:"--jwt-required-claim,env:JWT_REQUIRED_CLAIMS" help:"claim tokens must have, as CLAIM or CLAIM=VALUE" placeholder:"CLAIM"`
+	JWTClockSkew      time.Duration `arg:"--jwt-clock-skew,env:JWT_CLOCK_SKEW" default:"0s" help:"leeway for the exp, nbf and iat claims"`
+	JWTMaxTokenAge    time.Duration `arg:"--jwt-max-token-age,env:JWT_MAX_TOKEN_AGE" default:"0s" help:"max time since a token's iat (0 = no limit)"`
+	JWTCacheTTL       time.Duration `arg:"--jwt-cache-ttl,env:JWT_CACHE_TTL" default:"5m" help:"max time a validated token is cached (never beyond its exp, 0 disables)"`
+
+	// OAuth2 token introspection (used when INTROSPECTION_URL is set)
+	IntrospectionClientID     string        `arg:"--introspection-client-id,env:INTROSPECTION_CLIENT_ID" help:"client id to authenticate to the introspection endpoint" placeholder:"ID"`
+	IntrospectionClientSecret string        `arg:"--introspection-client-secret,env:INTROSPECTION_CLIENT_SECRET" help:"client secret to authenticate to the introspection endpoint" placeholder:"SECRET"`
+	IntrospectionCacheTTL     time.Duration `arg:"--introspection-cache-ttl,env:INTROSPECTION_CACHE_TTL" default:"5m" help:"max time an active token is cached (never beyond its exp)"`
+	IntrospectionNegativeTTL  time.Duration `arg:"--introspection-negative-ttl,env:INTROSPECTION_NEGATIVE_TTL" default:"10s" help:"time an inactive token is cached (0 disables)"`
+	IntrospectionTimeout      time.Duration `arg:"--introspection-timeout,env:INTROSPECTION_TIMEOUT" default:"5s" help:"timeout for introspection requests"`
+
+	// HMAC request signing (used when HMAC_SECRETS_FILE is set)
+	HMACSignedHeaders []string      `arg:"--hmac-signed-header,env:HMAC_SIGNED_HEADERS" help:"header every signature must cover (default: host)" placeholder:"HEADER"`
+	HMACMaxSkew       time.Duration `arg:"--hmac-max-skew,env:HMAC_MAX_SKEW" default:"5m" help:"max age of a signature timestamp, and how long nonces are remembered"`
+	HMACMaxBodySize   int64         `arg:"--hmac-max-body-size,env:HMAC_MAX_BODY_SIZE" default:"1048576" help:"max body size in bytes of signed requests (the body is buffered to verify it)"`
+
+	// Kubernetes TokenReview (used when TOKEN_REVIEW is set)
+	Kubeconfig             string        `arg:"--kubeconfig,env:KUBECONFIG" help:"kubeconfig to reach the Kubernetes API server (default: in-cluster config)" placeholder:"FILE"`
+	TokenReviewAudiences   []string      `arg:"--token-review-audience,env:TOKEN_REVIEW_AUDIENCES" help:"audience service-account tokens must be issued for (default: the API server's)" placeholder:"AUDIENCE"`
+	TokenReviewCacheTTL    time.Duration `arg:"--token-review-cache-ttl,env:TOKEN_REVIEW_CACHE_TTL" default:"5m" help:"max time a positive review is cached (never beyond the token's exp)"`
+	TokenReviewNegativeTTL time.Duration `arg:"--token-review-negative-ttl,env:TOKEN_REVIEW_NEGATIVE_TTL" default:"10s" help:"time a negative review is cached (0 disables)"`
+	TokenReviewTimeout     time.Duration `arg:"--token-review-timeout,env:TOKEN_REVIEW_TIMEOUT" default:"5s" help:"timeout for TokenReview requests"`
+
+	// TLS on the proxy listener (plain HTTP unless a certificate is set)
+	TLSCertFile     string   `arg:"--tls-cert-file,env:TLS_CERT_FILE" help:"PEM certificate (chain) for the proxy listener, reloaded on change" placeholder:"FILE"`
+	TLSKeyFile      string   `arg:"--tls-key-file,env:TLS_KEY_FILE" help:"PEM private key for the proxy listener, reloaded on change" placeholder:"FILE"`
+	TLSClientCAFile string   `arg:"--tls-client-ca-file,env:TLS_CLIENT_CA_FILE" help:"PEM CA bundle to verify client certificates against (enables mTLS)" placeholder:"FILE"`
+	TLSClientAuth   string   `arg:"--tls-client-auth,env:TLS_CLIENT_AUTH" default:"require" help:"with a client CA: require a client certificate, or verify it only if given (require or optional)" placeholder:"MODE"`
+	TLSMinVersion   string   `arg:"--tls-min-version,env:TLS_MIN_VERSION" default:"1.2" help:"minimum TLS version (1.2 or 1.3)" placeholder:"VERSION"`
+	TLSCipherSuites []string `arg:"--tls-cipher-suite,env:TLS_CIPHER_SUITES" help:"allowed TLS 1.2 cipher suite, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (default: Go's secure defaults)" placeholder:"NAME"`
+
 	// Timeout configuration for proxy server
 	ReadHeaderTimeout time.Duration `arg:"--read-header-timeout,env:READ_HEADER_TIMEOUT" default:"10s" help:"timeout for reading request headers"`
 	ReadTimeout       time.Duration `arg:"--read-timeout,env:READ_TIMEOUT" default:"30s" help:"timeout for reading entire request"`
@@ -53,6 +130,11 @@ func (f *Fields) Version() string {
 	return types.Version()
 }
 
+// DecisionLogEnabled reports if at least one decision log sink is configured.
+func (f *Fields) DecisionLogEnabled() bool {
+	return f.DecisionLogStdout || f.DecisionLogFile != "" || f.DecisionLogURL != ""
+}
+
 // validateEnvsubst validates the envsubst prefix and wrapper characters.
 func (f *Fields) validateEnvsubst() {
 	if !envsubst.SetPrefix(f.EnvsubstPrefixRune()) {
@@ -151,23 +233,77 @@ func New() *Fields {
 	if f.BasicAuthFile != "" {
 		authCount++
 	}
-	if f.NoAuth {
+	if f.APIKeyFile != "" {
+		authCount++
+	}
+	if f.HMACSecretsFile != "" {
+		authCount++
+	}
+	if f.IntrospectionURL != "" {
+		authCount++
+	}
+	if f.TokenReview {
+		authCount++
+	}
+	if f.ClientCertAuth {
 		authCount++
 	}
+	if f.NoAuth && authCount > 0 {
+		slog.Error("config: NO_AUTH cannot be combined with an auth-provider (AZURE_TENANT, WELLKNOWN_OIDC, BASIC_AUTH_FILE, API_KEY_FILE, HMAC_SECRETS_FILE, INTROSPECTION_URL, TOKEN_REVIEW, CLIENT_CERT_AUTH)")
+		os.Exit(1)
+	}
 	if authCount > 1 {
-		slog.Error("config: only one auth-provider may be configured (AZURE_TENANT, WELLKNOWN_OIDC, BASIC_AUTH_FILE) or using NO_AUTH mode")
+		slog.Info("config: multiple auth-providers configured, chaining them", "count", authCount)
+	}
+	if f.IntrospectionURL != "" {
+		if f.IntrospectionCacheTTL < 0 || f.IntrospectionNegativeTTL < 0 || f.IntrospectionTimeout <= 0 {
+			slog.Error("config: introspection cache ttls must not be negative and the timeout must be positive")
+			os.Exit(1)
+		}
+	}
+	if f.TokenReview {
+		if f.TokenReviewCacheTTL < 0 || f.TokenReviewNegativeTTL < 0 || f.TokenReviewTimeout <= 0 {
+			slog.Error("config: token-review cache ttls must not be negative and the timeout must be positive")
+			os.Exit(1)
+		}
+	}
+	if f.HMACSecretsFile != "" {
+		if f.HMACMaxSkew <= 0 || f.HMACMaxBodySize < 0 {
+			slog.Error("config: hmac-max-skew must be positive and hmac-max-body-size must not be negative")
+			os.Exit(1)
+		}
+		if len(f.HMACSignedHeaders) == 0 {
+			f.HMACSignedHeaders = []string{"host"}
+		}
+	}
+	if f.ClientCertAuth && f.TLSClientCAFile == "" {
+		slog.Error("config: client-cert-auth requires tls-client-ca-file to verify client certificates")
+		os.Exit(1)
+	}
+	if f.AzureTenant != "" && len(f.Audiences) == 0 && !f.AzureGraphTokens {
+		slog.Error("config: audiences must be provided when using azure-tenant, or azure-graph-tokens set to accept Microsoft Graph tokens")
 		os.Exit(1)
 	}
 	if len(f.WellKnownURL) > 0 && len(f.Audiences) == 0 {
 		slog.Error("config: audiences must be provided when using well-known")
 		os.Exit(1)
 	}
+	if f.JWTClockSkew < 0 || f.JWTMaxTokenAge < 0 || f.JWTCacheTTL < 0 {
+		slog.Error("config: jwt-clock-skew, jwt-max-token-age and jwt-cache-ttl must not be negative")
+		os.Exit(1)
+	}
 	if len(f.AuthHeader) == 0 {
 		slog.Error("config: auth-header must be provided")
 		os.Exit(1)
 	}
 	// need to make sure the auth-header is in proper canonical format
 	f.AuthHeader = http.CanonicalHeaderKey(f.AuthHeader)
+	f.RequestIDHeader = http.CanonicalHeaderKey(f.RequestIDHeader)
+	f.APIKeyHeader = http.CanonicalHeaderKey(f.APIKeyHeader)
+	if f.APIKeyFile != "" && (f.APIKeyHeader == "" || f.APIKeyHeader == f.AuthHeader) {
+		slog.Error("config: api-key-header must be set and differ from auth-header", "value", f.APIKeyHeader)
+		os.Exit(1)
+	}
 
 	// Log authentication mode
 	if f.PermissiveAuth {
@@ -176,6 +312,69 @@ func New() *Fields {
 		slog.Info("config: strict authentication mode - invalid tokens will be rejected")
 	}
 
+	f.ClientIPHeader = strings.ToLower(f.ClientIPHeader)
+	if f.ClientIPHeader != "x-forwarded-for" && f.ClientIPHeader != "forwarded" {
+		slog.Error("config: client-ip-header must be x-forwarded-for or forwarded", "value", f.ClientIPHeader)
+		os.Exit(1)
+	}
+
+	if f.BundleURL != "" && f.BundlePollInterval < time.Second {
+		slog.Error("config: bundle-poll-interval must be at least 1s", "value", f.BundlePollInterval)
+		os.Exit(1)
+	}
+	if f.BundleURL != "" && f.BundleMaxSize <= 0 {
+		slog.Error("config: bundle-max-size must be positive", "value", f.BundleMaxSize)
+		os.Exit(1)
+	}
+
+	if f.DecisionLogEnabled() {
+		if f.DecisionLogBatchSize < 1 || f.DecisionLogBufferSize < 1 || f.DecisionLogFlushInterval <= 0 {
+			slog.Error("config: decision-log batch-size, buffer-size and flush-interval must be positive")
+			os.Exit(1)
+		}
+		if f.DecisionLogFile != "" && f.DecisionLogFileBackups < 0 {
+			slog.Error("config: decision-log-file-backups must not be negative", "value", f.DecisionLogFileBackups)
+			os.Exit(1)
+		}
+	}
+
+	if f.OTLPEndpoint != "" {
+		if f.OTLPProtocol != "http/protobuf" && f.OTLPProtocol != "grpc" {
+			slog.Error("config: otlp-protocol must be http/protobuf or grpc", "value", f.OTLPProtocol)
+			os.Exit(1)
+		}
+		if f.TraceSampleRatio < 0 || f.TraceSampleRatio > 1 {
+			slog.Error("config: trace-sample-ratio must be between 0 and 1", "value", f.TraceSampleRatio)
+			os.Exit(1)
+		}
+	}
+
+	if (f.TLSCertFile == "") != (f.TLSKeyFile == "") {
+		slog.Error("config: tls-cert-file and tls-key-file must be set together")
+		os.Exit(1)
+	}
+	if f.ExtAuthzTLS && (f.ExtAuthzAddr == "" || f.TLSCertFile == "") {
+		slog.Error("config: extauthz-tls requires extauthz-addr and tls-cert-file")
+		os.Exit(1)
+	}
+	if host, _, _ := net.SplitHostPort(f.ExtAuthzAddr); f.ExtAuthzAddr != "" && !f.ExtAuthzTLS && (host == "" || host == "0.0.0.0" || host == "::") {
+		slog.Warn("config: ext_authz gRPC server without TLS, bind extauthz-addr to localhost or a pod-local address", "addr", f.ExtAuthzAddr)
+	}
+	if f.TLSClientCAFile != "" && f.TLSCertFile == "" {
+		slog.Error("config: tls-client-ca-file requires tls-cert-file and tls-key-file")
+		os.Exit(1)
+	}
+	if f.TLSCertFile != "" {
+		if f.TLSMinVersion != "1.2" && f.TLSMinVersion != "1.3" {
+			slog.Error("config: tls-min-version must be 1.2 or 1.3", "value", f.TLSMinVersion)
+			os.Exit(1)
+		}
+		if f.TLSClientAuth != "require" && f.TLSClientAuth != "optional" {
+			slog.Error("config: tls-client-auth must be require or optional", "value", f.TLSClientAuth)
+			os.Exit(1)
+		}
+	}
+
 	if f.URLMetricsLevel < 0 {
 		slog.Warn("config: url-metrics-level is negative — full request paths will be used as Prometheus url labels, which may cause unbounded cardinality")
 	}
@@ -0,0 +1,195 @@
+// Package decisionlog ships an OPA-compatible record of every policy decision
+// to one or more sinks. Events are queued in a bounded buffer per sink and
+// written asynchronously, so a slow sink never delays requests; when a buffer
+// is full new events for that sink are dropped and counted.
+package decisionlog
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/AB-Lindex/rest-rego/pkg/regocache"
+	"github.com/google/uuid"
+)
+
+// Event is a single decision, serialized in the OPA decision log format.
+type Event struct {
+	DecisionID string            `json:"decision_id"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Path       string            `json:"path"`
+	Policy     string            `json:"policy"`
+	Input      json.RawMessage   `json:"input,omitempty"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Bundles    map[string]Bundle `json:"bundles,omitempty"`
+	Metrics    map[string]int64  `json:"metrics"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// Bundle identifies the policy bundle that made a decision.
+type Bundle struct {
+	Revision string `json:"revision"`
+}
+
+// Sink receives batches of decision events.
+type Sink interface {
+	Name() string
+	Write([]Event) error
+	Close() error
+}
+
+// Config controls buffering and batching for every sink.
+type Config struct {
+	BufferSize    int           // events queued per sink before dropping
+	BatchSize     int           // events per Write call
+	FlushInterval time.Duration // max time an event waits for a batch to fill
+}
+
+// Logger implements regocache.DecisionLogger.
+type Logger struct {
+	pipes  []*pipe
+	labels map[string]string
+
+	mtx    sync.RWMutex // guards closed, held while queueing events
+	closed bool
+}
+
+// New starts a Logger writing to the given sinks.
+func New(cfg Config, sinks ...Sink) *Logger {
+	l := &Logger{
+		labels: map[string]string{
+			"app":     "rest-rego",
+			"version": strings.TrimSpace(types.Version()),
+		},
+	}
+	for _, sink := range sinks {
+		p := &pipe{
+			sink:      sink,
+			ch:        make(chan Event, cfg.BufferSize),
+			done:      make(chan struct{}),
+			batchSize: max(cfg.BatchSize, 1),
+			interval:  cfg.FlushInterval,
+		}
+		go p.run()
+		l.pipes = append(l.pipes, p)
+		slog.Info("decisionlog: sink enabled", "sink", sink.Name())
+	}
+	return l
+}
+
+// LogDecision converts the decision to an Event and queues it for every sink.
+// Input and result are serialized here, before the caller can modify them.
+func (l *Logger) LogDecision(d regocache.Decision) {
+	ev := Event{
+		DecisionID: uuid.NewString(),
+		RequestID:  d.RequestID,
+		Timestamp:  d.Time.UTC(),
+		Path:       strings.ReplaceAll(d.Package, ".", "/"),
+		Policy:     d.Policy,
+		Metrics:    map[string]int64{"timer_rego_query_eval_ns": d.Duration.Nanoseconds()},
+		Labels:     l.labels,
+	}
+	if d.Revision != "" {
+		ev.Bundles = map[string]Bundle{"policies": {Revision: d.Revision}}
+	}
+	if d.Err != nil {
+		ev.Error = d.Err.Error()
+	}
+
+	var err error
+	if ev.Input, err = json.Marshal(d.Input); err != nil {
+		slog.Warn("decisionlog: failed to serialize input", "decision_id", ev.DecisionID, "error", err)
+		ev.Input = nil
+	}
+	if d.Result != nil {
+		if ev.Result, err = json.Marshal(d.Result); err != nil {
+			slog.Warn("decisionlog: failed to serialize result", "decision_id", ev.DecisionID, "error", err)
+			ev.Result = nil
+		}
+	}
+
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	if l.closed {
+		return
+	}
+	for _, p := range l.pipes {
+		select {
+		case p.ch <- ev:
+		default:
+			metrics.IncrementDecisionLogsDropped(p.sink.Name())
+		}
+	}
+}
+
+// Close flushes all queued events and closes the sinks. Decisions logged
+// after Close are discarded.
+func (l *Logger) Close() {
+	l.mtx.Lock()
+	if l.closed {
+		l.mtx.Unlock()
+		return
+	}
+	l.closed = true
+	for _, p := range l.pipes {
+		close(p.ch)
+	}
+	l.mtx.Unlock()
+
+	for _, p := range l.pipes {
+		<-p.done
+	}
+}
+
+// pipe is the buffer and worker for a single sink
+type pipe struct {
+	sink      Sink
+	ch        chan Event
+	done      chan struct{}
+	batchSize int
+	interval  time.Duration
+}
+
+func (p *pipe) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, p.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.sink.Write(batch); err != nil {
+			slog.Error("decisionlog: sink write failed", "sink", p.sink.Name(), "events", len(batch), "error", err)
+			metrics.IncrementDecisionLogsFailed(p.sink.Name(), len(batch))
+		}
+		batch = make([]Event, 0, p.batchSize)
+	}
+
+	for {
+		select {
+		case ev, ok := <-p.ch:
+			if !ok {
+				flush()
+				if err := p.sink.Close(); err != nil {
+					slog.Warn("decisionlog: sink close failed", "sink", p.sink.Name(), "error", err)
+				}
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= p.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
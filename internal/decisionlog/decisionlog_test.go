@@ -0,0 +1,328 @@
+package decisionlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/pkg/regocache"
+)
+
+func init() {
+	metrics.New()
+}
+
+// memSink records every batch it receives
+type memSink struct {
+	mtx     sync.Mutex
+	batches [][]Event
+	block   chan struct{}
+	closed  bool
+}
+
+func (s *memSink) Name() string { return "mem" }
+
+func (s *memSink) Write(events []Event) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *memSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memSink) events() []Event {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var all []Event
+	for _, b := range s.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func testConfig() Config {
+	return Config{BufferSize: 100, BatchSize: 10, FlushInterval: time.Hour}
+}
+
+func TestLogDecision_event(t *testing.T) {
+	sink := &memSink{}
+	l := New(testConfig(), sink)
+
+	input := map[string]any{"request": map[string]any{"method": "GET"}}
+	l.LogDecision(regocache.Decision{
+		Policy:    "request.rego",
+		Package:   "policies.request",
+		Revision:  "rev-1",
+		RequestID: "req-1",
+		Input:     input,
+		Result:    map[string]any{"allow": true},
+		Duration:  1500 * time.Nanosecond,
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	// the logger must not be affected by later changes to the input
+	input["request"] = "modified"
+	l.Close()
+
+	events := sink.events()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	ev := events[0]
+	if ev.DecisionID == "" {
+		t.Error("expected a decision id")
+	}
+	if ev.RequestID != "req-1" {
+		t.Errorf("expected request id req-1, got %q", ev.RequestID)
+	}
+	if ev.Path != "policies/request" || ev.Policy != "request.rego" {
+		t.Errorf("unexpected path/policy: %q %q", ev.Path, ev.Policy)
+	}
+	if ev.Bundles["policies"].Revision != "rev-1" {
+		t.Errorf("expected revision rev-1, got %v", ev.Bundles)
+	}
+	if ev.Metrics["timer_rego_query_eval_ns"] != 1500 {
+		t.Errorf("expected eval duration 1500ns, got %v", ev.Metrics)
+	}
+	if string(ev.Input) != `{"request":{"method":"GET"}}` {
+		t.Errorf("unexpected input %s", ev.Input)
+	}
+	if string(ev.Result) != `{"allow":true}` {
+		t.Errorf("unexpected result %s", ev.Result)
+	}
+	if !sink.closed {
+		t.Error("expected sink to be closed")
+	}
+}
+
+func TestLogDecision_error(t *testing.T) {
+	sink := &memSink{}
+	l := New(testConfig(), sink)
+	l.LogDecision(regocache.Decision{Policy: "request.rego", Err: errors.New("boom"), Time: time.Now()})
+	l.Close()
+
+	events := sink.events()
+	if len(events) != 1 || events[0].Error != "boom" || events[0].Result != nil {
+		t.Fatalf("expected one error event without result, got %+v", events)
+	}
+}
+
+func TestLogger_batching(t *testing.T) {
+	sink := &memSink{}
+	l := New(Config{BufferSize: 100, BatchSize: 3, FlushInterval: time.Hour}, sink)
+	for range 7 {
+		l.LogDecision(regocache.Decision{Policy: "request.rego", Time: time.Now()})
+	}
+	l.Close()
+
+	sizes := []int{}
+	for _, b := range sink.batches {
+		sizes = append(sizes, len(b))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("expected batches of 3,3,1 - got %v", sizes)
+	}
+}
+
+func TestLogger_flushInterval(t *testing.T) {
+	sink := &memSink{}
+	l := New(Config{BufferSize: 100, BatchSize: 100, FlushInterval: 10 * time.Millisecond}, sink)
+	defer l.Close()
+
+	l.LogDecision(regocache.Decision{Policy: "request.rego", Time: time.Now()})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.events()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected event to be flushed by the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLogger_dropsWhenFull(t *testing.T) {
+	sink := &memSink{block: make(chan struct{})}
+	l := New(Config{BufferSize: 2, BatchSize: 1, FlushInterval: time.Hour}, sink)
+
+	// must never block, even though the sink is stuck
+	done := make(chan struct{})
+	go func() {
+		for range 50 {
+			l.LogDecision(regocache.Decision{Policy: "request.rego", Time: time.Now()})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("LogDecision blocked on a full buffer")
+	}
+
+	close(sink.block)
+	l.Close()
+	if n := len(sink.events()); n == 0 || n > 3 {
+		t.Errorf("expected at most buffer+in-flight (3) events, got %d", n)
+	}
+}
+
+func TestLogger_logAfterClose(t *testing.T) {
+	sink := &memSink{}
+	l := New(testConfig(), sink)
+
+	// decisions racing with Close must not panic on the closed buffers
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				l.LogDecision(regocache.Decision{Policy: "request.rego", Time: time.Now()})
+			}
+		}()
+	}
+	l.Close()
+	wg.Wait()
+
+	l.LogDecision(regocache.Decision{Policy: "request.rego", Time: time.Now()})
+	l.Close()
+	if !sink.closed {
+		t.Error("expected sink to be closed")
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriterSink("test", &buf)
+	if err := s.Write([]Event{{DecisionID: "a"}, {DecisionID: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 JSON lines, got %q", buf.String())
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil || ev.DecisionID != "b" {
+		t.Errorf("unexpected second line %q (%v)", lines[1], err)
+	}
+}
+
+func TestFileSink_rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	line, _ := json.Marshal(&Event{DecisionID: "x"})
+	lineSize := int64(len(line) + 1)
+
+	s, err := NewFileSink(path, 2*lineSize, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 7 {
+		if err := s.Write([]Event{{DecisionID: "x"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+		if stat.Size() > 2*lineSize {
+			t.Errorf("%s is larger than max size: %d", name, stat.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected only 2 backups to be kept")
+	}
+}
+
+func TestFileSink_rotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	line, _ := json.Marshal(&Event{DecisionID: "x"})
+	lineSize := int64(len(line) + 1)
+
+	// a directory in the way of the backup makes every rotation fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileSink(path, 2*lineSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		if err := s.Write([]Event{{DecisionID: "x"}}); err != nil {
+			t.Fatalf("expected write to continue in the original file, got %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 5*lineSize {
+		t.Errorf("expected all 5 events in %s, got %d bytes", path, stat.Size())
+	}
+}
+
+func TestHTTPSink_retry(t *testing.T) {
+	var calls atomic.Int32
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	s := NewHTTPSink(server.URL)
+	s.backoff = time.Millisecond
+	if err := s.Write([]Event{{DecisionID: "a"}, {DecisionID: "b"}}); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls.Load() != 3 || len(received) != 2 {
+		t.Errorf("expected 3 calls and 2 events, got %d and %d", calls.Load(), len(received))
+	}
+}
+
+func TestHTTPSink_clientErrorNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := NewHTTPSink(server.URL)
+	s.backoff = time.Millisecond
+	if err := s.Write([]Event{{DecisionID: "a"}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected no retries on 400, got %d calls", calls.Load())
+	}
+}
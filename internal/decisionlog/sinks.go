@@ -0,0 +1,154 @@
+package decisionlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// writerSink writes events as JSON lines to an io.Writer.
+type writerSink struct {
+	name string
+	w    io.Writer
+}
+
+// NewWriterSink creates a sink writing JSON lines to w.
+func NewWriterSink(name string, w io.Writer) Sink {
+	return &writerSink{name: name, w: w}
+}
+
+// NewStdoutSink creates a sink writing JSON lines to stdout.
+func NewStdoutSink() Sink {
+	return NewWriterSink("stdout", os.Stdout)
+}
+
+func (s *writerSink) Name() string { return s.name }
+
+func (s *writerSink) Write(events []Event) error {
+	bw := bufio.NewWriter(s.w)
+	enc := json.NewEncoder(bw)
+	for i := range events {
+		if err := enc.Encode(&events[i]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// FileSink writes events as JSON lines to a file, rotating it when it grows
+// beyond maxSize bytes. Rotated files are renamed to <path>.1 ... <path>.N.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mtx  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens (or creates) the decision log file at path.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640) // #nosec G304 — path comes from operator-controlled config
+	if err != nil {
+		return fmt.Errorf("decisionlog: cannot open file %q: %w", s.path, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = stat.Size()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. If that fails the
+// original file is reopened, leaving s.f nil only when no file can be opened.
+func (s *FileSink) rotate() error {
+	err := s.f.Close()
+	s.f = nil
+	if err == nil {
+		err = s.shift()
+	}
+	if openErr := s.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (s *FileSink) shift() error {
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+func (s *FileSink) Write(events []Event) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// a previous rotation could not reopen the file
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	for i := range events {
+		line, err := json.Marshal(&events[i])
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+			if err := s.rotate(); err != nil {
+				if s.f == nil {
+					return err
+				}
+				slog.Warn("decisionlog: file rotation failed, appending to current file", "path", s.path, "error", err)
+			}
+		}
+
+		n, err := s.f.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,88 @@
+package decisionlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	webhookRetries = 3
+	webhookBackoff = 500 * time.Millisecond
+)
+
+// HTTPSink POSTs batches of events as a JSON array to a webhook, retrying
+// server errors and throttling with exponential backoff.
+type HTTPSink struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// NewHTTPSink creates a sink posting to url.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url:     url,
+		client:  &http.Client{Timeout: webhookTimeout},
+		retries: webhookRetries,
+		backoff: webhookBackoff,
+	}
+}
+
+func (s *HTTPSink) Name() string { return "http" }
+
+func (s *HTTPSink) Write(events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.backoff << (attempt - 1))
+			slog.Debug("decisionlog: retrying webhook", "attempt", attempt, "error", lastErr)
+		}
+
+		retry, err := s.post(body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post sends one batch, reporting whether a failure is worth retrying.
+func (s *HTTPSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+func (s *HTTPSink) Close() error { return nil }
@@ -0,0 +1,232 @@
+// Package extauthz implements Envoy's ext_authz gRPC API
+// (envoy.service.auth.v3.Authorization/Check) on top of the router's auth and
+// policy chain, so Istio and Envoy can ask rest-rego for decisions.
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const headerPrefix = "X-Restrego-"
+
+// Server answers ext_authz checks using an http.Handler (router.Proxy.CheckHandler)
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	handler         http.Handler
+	requestIDHeader string
+	grpc            *grpc.Server
+	tls             bool
+}
+
+// New creates a Server evaluating checks with handler. The request id header
+// is passed on to the upstream request of allowed checks. Without tlsConfig
+// the server accepts plaintext connections.
+func New(handler http.Handler, requestIDHeader string, tlsConfig *tls.Config) *Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := &Server{
+		handler:         handler,
+		requestIDHeader: requestIDHeader,
+		grpc:            grpc.NewServer(opts...),
+		tls:             tlsConfig != nil,
+	}
+	authv3.RegisterAuthorizationServer(s.grpc, s)
+	return s
+}
+
+// ListenAndServe starts the gRPC server on addr (in background)
+func (s *Server) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		slog.Info("extauthz: starting grpc server", "addr", addr, "tls", s.tls)
+		if err := s.grpc.Serve(lis); err != nil {
+			slog.Warn("extauthz: server aborted", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the server, letting running checks finish
+func (s *Server) Close() {
+	s.grpc.GracefulStop()
+}
+
+// Check implements envoy.service.auth.v3.Authorization
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	r, err := newRequest(ctx, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// the cleanup handler strips these from the request, so collect them first
+	var spoofed []string
+	for key := range r.Header {
+		if strings.HasPrefix(key, headerPrefix) {
+			spoofed = append(spoofed, key)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+
+	if w.Code == http.StatusOK {
+		return s.okResponse(spoofed, w.Header()), nil
+	}
+	return deniedResponse(w), nil
+}
+
+// newRequest rebuilds the request Envoy asks about
+func newRequest(ctx context.Context, req *authv3.CheckRequest) (*http.Request, error) {
+	attrs := req.GetAttributes()
+	h := attrs.GetRequest().GetHttp()
+	if h == nil {
+		return nil, fmt.Errorf("check request without http attributes")
+	}
+
+	path := h.GetPath()
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	if scheme := h.GetScheme(); scheme != "" {
+		ctx = context.WithValue(ctx, types.CtxSchemeKey, scheme)
+	}
+	if src := attrs.GetSource(); src.GetPrincipal() != "" || src.GetService() != "" || len(src.GetLabels()) > 0 {
+		ctx = context.WithValue(ctx, types.CtxSourceKey, &types.RequestSource{
+			Principal: src.GetPrincipal(),
+			Service:   src.GetService(),
+			Labels:    src.GetLabels(),
+		})
+	}
+
+	// the body is only sent when Envoy is configured with_request_body
+	var body io.Reader
+	if raw := h.GetRawBody(); len(raw) > 0 {
+		body = bytes.NewReader(raw)
+	} else if h.GetBody() != "" {
+		body = strings.NewReader(h.GetBody())
+	}
+
+	r, err := http.NewRequestWithContext(ctx, h.GetMethod(), path, body)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = path
+	r.Proto = h.GetProtocol()
+	r.ContentLength = h.GetSize()
+	r.RemoteAddr = peerAddr(attrs.GetSource())
+
+	for k, v := range h.GetHeaders() {
+		addHeader(r, k, v)
+	}
+	for _, hv := range h.GetHeaderMap().GetHeaders() {
+		v := hv.GetValue()
+		if v == "" {
+			v = string(hv.GetRawValue())
+		}
+		addHeader(r, hv.GetKey(), v)
+	}
+	if h.GetHost() != "" {
+		r.Host = h.GetHost()
+	}
+	return r, nil
+}
+
+// addHeader adds an Envoy header, pseudo-headers like :authority are skipped
+func addHeader(r *http.Request, key, value string) {
+	if strings.HasPrefix(key, ":") {
+		return
+	}
+	if strings.EqualFold(key, "host") {
+		r.Host = value
+		return
+	}
+	r.Header.Add(key, value)
+}
+
+// peerAddr returns the socket address of a peer as host:port
+func peerAddr(p *authv3.AttributeContext_Peer) string {
+	sa := p.GetAddress().GetSocketAddress()
+	if sa == nil {
+		return ""
+	}
+	return net.JoinHostPort(sa.GetAddress(), strconv.Itoa(int(sa.GetPortValue())))
+}
+
+// okResponse lets the request through, setting the X-Restrego-* result
+// headers and removing the ones the client sent itself
+func (s *Server) okResponse(spoofed []string, h http.Header) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{HeadersToRemove: spoofed}
+	for key, values := range h {
+		if strings.HasPrefix(key, headerPrefix) || (s.requestIDHeader != "" && key == s.requestIDHeader) {
+			ok.Headers = append(ok.Headers, headerOptions(key, values)...)
+		}
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+// deniedResponse returns the denial rest-rego would have sent to the client
+func deniedResponse(w *httptest.ResponseRecorder) *authv3.CheckResponse {
+	code := codes.PermissionDenied
+	if w.Code == http.StatusUnauthorized {
+		code = codes.Unauthenticated
+	}
+
+	denied := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(w.Code)},
+		Body:   w.Body.String(),
+	}
+	for key, values := range w.Header() {
+		denied.Headers = append(denied.Headers, headerOptions(key, values)...)
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(code), Message: http.StatusText(w.Code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied},
+	}
+}
+
+// headerOptions converts a header to Envoy header mutations; the first value
+// replaces any existing header, the others are appended
+func headerOptions(key string, values []string) []*corev3.HeaderValueOption {
+	opts := make([]*corev3.HeaderValueOption, 0, len(values))
+	for i, v := range values {
+		action := corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+		if i > 0 {
+			action = corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD
+		}
+		opts = append(opts, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: key, Value: v},
+			AppendAction: action,
+		})
+	}
+	return opts
+}
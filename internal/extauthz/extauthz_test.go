@@ -0,0 +1,248 @@
+package extauthz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/router"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func init() {
+	metrics.New()
+}
+
+type bearerAuth struct{}
+
+func (bearerAuth) Authenticate(info *types.Info, r *http.Request) error {
+	info.User = map[string]interface{}{"name": r.Header.Get("Authorization")}
+	return nil
+}
+
+// captureValidator allows requests with a fixed result and keeps the input
+type captureValidator struct {
+	result interface{}
+	input  *types.Info
+}
+
+func (v *captureValidator) Validate(name string, input interface{}) (interface{}, error) {
+	v.input, _ = input.(*types.Info)
+	return v.result, nil
+}
+
+func newClient(t *testing.T, v types.Validator) authv3.AuthorizationClient {
+	t.Helper()
+	return newTLSClient(t, v, nil, insecure.NewCredentials())
+}
+
+func newTLSClient(t *testing.T, v types.Validator, serverTLS *tls.Config, creds credentials.TransportCredentials) authv3.AuthorizationClient {
+	t.Helper()
+	proxy := router.New(bearerAuth{}, v, &config.Fields{
+		BackendScheme:   "http",
+		BackendHost:     "backend.invalid",
+		BackendPort:     1,
+		RequestIDHeader: "X-Request-Id",
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+	s := New(proxy.CheckHandler(), "X-Request-Id", serverTLS)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.grpc.Serve(lis)
+	t.Cleanup(s.Close)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func checkRequest(method, path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Source: &authv3.AttributeContext_Peer{
+			Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+				Address:       "10.1.2.3",
+				PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 51234},
+			}}},
+			Principal: "spiffe://cluster.local/ns/shop/sa/frontend",
+			Labels:    map[string]string{"app": "frontend"},
+		},
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method:  method,
+			Path:    path,
+			Host:    "shop.example.com",
+			Scheme:  "https",
+			Headers: headers,
+		}},
+	}}
+}
+
+func headerMap(opts []*corev3.HeaderValueOption) map[string]string {
+	m := make(map[string]string)
+	for _, o := range opts {
+		m[o.GetHeader().GetKey()] = o.GetHeader().GetValue()
+	}
+	return m
+}
+
+func TestCheck_Allow(t *testing.T) {
+	v := &captureValidator{result: map[string]interface{}{"allow": true, "user_role": "admin"}}
+	client := newClient(t, v)
+
+	resp, err := client.Check(context.Background(), checkRequest(http.MethodDelete, "/api/orders/1?force=1", map[string]string{
+		":authority":         "shop.example.com",
+		"authorization":      "Bearer abc",
+		"x-request-id":       "req-1",
+		"x-restrego-spoofed": "yes",
+	}))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if codes.Code(resp.GetStatus().GetCode()) != codes.OK {
+		t.Fatalf("Expected OK, got %v", resp.GetStatus())
+	}
+
+	ok := resp.GetOkResponse()
+	h := headerMap(ok.GetHeaders())
+	if h["X-Restrego-User-Role"] != "admin" {
+		t.Errorf("Expected result header, got %v", h)
+	}
+	if h["X-Request-Id"] != "req-1" {
+		t.Errorf("Expected request id header, got %v", h)
+	}
+	if len(ok.GetHeadersToRemove()) != 1 || ok.GetHeadersToRemove()[0] != "X-Restrego-Spoofed" {
+		t.Errorf("Expected spoofed header to be removed, got %v", ok.GetHeadersToRemove())
+	}
+
+	in := v.input.Request
+	if in.Method != http.MethodDelete || in.RawQuery != "force=1" || len(in.Path) != 3 {
+		t.Errorf("Unexpected request %s %v %q", in.Method, in.Path, in.RawQuery)
+	}
+	if in.Host != "shop.example.com" || in.Scheme != "https" {
+		t.Errorf("Unexpected host/scheme %q %q", in.Host, in.Scheme)
+	}
+	if in.Source == nil || in.Source.Principal != "spiffe://cluster.local/ns/shop/sa/frontend" || in.Source.Labels["app"] != "frontend" {
+		t.Errorf("Expected source peer in input, got %+v", in.Source)
+	}
+	if v.input.User == nil {
+		t.Error("Expected authenticated user")
+	}
+}
+
+func TestCheck_Deny(t *testing.T) {
+	client := newClient(t, &captureValidator{result: map[string]interface{}{
+		"allow":   false,
+		"status":  401,
+		"headers": map[string]interface{}{"WWW-Authenticate": `Bearer realm="shop"`},
+		"body":    "login required",
+	}})
+
+	resp, err := client.Check(context.Background(), checkRequest(http.MethodGet, "/admin", nil))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if codes.Code(resp.GetStatus().GetCode()) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated, got %v", resp.GetStatus())
+	}
+
+	denied := resp.GetDeniedResponse()
+	if denied.GetStatus().GetCode() != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", denied.GetStatus().GetCode())
+	}
+	if h := headerMap(denied.GetHeaders()); h["Www-Authenticate"] != `Bearer realm="shop"` {
+		t.Errorf("Expected policy header, got %v", h)
+	}
+	if denied.GetBody() == "" {
+		t.Error("Expected denial body")
+	}
+}
+
+func TestCheck_InvalidRequest(t *testing.T) {
+	client := newClient(t, &captureValidator{result: map[string]interface{}{"allow": true}})
+
+	for _, req := range []*authv3.CheckRequest{
+		{},
+		checkRequest(http.MethodGet, "http://evil.example.com/", nil),
+	} {
+		_, err := client.Check(context.Background(), req)
+		if err == nil {
+			t.Errorf("Expected an error for %v", req)
+		}
+	}
+}
+
+// selfSigned returns a server certificate for localhost and a pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestCheck_TLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	v := &captureValidator{result: map[string]interface{}{"allow": true}}
+	req := checkRequest(http.MethodGet, "/orders", nil)
+
+	client := newTLSClient(t, v, serverTLS, credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "localhost"}))
+	resp, err := client.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Check() over TLS failed: %v", err)
+	}
+	if codes.Code(resp.GetStatus().GetCode()) != codes.OK {
+		t.Errorf("Expected OK, got %v", resp.GetStatus())
+	}
+
+	plaintext := newTLSClient(t, v, serverTLS, insecure.NewCredentials())
+	if _, err := plaintext.Check(context.Background(), req); err == nil {
+		t.Error("Expected a plaintext client to be refused")
+	}
+}
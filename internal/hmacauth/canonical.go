@@ -0,0 +1,168 @@
+package hmacauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Scheme is the Authorization scheme of signed requests
+const Scheme = "HMAC-SHA256"
+
+// maxNonceLength limits the nonces kept for replay protection
+const maxNonceLength = 128
+
+// signature is the parsed Authorization header of a signed request:
+//
+//	HMAC-SHA256 Credential=<id>, SignedHeaders=<h1;h2>, Timestamp=<unix>, Nonce=<nonce>, Signature=<hex>
+type signature struct {
+	credential    string
+	signedHeaders []string
+	timestamp     int64
+	nonce         string
+	signature     []byte
+}
+
+// parseSignature parses the parameters following the scheme
+func parseSignature(params string) (*signature, error) {
+	s := &signature{}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("invalid parameter %q", part)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("duplicate parameter %q", k)
+		}
+		seen[k] = true
+
+		switch k {
+		case "Credential":
+			s.credential = v
+		case "SignedHeaders":
+			s.signedHeaders = strings.Split(strings.ToLower(v), ";")
+			if !slices.IsSorted(s.signedHeaders) || len(slices.Compact(slices.Clone(s.signedHeaders))) != len(s.signedHeaders) {
+				return nil, fmt.Errorf("signed headers must be sorted and unique")
+			}
+		case "Timestamp":
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q", v)
+			}
+			s.timestamp = ts
+		case "Nonce":
+			if len(v) > maxNonceLength {
+				return nil, fmt.Errorf("nonce longer than %d characters", maxNonceLength)
+			}
+			s.nonce = v
+		case "Signature":
+			sig, err := hex.DecodeString(v)
+			if err != nil || len(sig) != sha256.Size {
+				return nil, fmt.Errorf("invalid signature")
+			}
+			s.signature = sig
+		default:
+			return nil, fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+
+	for _, k := range []string{"Credential", "SignedHeaders", "Timestamp", "Nonce", "Signature"} {
+		if !seen[k] {
+			return nil, fmt.Errorf("missing parameter %s", k)
+		}
+	}
+	return s, nil
+}
+
+// canonicalRequest builds the signed form of a request:
+//
+//	METHOD
+//	escaped path
+//	canonical query (sorted, RFC 3986 encoded)
+//	name:value of each signed header, one per line
+//	signed header names joined by ';'
+//	hex sha256 of the body
+func canonicalRequest(r *http.Request, signedHeaders []string, bodyDigest string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(r.URL.EscapedPath())
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(r.URL.Query()))
+	b.WriteByte('\n')
+	for _, h := range signedHeaders {
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(headerValue(r, h))
+		b.WriteByte('\n')
+	}
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteByte('\n')
+	b.WriteString(bodyDigest)
+	return b.String()
+}
+
+// stringToSign is what the client signs: the scheme, timestamp, nonce and
+// the digest of the canonical request
+func stringToSign(timestamp int64, nonce, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return Scheme + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+// sign returns the HMAC-SHA256 of the string to sign
+func sign(secret []byte, toSign string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(toSign))
+	return mac.Sum(nil)
+}
+
+// headerValue returns the values of a header, trimmed, with inner whitespace
+// collapsed and joined by ','. Host is taken from the request.
+func headerValue(r *http.Request, name string) string {
+	values := r.Header.Values(name)
+	if name == "host" {
+		values = []string{r.Host}
+	}
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(out, ",")
+}
+
+// canonicalQuery encodes the query sorted by name, then value
+func canonicalQuery(q url.Values) string {
+	pairs := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes all but the RFC 3986 unreserved characters
+func uriEncode(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package hmacauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Config describes how signed requests are verified
+type Config struct {
+	SecretsFile   string
+	SignedHeaders []string      // headers every signature must cover, e.g. "host"
+	MaxSkew       time.Duration // max difference between the signature timestamp and now
+	MaxBodySize   int64         // bodies up to this size are buffered and signed
+	Permissive    bool
+}
+
+// Client is the identity of a verified signature, available to policies as input.user
+type Client struct {
+	ID            string   `json:"id"`
+	SignedHeaders []string `json:"signed_headers"`
+}
+
+// HMACProvider authenticates requests signed with a per-client shared secret
+// over the method, path, query, selected headers, a timestamp and the body.
+type HMACProvider struct {
+	cfg     Config
+	clients atomic.Pointer[map[string][][]byte]
+	nonces  *nonceStore
+	watcher *fsnotify.Watcher
+	now     func() time.Time
+}
+
+// New creates an HMACProvider reading client secrets from cfg.SecretsFile.
+// Returns nil if the file cannot be loaded or contains no valid clients.
+func New(cfg Config) *HMACProvider {
+	clients, err := loadFile(cfg.SecretsFile)
+	if err != nil {
+		slog.Error("hmacauth: failed to load secrets", "file", cfg.SecretsFile, "error", err)
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("hmacauth: failed to create file watcher", "error", err)
+		return nil
+	}
+
+	for i, h := range cfg.SignedHeaders {
+		cfg.SignedHeaders[i] = strings.ToLower(h)
+	}
+
+	p := &HMACProvider{
+		cfg:     cfg,
+		nonces:  newNonceStore(),
+		watcher: w,
+		now:     time.Now,
+	}
+	p.clients.Store(&clients)
+
+	// watch the directory, so a replaced file (e.g. a Kubernetes Secret
+	// volume swapping a symlink) is noticed as well
+	p.cfg.SecretsFile = filepath.Clean(cfg.SecretsFile)
+	if err := w.Add(filepath.Dir(p.cfg.SecretsFile)); err != nil {
+		slog.Warn("hmacauth: failed to watch file, hot-reload disabled", "file", cfg.SecretsFile, "error", err)
+	} else {
+		go startWatcher(p)
+	}
+
+	return p
+}
+
+// Close stops watching the secrets file.
+func (p *HMACProvider) Close() error {
+	return p.watcher.Close()
+}
+
+// Authenticate implements types.AuthProvider.
+// No HMAC-SHA256 authorization → anonymous (nil error).
+// Malformed authorization or unknown client → ErrAuthenticationFailed in strict mode, anonymous in permissive mode.
+// Known client with a stale, replayed or wrong signature → always ErrAuthenticationFailed.
+func (p *HMACProvider) Authenticate(info *types.Info, r *http.Request) error {
+	auth := info.Request.Auth
+	if auth == nil || !strings.EqualFold(auth.Kind, Scheme) {
+		return nil // anonymous passthrough
+	}
+
+	sig, err := parseSignature(auth.Token)
+	if err != nil {
+		return p.reject("malformed authorization", "error", err)
+	}
+	secrets, ok := (*p.clients.Load())[sig.credential]
+	if !ok {
+		return p.reject("unknown client", "client", sig.credential)
+	}
+
+	for _, h := range p.cfg.SignedHeaders {
+		if !slices.Contains(sig.signedHeaders, h) {
+			return fail(sig.credential, "required header not signed", "header", h)
+		}
+	}
+
+	now := p.now()
+	signedAt := time.Unix(sig.timestamp, 0)
+	if skew := now.Sub(signedAt).Abs(); skew > p.cfg.MaxSkew {
+		return fail(sig.credential, "timestamp outside window", "timestamp", signedAt, "skew", skew.Round(time.Second))
+	}
+
+	body, err := p.readBody(r)
+	if err != nil {
+		return fail(sig.credential, err.Error())
+	}
+	digest := sha256.Sum256(body)
+
+	canonical := canonicalRequest(r, sig.signedHeaders, hex.EncodeToString(digest[:]))
+	toSign := stringToSign(sig.timestamp, sig.nonce, canonical)
+	if !slices.ContainsFunc(secrets, func(secret []byte) bool { return hmac.Equal(sign(secret, toSign), sig.signature) }) {
+		return fail(sig.credential, "signature mismatch", "canonical_request", canonical)
+	}
+
+	// only a valid signature uses up its nonce
+	if !p.nonces.add(sig.credential+"\x00"+sig.nonce, signedAt.Add(p.cfg.MaxSkew), now) {
+		return fail(sig.credential, "replayed nonce", "nonce", sig.nonce)
+	}
+
+	info.User = &Client{ID: sig.credential, SignedHeaders: sig.signedHeaders}
+	info.Request.Principal = sig.credential
+	return nil
+}
+
+// readBody buffers the body up to MaxBodySize and puts it back on the
+// request, so it is still forwarded to the backend
+func (p *HMACProvider) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.ContentLength > p.cfg.MaxBodySize {
+		return nil, fmt.Errorf("body larger than %d bytes", p.cfg.MaxBodySize)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, p.cfg.MaxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if int64(len(body)) > p.cfg.MaxBodySize {
+		return nil, fmt.Errorf("body larger than %d bytes", p.cfg.MaxBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// reject returns ErrAuthenticationFailed in strict mode, or nil (anonymous) in permissive mode.
+func (p *HMACProvider) reject(reason string, args ...any) error {
+	if !p.cfg.Permissive {
+		slog.Debug("hmacauth: signature rejected", append([]any{"reason", reason}, args...)...)
+		return types.ErrAuthenticationFailed
+	}
+	slog.Debug(fmt.Sprintf("hmacauth: treating %s as anonymous (permissive mode)", reason), args...)
+	return nil
+}
+
+// fail rejects the signature of a known client, regardless of permissive mode
+func fail(client, reason string, args ...any) error {
+	slog.Debug("hmacauth: signature rejected", append([]any{"client", client, "reason", reason}, args...)...)
+	return types.ErrAuthenticationFailed
+}
+
+// WWWAuthenticate implements the optional types.AuthChallenger interface.
+func (p *HMACProvider) WWWAuthenticate() string {
+	return Scheme + ` realm="rest-rego"`
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (p *HMACProvider) Name() string {
+	return "hmac"
+}
@@ -0,0 +1,365 @@
+package hmacauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+const (
+	currentSecret  = "webhook-secret-2026"
+	previousSecret = "webhook-secret-2025"
+)
+
+var testNow = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+func writeSecretsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hmac.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestProvider(t *testing.T, permissive bool) *HMACProvider {
+	t.Helper()
+	p := New(Config{
+		SecretsFile: writeSecretsFile(t, fmt.Sprintf(`
+clients:
+  - id: payments
+    secrets: [%q, %q]
+`, currentSecret, previousSecret)),
+		SignedHeaders: []string{"Host"},
+		MaxSkew:       5 * time.Minute,
+		MaxBodySize:   64,
+		Permissive:    permissive,
+	})
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	p.now = func() time.Time { return testNow }
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// signedRequest builds a request the way a client signs it
+type signedRequest struct {
+	method, target, body string
+	headers              map[string]string
+	signedHeaders        []string
+	client, secret       string
+	timestamp            time.Time
+	nonce                string
+}
+
+func defaultRequest() signedRequest {
+	return signedRequest{
+		method:        http.MethodPost,
+		target:        "https://api.example.com/hooks/payment?b=2&a=1&a=0",
+		body:          `{"event":"paid"}`,
+		headers:       map[string]string{"Content-Type": "application/json"},
+		signedHeaders: []string{"content-type", "host"},
+		client:        "payments",
+		secret:        currentSecret,
+		timestamp:     testNow,
+		nonce:         "n-1",
+	}
+}
+
+func (s signedRequest) build() (*types.Info, *http.Request) {
+	r := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+	for k, v := range s.headers {
+		r.Header.Set(k, v)
+	}
+
+	digest := sha256Hex(s.body)
+	toSign := stringToSign(s.timestamp.Unix(), s.nonce, canonicalRequest(r, s.signedHeaders, digest))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Timestamp=%d, Nonce=%s, Signature=%s",
+		Scheme, s.client, strings.Join(s.signedHeaders, ";"), s.timestamp.Unix(), s.nonce,
+		hex.EncodeToString(sign([]byte(s.secret), toSign))))
+	return types.NewInfo(r, "Authorization", 0), r
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_Valid(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	info, r := defaultRequest().build()
+	if err := p.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	c, ok := info.User.(*Client)
+	if !ok || c.ID != "payments" || info.Request.Principal != "payments" {
+		t.Fatalf("Expected client payments, got %+v", info.User)
+	}
+
+	// the body is still there for the backend
+	body, _ := io.ReadAll(r.Body)
+	if string(body) != `{"event":"paid"}` {
+		t.Errorf("Expected the body to be kept, got %q", body)
+	}
+}
+
+func TestAuthenticate_PreviousSecret(t *testing.T) {
+	req := defaultRequest()
+	req.secret = previousSecret
+	info, r := req.build()
+	if err := newTestProvider(t, false).Authenticate(info, r); err != nil {
+		t.Errorf("Expected the previous secret to be accepted during rotation, got %v", err)
+	}
+}
+
+func TestAuthenticate_NoSignature_Anonymous(t *testing.T) {
+	p := newTestProvider(t, false)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	info := types.NewInfo(r, "Authorization", 0)
+	if err := p.Authenticate(info, r); err != nil || info.User != nil {
+		t.Errorf("Expected anonymous, got %v %v", info.User, err)
+	}
+}
+
+func TestAuthenticate_Tampered(t *testing.T) {
+	testCases := []struct {
+		name   string
+		tamper func(r *http.Request)
+	}{
+		{"method", func(r *http.Request) { r.Method = http.MethodPut }},
+		{"path", func(r *http.Request) { r.URL.Path = "/hooks/refund" }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "a=1&b=3" }},
+		{"signed header", func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }},
+		{"host", func(r *http.Request) { r.Host = "evil.example.com" }},
+		{"body", func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"event":"refund"}`)) }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, r := defaultRequest().build()
+			tc.tamper(r)
+			// tampering is rejected even in permissive mode
+			if err := newTestProvider(t, true).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_UnsignedHeaderIgnored(t *testing.T) {
+	info, r := defaultRequest().build()
+	r.Header.Set("X-Trace", "changed in transit")
+	if err := newTestProvider(t, false).Authenticate(info, r); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+}
+
+func TestAuthenticate_Rejected(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(s *signedRequest)
+		always bool // rejected in permissive mode too
+	}{
+		{"wrong secret", func(s *signedRequest) { s.secret = "not-the-right-secret" }, true},
+		{"old timestamp", func(s *signedRequest) { s.timestamp = testNow.Add(-6 * time.Minute) }, true},
+		{"future timestamp", func(s *signedRequest) { s.timestamp = testNow.Add(6 * time.Minute) }, true},
+		{"host not signed", func(s *signedRequest) { s.signedHeaders = []string{"content-type"} }, true},
+		{"body too large", func(s *signedRequest) { s.body = strings.Repeat("x", 65) }, true},
+		{"unknown client", func(s *signedRequest) { s.client = "unknown" }, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := defaultRequest()
+			tc.modify(&req)
+
+			info, r := req.build()
+			if err := newTestProvider(t, false).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+			}
+
+			info, r = req.build()
+			err := newTestProvider(t, true).Authenticate(info, r)
+			if tc.always && !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed in permissive mode, got %v", err)
+			}
+			if !tc.always && (err != nil || info.User != nil) {
+				t.Errorf("Expected anonymous in permissive mode, got %v %v", info.User, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_Replay(t *testing.T) {
+	p := newTestProvider(t, false)
+
+	info, r := defaultRequest().build()
+	if err := p.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	info, r = defaultRequest().build()
+	if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the replay to be rejected, got %v", err)
+	}
+
+	req := defaultRequest()
+	req.nonce = "n-2"
+	info, r = req.build()
+	if err := p.Authenticate(info, r); err != nil {
+		t.Errorf("Expected a new nonce to be accepted, got %v", err)
+	}
+}
+
+func TestAuthenticate_Malformed(t *testing.T) {
+	p := newTestProvider(t, false)
+	for _, token := range []string{
+		"Credential=payments",
+		"Credential=payments, SignedHeaders=host, Timestamp=now, Nonce=1, Signature=00",
+		"Credential=payments, SignedHeaders=host;content-type, Timestamp=1, Nonce=1, Signature=" + strings.Repeat("00", 32),
+		"Credential=payments, Credential=other, SignedHeaders=host, Timestamp=1, Nonce=1, Signature=" + strings.Repeat("00", 32),
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", Scheme+" "+token)
+		info := types.NewInfo(r, "Authorization", 0)
+		if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+			t.Errorf("%q: expected ErrAuthenticationFailed, got %v", token, err)
+		}
+	}
+}
+
+// --- canonical form tests ---
+
+func TestCanonicalRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/a%2Fb/c?z=1&a=hello%20world&a=%C3%A9", nil)
+	r.Header.Add("X-Multi", "  one   two ")
+	r.Header.Add("X-Multi", "three")
+
+	got := canonicalRequest(r, []string{"host", "x-multi"}, sha256Hex(""))
+	want := "GET\n" +
+		"/a%2Fb/c\n" +
+		"a=%C3%A9&a=hello%20world&z=1\n" +
+		"host:api.example.com\n" +
+		"x-multi:one two,three\n" +
+		"host;x-multi\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got != want {
+		t.Errorf("Unexpected canonical request:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// --- loadFile tests ---
+
+func TestLoadFile_SkipsInvalidClients(t *testing.T) {
+	clients, err := loadFile(writeSecretsFile(t, `
+clients:
+  - id: valid
+    secrets: ["a-long-enough-secret", "short"]
+  - secrets: ["a-long-enough-secret"]
+  - id: valid
+    secrets: ["another-long-secret"]
+  - id: weak
+    secrets: ["short"]
+`))
+	if err != nil {
+		t.Fatalf("Expected file to load, got %v", err)
+	}
+	if len(clients) != 1 || len(clients["valid"]) != 1 {
+		t.Errorf("Expected one client with one secret, got %v", clients)
+	}
+
+	if _, err := loadFile(writeSecretsFile(t, "clients:\n  - id: weak\n    secrets: [short]\n")); !errors.Is(err, ErrNoValidClients) {
+		t.Errorf("Expected ErrNoValidClients, got %v", err)
+	}
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// TestReload_KubernetesVolume tests a Secret update, which swaps the "..data"
+// symlink the mounted file points through
+func TestReload_KubernetesVolume(t *testing.T) {
+	const rotatedSecret = "webhook-secret-2027"
+	dir := t.TempDir()
+	writeVersion := func(version string, secrets ...string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := fmt.Sprintf("clients:\n  - id: payments\n    secrets: [%s]\n", strings.Join(secrets, ", "))
+		if err := os.WriteFile(filepath.Join(dir, version, "hmac.yaml"), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, kubernetesDataLink)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("..version_1", currentSecret)
+	if err := os.Symlink(filepath.Join(kubernetesDataLink, "hmac.yaml"), filepath.Join(dir, "hmac.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(Config{
+		SecretsFile: filepath.Join(dir, "hmac.yaml"),
+		MaxSkew:     5 * time.Minute,
+		MaxBodySize: 64,
+	})
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	p.now = func() time.Time { return testNow }
+	t.Cleanup(func() { p.Close() })
+
+	writeVersion("..version_2", rotatedSecret)
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; ; i++ {
+		req := defaultRequest()
+		req.secret = rotatedSecret
+		req.nonce = fmt.Sprintf("rotated-%d", i)
+		info, r := req.build()
+		if p.Authenticate(info, r) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Secrets were not reloaded after the volume update")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	req := defaultRequest()
+	req.nonce = "revoked"
+	info, r := req.build()
+	if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the revoked secret to be rejected, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := New(Config{SecretsFile: writeSecretsFile(t, fmt.Sprintf("clients:\n  - id: payments\n    secrets: [%q]\n", currentSecret))})
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if _, ok := <-p.watcher.Events; ok {
+		t.Error("Expected the watcher to be closed")
+	}
+}
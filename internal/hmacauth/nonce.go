@@ -0,0 +1,40 @@
+package hmacauth
+
+import (
+	"sync"
+	"time"
+)
+
+// nonceStore remembers the nonces seen within the replay window. Unlike the
+// caches of the other providers it never evicts early, a dropped nonce could
+// be replayed.
+type nonceStore struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // nonce key → time it can be forgotten
+	nextSweep time.Time
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{seen: make(map[string]time.Time)}
+}
+
+// add records a nonce until expires, and reports false if it was already seen
+func (s *nonceStore) add(key string, expires, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.After(s.nextSweep) {
+		for k, exp := range s.seen {
+			if now.After(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+
+	if exp, ok := s.seen[key]; ok && !now.After(exp) {
+		return false
+	}
+	s.seen[key] = expires
+	return true
+}
@@ -0,0 +1,75 @@
+package hmacauth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrNoValidClients is returned when a file contains no usable clients.
+var ErrNoValidClients = errors.New("hmacauth: no valid clients found in file")
+
+// minSecretLength is the shortest secret accepted, shorter ones are guessable
+const minSecretLength = 16
+
+// client is one signing client in the secrets file
+type client struct {
+	ID      string   `json:"id"`
+	Secrets []string `json:"secrets"` // the current secret first, older ones during rotation
+}
+
+// secretsFile is the format of HMAC_SECRETS_FILE
+type secretsFile struct {
+	Clients []*client `json:"clients"`
+}
+
+// loadFile parses a YAML (or JSON) secrets file into a map of client id to
+// secrets. Clients without an id or without valid secrets are logged at WARN
+// level and skipped. Returns ErrNoValidClients if no client is usable.
+func loadFile(filePath string) (map[string][][]byte, error) {
+	data, err := os.ReadFile(filePath) // #nosec G304 — path comes from operator-controlled config
+	if err != nil {
+		return nil, fmt.Errorf("hmacauth: cannot read file %q: %w", filePath, err)
+	}
+
+	var f secretsFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("hmacauth: invalid file %q: %w", filePath, err)
+	}
+
+	clients := make(map[string][][]byte, len(f.Clients))
+	for i, c := range f.Clients {
+		if c == nil || c.ID == "" {
+			slog.Warn("hmacauth: skipping client without id", "file", filePath, "index", i)
+			continue
+		}
+		if _, ok := clients[c.ID]; ok {
+			slog.Warn("hmacauth: skipping duplicate client id", "file", filePath, "id", c.ID)
+			continue
+		}
+
+		var secrets [][]byte
+		for _, s := range c.Secrets {
+			if len(s) < minSecretLength {
+				slog.Warn("hmacauth: skipping secret shorter than 16 characters", "file", filePath, "id", c.ID)
+				continue
+			}
+			secrets = append(secrets, []byte(s))
+		}
+		if len(secrets) == 0 {
+			slog.Warn("hmacauth: skipping client without valid secrets", "file", filePath, "id", c.ID)
+			continue
+		}
+		clients[c.ID] = secrets
+	}
+
+	if len(clients) == 0 {
+		return nil, ErrNoValidClients
+	}
+
+	slog.Info("hmacauth: loaded clients", "count", len(clients), "file", filePath)
+	return clients, nil
+}
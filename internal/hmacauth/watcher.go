@@ -0,0 +1,48 @@
+package hmacauth
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// kubernetesDataLink is the symlink Kubernetes swaps to update a Secret
+// volume at once, the files in the volume are symlinks through it
+const kubernetesDataLink = "..data"
+
+// startWatcher listens for file-system events in the directory of
+// p.cfg.SecretsFile and atomically replaces the client secrets when the file,
+// or the "..data" link of a Kubernetes volume, is written or created.
+// On a load error the existing secrets are retained unchanged.
+// This function is intended to run in its own goroutine.
+func startWatcher(p *HMACProvider) {
+	for {
+		select {
+		case event, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if name != p.cfg.SecretsFile && filepath.Base(name) != kubernetesDataLink {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+				clients, err := loadFile(p.cfg.SecretsFile)
+				if err != nil {
+					slog.Error("hmacauth: failed to reload secrets, retaining last valid set",
+						"file", p.cfg.SecretsFile, "error", err)
+					continue
+				}
+				p.clients.Store(&clients)
+				slog.Info("hmacauth: reloaded secrets", "count", len(clients), "file", p.cfg.SecretsFile)
+			}
+
+		case err, ok := <-p.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("hmacauth: file watcher error", "file", p.cfg.SecretsFile, "error", err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// maxResponseSize limits the introspection response that is read
+const maxResponseSize = 1 << 20
+
+// Config describes the introspection endpoint (RFC 7662)
+type Config struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	AuthHeader   string
+	Audiences    []string      // if set, the token must have one of these in "aud"
+	CacheTTL     time.Duration // max time an active token is cached (never beyond "exp")
+	NegativeTTL  time.Duration // time an inactive token is cached
+	Timeout      time.Duration
+	Permissive   bool
+}
+
+// entry is a cached introspection result, claims is nil for inactive tokens
+type entry struct {
+	digest [sha256.Size]byte // of the token, to rule out cache key collisions
+	claims map[string]interface{}
+}
+
+// IntrospectionProvider authenticates opaque bearer tokens by asking the
+// authorization server's introspection endpoint.
+type IntrospectionProvider struct {
+	cfg    Config
+	client *http.Client
+	cache  *ristretto.Cache[uint64, *entry]
+	seed   maphash.Seed
+}
+
+// New creates an IntrospectionProvider.
+// Returns nil if the endpoint URL is invalid.
+func New(cfg Config) *IntrospectionProvider {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		slog.Error("introspection: invalid endpoint", "url", cfg.URL, "error", err)
+		return nil
+	}
+	if u.Scheme == "http" {
+		slog.Warn("introspection: endpoint is not using https, client secret and tokens are sent in clear text", "url", cfg.URL)
+	}
+
+	p := &IntrospectionProvider{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(http.DefaultTransport),
+		},
+		seed: maphash.MakeSeed(),
+	}
+
+	cache, err := ristretto.NewCache(&ristretto.Config[uint64, *entry]{
+		NumCounters: 100000, // number of keys to track frequency of.
+		MaxCost:     10000,  // maximum cost of cache (no-of-entries since we use cost=1).
+		BufferItems: 64,     // number of keys per Get buffer.
+	})
+	if err != nil {
+		slog.Warn("introspection: failed to create cache, every request will be introspected", "error", err)
+	}
+	p.cache = cache
+
+	slog.Info("introspection: creating auth provider", "url", cfg.URL, "client", cfg.ClientID)
+	return p
+}
+
+// Authenticate implements types.AuthProvider.
+// No bearer token → anonymous.
+// Inactive, expired or wrong-audience token → ErrAuthenticationFailed (anonymous in permissive mode).
+// Endpoint failure → ErrAuthenticationUnavailable, even in permissive mode.
+func (p *IntrospectionProvider) Authenticate(info *types.Info, r *http.Request) error {
+	token, ok := info.GetBearerToken(r, p.cfg.AuthHeader)
+	if len(token) == 0 || !ok {
+		slog.Debug("introspection: no bearer token, treating as anonymous")
+		return nil
+	}
+
+	e, err := p.lookup(r.Context(), string(token))
+	if err != nil {
+		slog.Error("introspection: endpoint unavailable", "url", p.cfg.URL, "error", err)
+		return types.ErrAuthenticationUnavailable
+	}
+	if e.claims == nil {
+		return p.reject("inactive token")
+	}
+	if len(p.cfg.Audiences) > 0 && !slices.ContainsFunc(audiences(e.claims["aud"]), func(aud string) bool {
+		return slices.Contains(p.cfg.Audiences, aud)
+	}) {
+		slog.Warn("introspection: audience mismatch", "expected", p.cfg.Audiences, "got", e.claims["aud"])
+		return p.reject("wrong audience")
+	}
+
+	info.JWT = e.claims
+	info.Request.Principal = principal(e.claims)
+	slog.Debug("introspection: authentication successful", "principal", info.Request.Principal)
+	return nil
+}
+
+// lookup returns the cached result for a token, or introspects it
+func (p *IntrospectionProvider) lookup(ctx context.Context, token string) (*entry, error) {
+	key := maphash.String(p.seed, token)
+	digest := sha256.Sum256([]byte(token))
+	if p.cache != nil {
+		e, found := p.cache.Get(key)
+		// another token with the same cache key is a miss
+		found = found && e.digest == digest
+		metrics.IncrementIntrospectionCache(found)
+		if found {
+			return e, nil
+		}
+	}
+
+	claims, err := p.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{digest: digest, claims: claims}
+	ttl := p.cfg.NegativeTTL
+	if claims != nil {
+		ttl = p.cfg.CacheTTL
+		if exp, ok := expiry(claims); ok {
+			ttl = min(ttl, time.Until(exp))
+		}
+	}
+	if p.cache != nil && ttl > 0 {
+		p.cache.SetWithTTL(key, e, 1, ttl)
+	}
+	return e, nil
+}
+
+// introspect asks the endpoint about a token, returning its claims when it
+// is active and not expired, nil otherwise
+func (p *IntrospectionProvider) introspect(ctx context.Context, token string) (map[string]interface{}, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientID != "" {
+		// RFC 6749 2.3.1: client credentials are form-encoded before basic auth
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		slog.Debug("introspection: token is not active")
+		return nil, nil
+	}
+	if exp, ok := expiry(claims); ok && !time.Now().Before(exp) {
+		slog.Debug("introspection: active token is expired", "exp", exp)
+		return nil, nil
+	}
+	delete(claims, "active")
+	return claims, nil
+}
+
+// reject returns ErrAuthenticationFailed in strict mode, or nil (anonymous) in permissive mode.
+func (p *IntrospectionProvider) reject(reason string) error {
+	if !p.cfg.Permissive {
+		slog.Warn("introspection: token rejected", "reason", reason)
+		return types.ErrAuthenticationFailed
+	}
+	slog.Debug(fmt.Sprintf("introspection: treating %s as anonymous (permissive mode)", reason))
+	return nil
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (p *IntrospectionProvider) Name() string {
+	return "introspection"
+}
+
+// expiry returns the "exp" claim (seconds since epoch)
+func expiry(claims map[string]interface{}) (time.Time, bool) {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// audiences returns the "aud" claim, a string or a list of strings
+func audiences(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// principal returns the identity of the token: the subject, the user name
+// or, for client-credentials tokens, the client
+func principal(claims map[string]interface{}) string {
+	for _, key := range []string{"sub", "username", "client_id"} {
+		if s, ok := claims[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
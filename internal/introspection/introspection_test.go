@@ -0,0 +1,249 @@
+package introspection
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+// fakeServer answers introspection requests from a token → response map
+type fakeServer struct {
+	*httptest.Server
+	calls atomic.Int32
+}
+
+func newFakeServer(t *testing.T, responses map[string]map[string]interface{}) *fakeServer {
+	t.Helper()
+	f := &fakeServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls.Add(1)
+		if user, pass, _ := r.BasicAuth(); user != "rest-rego" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.FormValue("token_type_hint") != "access_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, ok := responses[r.FormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestProvider(t *testing.T, url string, permissive bool) *IntrospectionProvider {
+	t.Helper()
+	p := New(Config{
+		URL:          url,
+		ClientID:     "rest-rego",
+		ClientSecret: "s3cret",
+		AuthHeader:   "Authorization",
+		CacheTTL:     time.Minute,
+		NegativeTTL:  time.Minute,
+		Timeout:      time.Second,
+		Permissive:   permissive,
+	})
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	return p
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// authenticate runs the provider and waits for the cache to settle
+func authenticate(p *IntrospectionProvider, token string) (*types.Info, error) {
+	info := &types.Info{}
+	err := p.Authenticate(info, bearerRequest(token))
+	p.cache.Wait()
+	return info, err
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_Active(t *testing.T) {
+	srv := newFakeServer(t, map[string]map[string]interface{}{
+		"opaque-1": {"active": true, "sub": "alice", "scope": "orders:read", "exp": time.Now().Add(time.Hour).Unix()},
+	})
+	p := newTestProvider(t, srv.URL, false)
+
+	info, err := authenticate(p, "opaque-1")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	claims, ok := info.JWT.(map[string]interface{})
+	if !ok || claims["scope"] != "orders:read" {
+		t.Errorf("Expected claims in info.JWT, got %v", info.JWT)
+	}
+	if _, ok := claims["active"]; ok {
+		t.Error("Expected active to be removed from the claims")
+	}
+	if info.Request.Principal != "alice" {
+		t.Errorf("Expected principal alice, got %q", info.Request.Principal)
+	}
+
+	// the second request is answered from the cache
+	if _, err := authenticate(p, "opaque-1"); err != nil {
+		t.Fatalf("Expected cached success, got %v", err)
+	}
+	if n := srv.calls.Load(); n != 1 {
+		t.Errorf("Expected 1 introspection call, got %d", n)
+	}
+}
+
+func TestLookup_CacheKeyCollision(t *testing.T) {
+	srv := newFakeServer(t, map[string]map[string]interface{}{
+		"opaque-1": {"active": true, "sub": "alice"},
+	})
+	p := newTestProvider(t, srv.URL, false)
+
+	if _, err := authenticate(p, "opaque-1"); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	e, found := p.cache.Get(maphash.String(p.seed, "opaque-1"))
+	if !found {
+		t.Fatal("Expected the token to be cached")
+	}
+
+	// store alice's entry under another token's cache key, as a collision would
+	p.cache.Set(maphash.String(p.seed, "opaque-2"), e, 1)
+	p.cache.Wait()
+	if _, err := authenticate(p, "opaque-2"); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the other token to be introspected and rejected, got %v", err)
+	}
+}
+
+func TestAuthenticate_ClientCredentialsPrincipal(t *testing.T) {
+	srv := newFakeServer(t, map[string]map[string]interface{}{
+		"opaque-2": {"active": true, "client_id": "batch-job"},
+	})
+	p := newTestProvider(t, srv.URL, false)
+
+	info, err := authenticate(p, "opaque-2")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if info.Request.Principal != "batch-job" {
+		t.Errorf("Expected client id as principal, got %q", info.Request.Principal)
+	}
+}
+
+func TestAuthenticate_NoToken_Anonymous(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	p := newTestProvider(t, srv.URL, false)
+
+	info, err := authenticate(p, "")
+	if err != nil || info.JWT != nil {
+		t.Errorf("Expected anonymous, got %v %v", info.JWT, err)
+	}
+	if n := srv.calls.Load(); n != 0 {
+		t.Errorf("Expected no introspection call, got %d", n)
+	}
+}
+
+func TestAuthenticate_Inactive(t *testing.T) {
+	srv := newFakeServer(t, map[string]map[string]interface{}{
+		"expired": {"active": true, "sub": "bob", "exp": time.Now().Add(-time.Minute).Unix()},
+	})
+
+	for _, token := range []string{"revoked", "expired"} {
+		t.Run(token+" strict", func(t *testing.T) {
+			p := newTestProvider(t, srv.URL, false)
+			before := srv.calls.Load()
+
+			for range 2 {
+				if _, err := authenticate(p, token); !errors.Is(err, types.ErrAuthenticationFailed) {
+					t.Fatalf("Expected ErrAuthenticationFailed, got %v", err)
+				}
+			}
+			if n := srv.calls.Load() - before; n != 1 {
+				t.Errorf("Expected the inactive result to be cached, got %d calls", n)
+			}
+		})
+		t.Run(token+" permissive", func(t *testing.T) {
+			p := newTestProvider(t, srv.URL, true)
+			info, err := authenticate(p, token)
+			if err != nil || info.JWT != nil {
+				t.Errorf("Expected anonymous, got %v %v", info.JWT, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_Audience(t *testing.T) {
+	srv := newFakeServer(t, map[string]map[string]interface{}{
+		"for-us":    {"active": true, "sub": "alice", "aud": []string{"api://other", "api://shop"}},
+		"for-other": {"active": true, "sub": "alice", "aud": "api://other"},
+	})
+	p := newTestProvider(t, srv.URL, false)
+	p.cfg.Audiences = []string{"api://shop"}
+
+	if _, err := authenticate(p, "for-us"); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	if _, err := authenticate(p, "for-other"); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+}
+
+func TestAuthenticate_Unavailable(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	}))
+	defer garbage.Close()
+	wrongSecret := newFakeServer(t, nil)
+
+	for name, url := range map[string]string{
+		"5xx":          failing.URL,
+		"invalid json": garbage.URL,
+		"unreachable":  "http://127.0.0.1:1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			// fail closed, even in permissive mode
+			p := newTestProvider(t, url, true)
+			if _, err := authenticate(p, "opaque"); !errors.Is(err, types.ErrAuthenticationUnavailable) {
+				t.Errorf("Expected ErrAuthenticationUnavailable, got %v", err)
+			}
+		})
+	}
+
+	t.Run("rejected client credentials", func(t *testing.T) {
+		p := newTestProvider(t, wrongSecret.URL, false)
+		p.cfg.ClientSecret = "wrong"
+		if _, err := authenticate(p, "opaque"); !errors.Is(err, types.ErrAuthenticationUnavailable) {
+			t.Errorf("Expected ErrAuthenticationUnavailable, got %v", err)
+		}
+	})
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	for _, url := range []string{"", "ftp://example.com/introspect", "://"} {
+		if p := New(Config{URL: url}); p != nil {
+			t.Errorf("Expected nil provider for %q", url)
+		}
+	}
+}
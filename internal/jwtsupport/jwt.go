@@ -3,18 +3,28 @@ package jwtsupport
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AB-Lindex/go-resthelp"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/fsnotify/fsnotify"
+	"github.com/lestrrat-go/httprc"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type JWTSupport struct {
@@ -26,8 +36,21 @@ type JWTSupport struct {
 	cache         *jwk.Cache
 	JWKS          []jwk.Set
 	permissive    bool // true = treat auth failures as anonymous
+	claims        []requiredClaim
+	skew          time.Duration
+	maxAge        time.Duration
+	sources       atomic.Pointer[sourceIndex]
+	watcher       *fsnotify.Watcher
+	tokens        *ristretto.Cache[uint64, *cachedToken]
+	seed          maphash.Seed
+	cacheTTL      time.Duration
 }
 
+var errSourceTypeMismatch = errors.New("well-known and jwks_uri source types differ")
+
+// errNoIssuer is returned for well-known documents without an issuer
+var errNoIssuer = errors.New("well-known has no issuer")
+
 var algConverter sync.Map
 
 func getAlgorithm(name string) jwa.KeyAlgorithm {
@@ -40,10 +63,13 @@ func getAlgorithm(name string) jwa.KeyAlgorithm {
 }
 
 type wellKnownData struct {
+	Issuer              string   `json:"issuer"`
 	JwksURI             string   `json:"jwks_uri"`
 	SupportedAlgorithms []string `json:"id_token_signing_alg_values_supported"`
 	sourceURL           string   // original well-known URL used to load this data
 	isLocalFile         bool     // true if loaded from file: URL, false if from HTTP(S)
+	fingerprint         atomic.Uint64
+	generation          atomic.Uint64 // incremented when the keys rotate
 }
 
 // PostFetch is a function that is called after the JWKS is fetched from the
@@ -75,16 +101,62 @@ func (wkd *wellKnownData) PostFetch(url string, set jwk.Set) (jwk.Set, error) {
 	}
 	// fmt.Println("--postfetch--end--")
 	// fmt.Println()
+	if fp := keysFingerprint(newset); wkd.fingerprint.Swap(fp) != fp {
+		wkd.generation.Add(1)
+	}
+	metrics.ObserveJWKSRefresh(url, newset.Len(), nil)
 	return newset, nil
 }
 
-func New(wellKnowns []string, audKey string, audList []string, kind string, permissive bool) *JWTSupport {
+// refreshErrSink counts the JWKS refreshes failing in the background
+var refreshErrSink = httprc.ErrSinkFunc(func(err error) {
+	url := ""
+	var re *httprc.RefreshError
+	if errors.As(err, &re) {
+		url = re.URL
+	}
+	slog.Warn("jwtsupport: jwks refresh failed", "url", url, "error", err)
+	metrics.ObserveJWKSRefresh(url, 0, err)
+})
+
+// getKeySet gets a JWKS from the cache, fetching it if it was never fetched
+// successfully before. The lookup is traced and a failed fetch is counted.
+func getKeySet(ctx context.Context, cache *jwk.Cache, url string) (jwk.Set, error) {
+	ctx, span := tracing.Start(ctx, "jwks", attribute.String("jwks.url", url))
+	set, err := cache.Get(ctx, url)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.ObserveJWKSRefresh(url, 0, err)
+	}
+	return set, err
+}
+
+func New(wellKnowns []string, audKey string, audList []string, kind string, permissive bool, v Validation, cacheTTL time.Duration) *JWTSupport {
+	claims, err := parseRequiredClaims(v.RequiredClaims)
+	if err != nil {
+		slog.Error("jwtsupport: invalid required claims", "error", err)
+		os.Exit(1)
+	}
+
 	j := &JWTSupport{
 		wellKnowns:  wellKnowns,
 		audienceKey: audKey,
 		audiences:   audList,
 		authKind:    kind,
 		permissive:  permissive,
+		claims:      claims,
+		skew:        v.ClockSkew,
+		maxAge:      v.MaxAge,
+		seed:        maphash.MakeSeed(),
+		cacheTTL:    cacheTTL,
+	}
+
+	if cacheTTL > 0 {
+		tokens, err := newTokenCache()
+		if err != nil {
+			slog.Warn("jwtsupport: failed to create token cache, every token will be verified", "error", err)
+		}
+		j.tokens = tokens
 	}
 
 	j.LoadWellKnowns()
@@ -98,6 +170,7 @@ func New(wellKnowns []string, audKey string, audList []string, kind string, perm
 		slog.Error("jwtsupport: no audiences to match")
 		os.Exit(1)
 	}
+	j.watchFiles()
 
 	return j
 }
@@ -107,113 +180,146 @@ func (j *JWTSupport) LoadWellKnowns() {
 		if wellKnown == "" {
 			continue
 		}
-		slog.Debug("jwtsupport: loading well-known", "url", wellKnown)
+		wc, err := loadWellKnown(wellKnown)
+		if err != nil {
+			continue
+		}
+		j.wellknownList = append(j.wellknownList, wc)
+	}
+}
 
-		var wc wellKnownData
+// loadWellKnown fetches and parses a single well-known document from either a
+// file: URL or HTTP(S). Failures are logged here, the caller decides whether
+// to skip the entry or abort.
+func loadWellKnown(wellKnown string) (*wellKnownData, error) {
+	slog.Debug("jwtsupport: loading well-known", "url", wellKnown)
 
-		if isFileURL(wellKnown) {
-			// Load well-known from file
-			data, err := readFileURL(wellKnown)
-			if err != nil {
-				slog.Error("jwtsupport: failed to read well-known file", "url", wellKnown, "error", err)
-				continue
-			}
+	var wc wellKnownData
 
-			if err := json.Unmarshal(data, &wc); err != nil {
-				slog.Error("jwtsupport: failed to parse well-known file", "url", wellKnown, "error", err)
-				continue
-			}
+	if isFileURL(wellKnown) {
+		// Load well-known from file
+		data, err := readFileURL(wellKnown)
+		if err != nil {
+			slog.Error("jwtsupport: failed to read well-known file", "url", wellKnown, "error", err)
+			return nil, err
+		}
 
-			wc.isLocalFile = true
-			slog.Info("jwtsupport: loaded well-known from file", "url", wellKnown)
-		} else {
-			// Load well-known from HTTP(S)
-			helper := resthelp.New()
-			req, err := helper.Get(wellKnown)
-			if err != nil {
-				slog.Error("jwtsupport: failed to init well-known", "url", wellKnown, "error", err)
-				continue
-			}
+		if err := json.Unmarshal(data, &wc); err != nil {
+			slog.Error("jwtsupport: failed to parse well-known file", "url", wellKnown, "error", err)
+			return nil, err
+		}
 
-			resp, err := req.Do()
-			if err != nil {
-				slog.Error("jwtsupport: failed to get well-known", "url", wellKnown, "error", err)
-				continue
-			}
+		wc.isLocalFile = true
+		slog.Info("jwtsupport: loaded well-known from file", "url", wellKnown)
+	} else {
+		// Load well-known from HTTP(S)
+		helper := resthelp.New()
+		req, err := helper.Get(wellKnown)
+		if err != nil {
+			slog.Error("jwtsupport: failed to init well-known", "url", wellKnown, "error", err)
+			return nil, err
+		}
 
-			if err = resp.ParseJSON(&wc); err != nil {
-				slog.Error("jwtsupport: failed to parse well-known", "url", wellKnown, "error", err)
-				continue
-			}
-			wc.isLocalFile = false
+		resp, err := req.Do()
+		if err != nil {
+			slog.Error("jwtsupport: failed to get well-known", "url", wellKnown, "error", err)
+			return nil, err
 		}
 
-		// Record the source URL for this well-known data
-		wc.sourceURL = wellKnown
-		j.wellknownList = append(j.wellknownList, &wc)
+		if err = resp.ParseJSON(&wc); err != nil {
+			slog.Error("jwtsupport: failed to parse well-known", "url", wellKnown, "error", err)
+			return nil, err
+		}
+		wc.isLocalFile = false
+	}
+
+	// Without an issuer the keys could not be tied to the tokens they issue
+	if wc.Issuer == "" {
+		slog.Error("jwtsupport: well-known has no issuer", "url", wellKnown)
+		return nil, errNoIssuer
 	}
+
+	// Record the source URL for this well-known data
+	wc.sourceURL = wellKnown
+	return &wc, nil
 }
 
 func (j *JWTSupport) LoadJWKS() {
 
 	j.cache = jwk.NewCache(context.Background(),
 		jwk.WithRefreshWindow(2*time.Minute),
+		jwk.WithErrSink(refreshErrSink),
 		// jwk.WithRefreshWindow(24*time.Hour),
 	)
 
+	var sources []*KeySource
 	for _, wk := range j.wellknownList {
-		// Validate source-type consistency: well-known and jwks_uri must use matching source types
-		if isFileURL(wk.sourceURL) != isFileURL(wk.JwksURI) {
-			slog.Error("jwtsupport: source type mismatch",
-				"well-known", wk.sourceURL,
-				"well-known-type", sourceType(wk.sourceURL),
-				"jwks-uri", wk.JwksURI,
-				"jwks-type", sourceType(wk.JwksURI),
-				"error", "well-known and jwks_uri must use matching source types (both file or both http)")
+		set, err := loadJWKS(j.cache, wk)
+		if err != nil {
 			continue
 		}
+		j.JWKS = append(j.JWKS, set)
 
-		if isFileURL(wk.JwksURI) {
-			// Load JWKS from file
-			data, err := readFileURL(wk.JwksURI)
-			if err != nil {
-				slog.Error("jwtsupport: failed to read jwks file", "url", wk.JwksURI, "error", err)
-				continue
-			}
+		sources = append(sources, &KeySource{wk: wk, cache: j.cache, set: set})
+	}
+	j.sources.Store(newSourceIndex(sources))
+}
 
-			set, err := jwk.Parse(data)
-			if err != nil {
-				slog.Error("jwtsupport: failed to parse jwks file", "url", wk.JwksURI, "error", err)
-				continue
-			}
+// loadJWKS loads the key set referenced by a well-known document. File-based
+// sets are parsed once, HTTP(S) sets are registered with the cache so they are
+// refreshed in the background.
+func loadJWKS(cache *jwk.Cache, wk *wellKnownData) (jwk.Set, error) {
+	// Validate source-type consistency: well-known and jwks_uri must use matching source types
+	if isFileURL(wk.sourceURL) != isFileURL(wk.JwksURI) {
+		slog.Error("jwtsupport: source type mismatch",
+			"well-known", wk.sourceURL,
+			"well-known-type", sourceType(wk.sourceURL),
+			"jwks-uri", wk.JwksURI,
+			"jwks-type", sourceType(wk.JwksURI),
+			"error", "well-known and jwks_uri must use matching source types (both file or both http)")
+		return nil, errSourceTypeMismatch
+	}
 
-			// Apply PostFetch to enrich keys with algorithms if needed
-			set, err = wk.PostFetch(wk.JwksURI, set)
-			if err != nil {
-				slog.Error("jwtsupport: failed to post-process jwks", "url", wk.JwksURI, "error", err)
-				continue
-			}
+	if isFileURL(wk.JwksURI) {
+		// Load JWKS from file
+		data, err := readFileURL(wk.JwksURI)
+		if err != nil {
+			slog.Error("jwtsupport: failed to read jwks file", "url", wk.JwksURI, "error", err)
+			return nil, err
+		}
 
-			slog.Info("jwtsupport: loaded jwks from file", "url", wk.JwksURI, "keys", set.Len())
-			j.JWKS = append(j.JWKS, set)
-		} else {
-			// Load JWKS from HTTP(S)
-			err := j.cache.Register(wk.JwksURI, jwk.WithPostFetcher(wk)) //, jwk.WithPostFetcher(postfetch))
-			if err != nil {
-				slog.Error("jwtsupport: failed to register jwks", "url", wk.JwksURI, "error", err)
-				continue
-			}
+		set, err := jwk.Parse(data)
+		if err != nil {
+			slog.Error("jwtsupport: failed to parse jwks file", "url", wk.JwksURI, "error", err)
+			return nil, err
+		}
 
-			_, err = j.cache.Get(context.Background(), wk.JwksURI)
-			if err != nil {
-				slog.Error("jwtsupport: failed to get jwks", "url", wk.JwksURI, "error", err)
-				continue
-			}
-			cachedset := jwk.NewCachedSet(j.cache, wk.JwksURI)
-			slog.Info("jwtsupport: loaded jwks", "url", wk.JwksURI, "keys", cachedset.Len())
-			j.JWKS = append(j.JWKS, cachedset)
+		// Apply PostFetch to enrich keys with algorithms if needed
+		set, err = wk.PostFetch(wk.JwksURI, set)
+		if err != nil {
+			slog.Error("jwtsupport: failed to post-process jwks", "url", wk.JwksURI, "error", err)
+			return nil, err
 		}
+
+		slog.Info("jwtsupport: loaded jwks from file", "url", wk.JwksURI, "keys", set.Len())
+		return set, nil
 	}
+
+	// Load JWKS from HTTP(S)
+	err := cache.Register(wk.JwksURI, jwk.WithPostFetcher(wk)) //, jwk.WithPostFetcher(postfetch))
+	if err != nil {
+		slog.Error("jwtsupport: failed to register jwks", "url", wk.JwksURI, "error", err)
+		return nil, err
+	}
+
+	_, err = getKeySet(context.Background(), cache, wk.JwksURI)
+	if err != nil {
+		slog.Error("jwtsupport: failed to get jwks", "url", wk.JwksURI, "error", err)
+		return nil, err
+	}
+	cachedset := jwk.NewCachedSet(cache, wk.JwksURI)
+	slog.Info("jwtsupport: loaded jwks", "url", wk.JwksURI, "keys", cachedset.Len())
+	return cachedset, nil
 }
 
 func (j *JWTSupport) Authenticate(info *types.Info, r *http.Request) error {
@@ -237,86 +343,102 @@ func (j *JWTSupport) Authenticate(info *types.Info, r *http.Request) error {
 		return nil
 	}
 
-	request := []byte(info.Request.Auth.Token)
-	lastError := error(nil)
-
-	// Try to validate token against all configured issuers
-	for i, wc := range j.wellknownList {
-		var ks jwk.Set
-		var err error
-
-		if wc.isLocalFile {
-			// Use static JWKS loaded from file at startup
-			ks = j.JWKS[i]
-		} else {
-			// Fetch fresh JWKS from cache (with automatic refresh)
-			ks, err = j.cache.Get(context.Background(), wc.JwksURI)
-			if err != nil {
-				slog.Warn("jwtsupport: failed to fetch JWKS", "url", wc.JwksURI, "error", err)
-				lastError = err
-				continue
-			}
-		}
-
-		for _, aud := range j.audiences {
-			slog.Debug("jwtsupport: validating token", "issuer", wc.JwksURI, "aud", aud)
-
-			var options []jwt.ParseOption
-			if ks.Len() == 1 {
-				if key, ok := ks.Key(0); ok {
-					options = append(options, jwt.WithKey(key.Algorithm(), key))
-				}
-			} else {
-				options = append(options, jwt.WithKeySet(ks))
-			}
-
-			options = append(options, jwt.WithValidate(true))
-			options = append(options, jwt.WithVerify(true))
-
-			if j.audienceKey == "aud" {
-				options = append(options, jwt.WithAudience(aud))
-			} else {
-				options = append(options, jwt.WithClaimValue(j.audienceKey, aud))
-			}
-
-			token, err := jwt.Parse(request, options...)
-			if err != nil {
-				slog.Debug("jwtsupport: token validation failed", "aud", aud, "error", err)
-				lastError = err
-				continue
-			}
+	raw := info.Request.Auth.Token
+	if e, found := j.cachedTokenFor(raw); found {
+		info.JWT = e.claims
+		info.Request.Principal = e.subject
+		slog.Info("jwtsupport: authentication successful", "issuer", e.source.Issuer(), "cached", true)
+		return nil
+	}
 
-			// SUCCESS: Valid token
-			// Use request context so claim extraction is bounded to request lifetime.
-			fields, _ := token.AsMap(r.Context())
-			info.JWT = fields
-			slog.Info("jwtsupport: authentication successful", "aud", aud)
-			return nil
+	// Parse once without verifying, to select the issuer and key
+	token, err := parseUnverified([]byte(raw))
+	if err != nil {
+		return j.reject(err)
+	}
+	index := j.sources.Load()
+	sources := index.sourcesFor(token.claims)
+	if len(sources) == 0 {
+		if index == nil || len(index.all) == 0 {
+			slog.Error("jwtsupport: no well-known endpoints configured")
+			return types.ErrAuthenticationUnavailable
 		}
+		return j.reject(fmt.Errorf("%w: %q", errUnknownIssuer, token.claims.Issuer()))
 	}
 
-	// Case 3: Token validation failed for all issuers
-	if lastError != nil {
-		if !j.permissive {
-			// Strict mode: check if it's a system error vs validation error
-			errStr := lastError.Error()
-			if strings.Contains(errStr, "failed to fetch") ||
-				strings.Contains(errStr, "connection") ||
-				strings.Contains(errStr, "timeout") {
-				slog.Error("jwtsupport: authentication system unavailable (strict mode)", "error", lastError)
-				return types.ErrAuthenticationUnavailable
-			}
+	// Verify the signature once, with the key of the token's issuer
+	var lastError error
+	for _, src := range sources {
+		generation := src.generation() // before fetching, a rotation meanwhile only makes the entry stale
+		ks, err := src.KeySet(r.Context())
+		if err != nil {
+			slog.Warn("jwtsupport: failed to fetch JWKS", "url", src.wk.JwksURI, "error", err)
+			lastError = err
+			continue
+		}
+		key, err := token.selectKey(ks)
+		if err == nil {
+			err = token.verify(key)
+		}
+		if err != nil {
+			slog.Debug("jwtsupport: no valid signature", "issuer", src.Issuer(), "kid", token.kid, "reason", failureReason(err), "error", err)
+			lastError = err
+			continue
+		}
 
-			slog.Warn("jwtsupport: token validation failed, rejecting (strict mode)", "error", lastError)
-			return types.ErrAuthenticationFailed
+		if err := jwt.Validate(token.claims, j.validateOptions()...); err != nil {
+			return j.reject(err)
 		}
 
-		// Permissive mode: treat validation failure as anonymous
-		slog.Debug("jwtsupport: token validation failed, treating as anonymous (permissive mode)", "error", lastError)
+		// SUCCESS: Valid token
+		// Use request context so claim extraction is bounded to request lifetime.
+		fields, _ := token.claims.AsMap(r.Context())
+		info.JWT = fields
+		info.Request.Principal = token.claims.Subject()
+		j.cacheToken(raw, &cachedToken{claims: fields, subject: info.Request.Principal, source: src, generation: generation}, token.claims)
+		slog.Info("jwtsupport: authentication successful", "issuer", src.Issuer())
 		return nil
 	}
 
-	// Case 4: No well-known endpoints configured
-	slog.Error("jwtsupport: no well-known endpoints configured")
-	return types.ErrAuthenticationUnavailable
+	if errors.Is(lastError, ErrKeySetUnavailable) && !j.permissive {
+		slog.Error("jwtsupport: authentication system unavailable (strict mode)", "error", lastError)
+		return types.ErrAuthenticationUnavailable
+	}
+	return j.reject(lastError)
+}
+
+// validateOptions checks the claims of a token with a verified signature,
+// the audience against all configured audiences at once
+func (j *JWTSupport) validateOptions() []jwt.ValidateOption {
+	options := []jwt.ValidateOption{
+		jwt.WithAcceptableSkew(j.skew),
+		jwt.WithValidator(audienceValidator(j.audienceKey, j.audiences)),
+	}
+	if j.maxAge > 0 {
+		options = append(options, jwt.WithValidator(maxAgeValidator(j.maxAge)))
+	}
+	for _, c := range j.claims {
+		options = append(options, jwt.WithValidator(c.validator()))
+	}
+	return options
+}
+
+// reject logs why a token failed, returning ErrAuthenticationFailed in strict
+// mode, or nil (anonymous) in permissive mode.
+func (j *JWTSupport) reject(err error) error {
+	reason := failureReason(err)
+	slog.Debug("jwtsupport: token validation failed", "reason", reason, "error", err)
+	if !j.permissive {
+		slog.Warn("jwtsupport: token validation failed, rejecting (strict mode)", "reason", reason, "error", err)
+		return types.ErrAuthenticationFailed
+	}
+
+	// Permissive mode: treat validation failure as anonymous
+	slog.Debug("jwtsupport: token validation failed, treating as anonymous (permissive mode)", "reason", reason)
+	return nil
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (j *JWTSupport) Name() string {
+	return "jwt"
 }
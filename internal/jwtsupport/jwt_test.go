@@ -24,6 +24,7 @@ func TestLoadWellKnowns_ValidFile(t *testing.T) {
 
 	// Create a valid well-known.json file
 	validJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file:///` + tmpDir + `/jwks.json",
 		"id_token_signing_alg_values_supported": ["RS256", "ES256"]
 	}`
@@ -138,6 +139,7 @@ func TestLoadWellKnowns_MultipleFiles(t *testing.T) {
 	// Create first well-known file
 	wellKnown1Path := filepath.Join(tmpDir, "well-known-1.json")
 	json1 := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file:///` + tmpDir + `/jwks1.json",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -148,6 +150,7 @@ func TestLoadWellKnowns_MultipleFiles(t *testing.T) {
 	// Create second well-known file
 	wellKnown2Path := filepath.Join(tmpDir, "well-known-2.json")
 	json2 := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file:///` + tmpDir + `/jwks2.json",
 		"id_token_signing_alg_values_supported": ["ES256"]
 	}`
@@ -188,6 +191,7 @@ func TestLoadWellKnowns_MixedValidInvalid(t *testing.T) {
 	// Create a valid well-known file
 	validPath := filepath.Join(tmpDir, "valid.json")
 	validJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file:///` + tmpDir + `/jwks.json",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -483,6 +487,7 @@ func TestLoadJWKS_SourceTypeMismatch_FileToHTTP(t *testing.T) {
 	// Create a well-known file that points to an HTTP jwks_uri (mismatch)
 	wellKnownPath := filepath.Join(tmpDir, "well-known.json")
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "https://example.com/jwks.json",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -548,6 +553,7 @@ func TestLoadJWKS_SourceTypeMatch(t *testing.T) {
 	jwksPath := filepath.Join(tmpDir, "jwks.json")
 
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -647,6 +653,7 @@ func TestAuthenticate_FileBasedKeys(t *testing.T) {
 
 	// Create well-known file
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -674,6 +681,9 @@ func TestAuthenticate_FileBasedKeys(t *testing.T) {
 
 	// Create a valid JWT token
 	token := jwt.New()
+	if err := token.Set(jwt.IssuerKey, "https://issuer.example.com"); err != nil {
+		t.Fatalf("Failed to set issuer: %v", err)
+	}
 	if err := token.Set(jwt.AudienceKey, "test-audience"); err != nil {
 		t.Fatalf("Failed to set audience: %v", err)
 	}
@@ -765,6 +775,10 @@ func TestAuthenticate_FileBasedKeys(t *testing.T) {
 	if sub, ok := jwtMap["sub"].(string); !ok || sub != "test-user" {
 		t.Errorf("Expected sub claim to be 'test-user', got %v", jwtMap["sub"])
 	}
+
+	if info.Request.Principal != "test-user" {
+		t.Errorf("Expected principal to be the subject, got %q", info.Request.Principal)
+	}
 }
 
 // TestAuthenticate_FileBasedKeys_InvalidToken tests that invalid tokens are rejected
@@ -813,6 +827,7 @@ func TestAuthenticate_FileBasedKeys_InvalidToken(t *testing.T) {
 
 	// Create well-known file
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -929,6 +944,7 @@ func BenchmarkAuthenticate(b *testing.B) {
 		b.Fatalf("Failed to write JWKS file: %v", err)
 	}
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -949,6 +965,7 @@ func BenchmarkAuthenticate(b *testing.B) {
 	// Build a valid, long-lived token once – we want to benchmark the validation
 	// path, not token signing.
 	tok := jwt.New()
+	_ = tok.Set(jwt.IssuerKey, "https://issuer.example.com")
 	_ = tok.Set(jwt.AudienceKey, "bench-audience")
 	_ = tok.Set(jwt.SubjectKey, "bench-user")
 	_ = tok.Set(jwt.IssuedAtKey, time.Now().Unix())
@@ -1018,6 +1035,7 @@ func BenchmarkAuthenticate_MultipleAudiences(b *testing.B) {
 		b.Fatalf("Failed to write JWKS file: %v", err)
 	}
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -1037,6 +1055,7 @@ func BenchmarkAuthenticate_MultipleAudiences(b *testing.B) {
 	j.LoadJWKS()
 
 	tok := jwt.New()
+	_ = tok.Set(jwt.IssuerKey, "https://issuer.example.com")
 	_ = tok.Set(jwt.AudienceKey, "bench-audience")
 	_ = tok.Set(jwt.SubjectKey, "bench-user")
 	_ = tok.Set(jwt.IssuedAtKey, time.Now().Unix())
@@ -1114,6 +1133,7 @@ func TestAuthenticate_FileBasedKeys_WrongAudience(t *testing.T) {
 
 	// Create well-known file
 	wellKnownJSON := `{
+		"issuer": "https://issuer.example.com",
 		"jwks_uri": "file://` + jwksPath + `",
 		"id_token_signing_alg_values_supported": ["RS256"]
 	}`
@@ -1136,6 +1156,9 @@ func TestAuthenticate_FileBasedKeys_WrongAudience(t *testing.T) {
 
 	// Create a JWT token with WRONG audience
 	token := jwt.New()
+	if err := token.Set(jwt.IssuerKey, "https://issuer.example.com"); err != nil {
+		t.Fatalf("Failed to set issuer: %v", err)
+	}
 	if err := token.Set(jwt.AudienceKey, "wrong-audience"); err != nil {
 		t.Fatalf("Failed to set audience: %v", err)
 	}
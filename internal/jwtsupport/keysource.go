@@ -0,0 +1,125 @@
+package jwtsupport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// ErrKeySetUnavailable is returned when the JWKS for an issuer cannot be fetched.
+var ErrKeySetUnavailable = errors.New("jwtsupport: key set unavailable")
+
+// tenantPlaceholder is used in the issuer of multi-tenant Azure AD well-known
+// documents, it stands for the token's "tid" claim.
+const tenantPlaceholder = "{tenantid}"
+
+// KeySource is a single OIDC issuer and its JWKS, kept fresh with the same
+// jwk.Cache refresh machinery as JWTSupport. It lets other auth providers
+// verify tokens locally without duplicating the well-known handling.
+type KeySource struct {
+	wk    *wellKnownData
+	cache *jwk.Cache
+	set   jwk.Set
+}
+
+// NewKeySource loads the well-known document at wellKnown (file: or HTTP(S))
+// and the JWKS it references.
+func NewKeySource(ctx context.Context, wellKnown string) (*KeySource, error) {
+	wk, err := loadWellKnown(wellKnown)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := jwk.NewCache(ctx,
+		jwk.WithRefreshWindow(2*time.Minute),
+		jwk.WithErrSink(refreshErrSink),
+	)
+
+	set, err := loadJWKS(cache, wk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySource{
+		wk:    wk,
+		cache: cache,
+		set:   set,
+	}, nil
+}
+
+// Issuer returns the issuer advertised by the well-known document.
+func (ks *KeySource) Issuer() string {
+	return ks.wk.Issuer
+}
+
+// KeySet returns the current key set, refreshing it from the cache when the
+// JWKS is served over HTTP(S).
+func (ks *KeySource) KeySet(ctx context.Context) (jwk.Set, error) {
+	if ks.wk.isLocalFile {
+		return ks.set, nil
+	}
+	set, err := getKeySet(ctx, ks.cache, ks.wk.JwksURI)
+	if err != nil {
+		return nil, errors.Join(ErrKeySetUnavailable, err)
+	}
+	return set, nil
+}
+
+// generation changes when the keys of the source rotate
+func (ks *KeySource) generation() uint64 {
+	return ks.wk.generation.Load()
+}
+
+// issues reports if a key source may have issued a token: its issuer is the
+// token's, with the tenant placeholder of multi-tenant issuers resolved from
+// the "tid" claim.
+func (ks *KeySource) issues(t jwt.Token) bool {
+	issuer := ks.Issuer()
+	if !strings.Contains(issuer, tenantPlaceholder) {
+		return t.Issuer() == issuer
+	}
+	tid, _ := t.Get("tid")
+	tenant, ok := tid.(string)
+	return ok && tenant != "" && t.Issuer() == strings.ReplaceAll(issuer, tenantPlaceholder, tenant)
+}
+
+// sourceIndex selects the key sources that may have issued a token
+type sourceIndex struct {
+	all      []*KeySource
+	byIssuer map[string][]*KeySource
+	unpinned []*KeySource // with a tenant placeholder
+}
+
+func newSourceIndex(sources []*KeySource) *sourceIndex {
+	x := &sourceIndex{all: sources, byIssuer: make(map[string][]*KeySource)}
+	for _, src := range sources {
+		if issuer := src.Issuer(); strings.Contains(issuer, tenantPlaceholder) {
+			x.unpinned = append(x.unpinned, src)
+		} else {
+			x.byIssuer[issuer] = append(x.byIssuer[issuer], src)
+		}
+	}
+	return x
+}
+
+// sourcesFor returns the key sources that may have issued a token: those of
+// its issuer, else those with a matching tenant placeholder.
+func (x *sourceIndex) sourcesFor(claims jwt.Token) []*KeySource {
+	if x == nil {
+		return nil
+	}
+	if sources, ok := x.byIssuer[claims.Issuer()]; ok {
+		return sources
+	}
+	var sources []*KeySource
+	for _, src := range x.unpinned {
+		if src.issues(claims) {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
@@ -0,0 +1,84 @@
+package jwtsupport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnknownIssuer    = errors.New("unknown issuer")
+	errUnknownKey       = errors.New("unknown key")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// unverifiedToken is a compact JWS token whose header and claims are parsed,
+// but whose signature is not verified yet. It is used to select the issuer
+// and key, so the signature is verified once.
+type unverifiedToken struct {
+	raw    []byte
+	kid    string
+	alg    jwa.SignatureAlgorithm
+	claims jwt.Token
+}
+
+// parseUnverified parses a compact JWS token without verifying it
+func parseUnverified(raw []byte) (*unverifiedToken, error) {
+	msg, err := jws.Parse(raw, jws.WithCompact())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+	sigs := msg.Signatures()
+	if len(sigs) != 1 {
+		return nil, fmt.Errorf("%w: %d signatures", errMalformedToken, len(sigs))
+	}
+	headers := sigs[0].ProtectedHeaders()
+	alg := headers.Algorithm()
+	if alg == "" || alg == jwa.NoSignature {
+		return nil, fmt.Errorf("%w: unsigned", errMalformedToken)
+	}
+
+	claims := jwt.New()
+	if err := json.Unmarshal(msg.Payload(), claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+	return &unverifiedToken{raw: raw, kid: headers.KeyID(), alg: alg, claims: claims}, nil
+}
+
+// selectKey returns the key of a set that signed the token: the one with its
+// kid and algorithm. Keys without kid match any kid, and a token without kid
+// is only matched when a single key has its algorithm.
+func (t *unverifiedToken) selectKey(set jwk.Set) (jwk.Key, error) {
+	var found jwk.Key
+	for i := range set.Len() {
+		key, ok := set.Key(i)
+		if !ok || key.Algorithm().String() != t.alg.String() {
+			continue
+		}
+		if t.kid != "" && key.KeyID() != "" && key.KeyID() != t.kid {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: several keys match kid %q and alg %s", errUnknownKey, t.kid, t.alg)
+		}
+		found = key
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: no key with kid %q and alg %s", errUnknownKey, t.kid, t.alg)
+	}
+	return found, nil
+}
+
+// verify checks the signature of the token with a key
+func (t *unverifiedToken) verify(key jwk.Key) error {
+	if _, err := jws.Verify(t.raw, jws.WithKey(t.alg, key), jws.WithCompact()); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package jwtsupport
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// signToken signs a valid token for test-audience from the issuer
+func signToken(t testing.TB, key jwk.Key, issuer string) string {
+	t.Helper()
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, issuer)
+	token.Set(jwt.AudienceKey, "test-audience")
+	token.Set(jwt.SubjectKey, "test-user")
+	token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return string(signed)
+}
+
+// TestParseUnverified tests that the header and claims are parsed without keys
+func TestParseUnverified(t *testing.T) {
+	_, key := writeIssuer(t, testIssuer, "test-key-1")
+
+	token, err := parseUnverified([]byte(signToken(t, key, testIssuer)))
+	if err != nil {
+		t.Fatalf("Expected token to parse, got %v", err)
+	}
+	if token.kid != "test-key-1" || token.alg != jwa.RS256 || token.claims.Issuer() != testIssuer {
+		t.Errorf("Unexpected token kid=%q alg=%s iss=%q", token.kid, token.alg, token.claims.Issuer())
+	}
+
+	unsigned := "eyJhbGciOiJub25lIn0.eyJpc3MiOiJodHRwczovL2lzc3Vlci5leGFtcGxlLmNvbSJ9."
+	for _, raw := range []string{"", "not.a.valid.jwt", "thisisnotavalidtoken", unsigned} {
+		if _, err := parseUnverified([]byte(raw)); !errors.Is(err, errMalformedToken) {
+			t.Errorf("%q: expected errMalformedToken, got %v", raw, err)
+		}
+	}
+}
+
+// TestSelectKey tests that the key is selected by kid and algorithm
+func TestSelectKey(t *testing.T) {
+	_, key1 := writeIssuer(t, testIssuer, "key-1")
+	_, key2 := writeIssuer(t, testIssuer, "key-2")
+	set := jwk.NewSet()
+	for _, key := range []jwk.Key{key1, key2} {
+		public, _ := key.PublicKey()
+		set.AddKey(public)
+	}
+
+	token, _ := parseUnverified([]byte(signToken(t, key2, testIssuer)))
+	key, err := token.selectKey(set)
+	if err != nil || key.KeyID() != "key-2" {
+		t.Fatalf("Expected key-2, got %v %v", key, err)
+	}
+	if err := token.verify(key); err != nil {
+		t.Errorf("Expected signature to verify, got %v", err)
+	}
+	public1, _ := key1.PublicKey()
+	if err := token.verify(public1); !errors.Is(err, errInvalidSignature) {
+		t.Errorf("Expected errInvalidSignature with another key, got %v", err)
+	}
+
+	token.kid = "key-3"
+	if _, err := token.selectKey(set); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Expected errUnknownKey for an unknown kid, got %v", err)
+	}
+	token.kid = ""
+	if _, err := token.selectKey(set); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Expected errUnknownKey without kid and several keys, got %v", err)
+	}
+	token.alg = jwa.ES256
+	token.kid = "key-2"
+	if _, err := token.selectKey(set); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Expected errUnknownKey for another algorithm, got %v", err)
+	}
+}
+
+// TestAuthenticate_MultipleIssuers tests that a token is only verified with the keys of its issuer
+func TestAuthenticate_MultipleIssuers(t *testing.T) {
+	wellKnown1, key1 := writeIssuer(t, "https://issuer-1.example.com", "shared-kid")
+	wellKnown2, key2 := writeIssuer(t, "https://issuer-2.example.com", "shared-kid")
+
+	j := &JWTSupport{
+		wellKnowns:  []string{wellKnown1, wellKnown2},
+		audienceKey: "aud",
+		audiences:   []string{"other-audience", "test-audience"},
+		authKind:    "bearer",
+	}
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+
+	testCases := []struct {
+		name    string
+		token   string
+		success bool
+	}{
+		{"issuer_1", signToken(t, key1, "https://issuer-1.example.com"), true},
+		{"issuer_2", signToken(t, key2, "https://issuer-2.example.com"), true},
+		{"issuer_1_signed_by_issuer_2", signToken(t, key2, "https://issuer-1.example.com"), false},
+		{"unknown_issuer", signToken(t, key1, "https://issuer-3.example.com"), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &types.Info{
+				Request: types.RequestInfo{
+					Auth: &types.RequestAuth{Kind: "bearer", Token: tc.token},
+				},
+			}
+			req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+			err := j.Authenticate(info, req)
+			if tc.success && err != nil {
+				t.Errorf("Expected success, got %v", err)
+			}
+			if !tc.success && !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// BenchmarkAuthenticate_GarbageTokens measures rejecting tokens with a bad signature,
+// which costs one verification regardless of the number of issuers and audiences
+func BenchmarkAuthenticate_GarbageTokens(b *testing.B) {
+	var wellKnowns []string
+	var key jwk.Key
+	for _, issuer := range []string{"https://issuer-1.example.com", "https://issuer-2.example.com", testIssuer} {
+		var wellKnown string
+		wellKnown, key = writeIssuer(b, issuer, "test-key-1")
+		wellKnowns = append(wellKnowns, wellKnown)
+	}
+	j := &JWTSupport{
+		wellKnowns:  wellKnowns,
+		audienceKey: "aud",
+		audiences:   []string{"aud-1", "aud-2", "aud-3", "test-audience"},
+		authKind:    "bearer",
+		permissive:  true,
+	}
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+
+	token := signToken(b, key, testIssuer)
+	garbage := token[:strings.LastIndexByte(token, '.')+1] + strings.Repeat("A", 342)
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		info := &types.Info{
+			Request: types.RequestInfo{
+				Auth: &types.RequestAuth{Kind: "bearer", Token: garbage},
+			},
+		}
+		_ = j.Authenticate(info, req)
+	}
+}
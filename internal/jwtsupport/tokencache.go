@@ -0,0 +1,77 @@
+package jwtsupport
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"hash/maphash"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// cachedToken is a token whose signature and claims were validated
+type cachedToken struct {
+	digest     [sha256.Size]byte // of the raw token, to rule out cache key collisions
+	claims     map[string]interface{}
+	subject    string
+	source     *KeySource
+	generation uint64 // of the source's keys when the token was verified
+}
+
+// newTokenCache creates the cache of validated tokens
+func newTokenCache() (*ristretto.Cache[uint64, *cachedToken], error) {
+	return ristretto.NewCache(&ristretto.Config[uint64, *cachedToken]{
+		NumCounters: 100000, // number of keys to track frequency of.
+		MaxCost:     10000,  // maximum cost of cache (no-of-entries since we use cost=1).
+		BufferItems: 64,     // number of keys per Get buffer.
+	})
+}
+
+// cachedTokenFor returns the validated token cached for a raw token, unless
+// the keys of its issuer rotated since it was verified
+func (j *JWTSupport) cachedTokenFor(raw string) (*cachedToken, bool) {
+	if j.tokens == nil {
+		return nil, false
+	}
+	key := maphash.String(j.seed, raw)
+	e, found := j.tokens.Get(key)
+	if found && e.digest != sha256.Sum256([]byte(raw)) {
+		// another token with the same cache key, never hand out its claims
+		found = false
+	} else if found && e.generation != e.source.generation() {
+		j.tokens.Del(key)
+		found = false
+	}
+	metrics.IncrementJWTCache(found)
+	return e, found
+}
+
+// cacheToken caches a validated token until it expires, gets too old, or the
+// cache TTL passes, whichever comes first
+func (j *JWTSupport) cacheToken(raw string, e *cachedToken, claims jwt.Token) {
+	if j.tokens == nil {
+		return
+	}
+	ttl := j.cacheTTL
+	if exp := claims.Expiration(); !exp.IsZero() {
+		ttl = min(ttl, time.Until(exp.Add(j.skew)))
+	}
+	if j.maxAge > 0 {
+		ttl = min(ttl, time.Until(claims.IssuedAt().Add(j.maxAge+j.skew)))
+	}
+	if ttl > 0 {
+		e.digest = sha256.Sum256([]byte(raw))
+		j.tokens.SetWithTTL(maphash.String(j.seed, raw), e, 1, ttl)
+	}
+}
+
+// keysFingerprint identifies the keys of a set, to notice when they rotate
+func keysFingerprint(set jwk.Set) uint64 {
+	b, _ := json.Marshal(set)
+	sum := sha256.Sum256(b)
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,194 @@
+package jwtsupport
+
+import (
+	"hash/maphash"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// enableTokenCache adds the token cache New creates
+func enableTokenCache(t *testing.T, j *JWTSupport, ttl time.Duration) {
+	t.Helper()
+	tokens, err := newTokenCache()
+	if err != nil {
+		t.Fatalf("Failed to create token cache: %v", err)
+	}
+	t.Cleanup(tokens.Close)
+	j.tokens = tokens
+	j.seed = maphash.MakeSeed()
+	j.cacheTTL = ttl
+}
+
+func authenticateToken(j *JWTSupport, token string) (*types.Info, error) {
+	info := &types.Info{
+		Request: types.RequestInfo{
+			Auth: &types.RequestAuth{Kind: "bearer", Token: token},
+		},
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+	return info, j.Authenticate(info, req)
+}
+
+// TestAuthenticate_Cached tests that a validated token is served from the cache
+func TestAuthenticate_Cached(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{})
+	enableTokenCache(t, f.j, time.Minute)
+	token := signToken(t, f.key, testIssuer)
+
+	if _, err := authenticateToken(f.j, token); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	f.j.tokens.Wait()
+
+	e, found := f.j.cachedTokenFor(token)
+	if !found {
+		t.Fatal("Expected the token to be cached")
+	}
+	info, err := authenticateToken(f.j, token)
+	if err != nil {
+		t.Fatalf("Expected success from the cache, got %v", err)
+	}
+	if info.Request.Principal != "test-user" || info.JWT.(map[string]interface{})["sub"] != "test-user" {
+		t.Errorf("Expected the cached claims, got %q %v", info.Request.Principal, info.JWT)
+	}
+	if e.source.Issuer() != testIssuer {
+		t.Errorf("Expected the token's issuer, got %q", e.source.Issuer())
+	}
+
+	if _, found := f.j.cachedTokenFor(signToken(t, f.key, testIssuer+"/other")); found {
+		t.Error("Expected another token not to be cached")
+	}
+}
+
+// TestCachedTokenFor_KeyCollision tests that an entry cached for another token is never returned
+func TestCachedTokenFor_KeyCollision(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{})
+	enableTokenCache(t, f.j, time.Minute)
+	token := signToken(t, f.key, testIssuer)
+	other := signToken(t, f.key, testIssuer+"/other")
+
+	if _, err := authenticateToken(f.j, token); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	f.j.tokens.Wait()
+	e, found := f.j.cachedTokenFor(token)
+	if !found {
+		t.Fatal("Expected the token to be cached")
+	}
+
+	// store the entry under the other token's cache key, as a collision would
+	f.j.tokens.Set(maphash.String(f.j.seed, other), e, 1)
+	f.j.tokens.Wait()
+	if _, found := f.j.cachedTokenFor(other); found {
+		t.Error("Expected the entry of another token not to be returned")
+	}
+}
+
+// TestAuthenticate_CacheInvalidatedOnRotation tests that cached tokens are verified again when the keys rotate
+func TestAuthenticate_CacheInvalidatedOnRotation(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{})
+	enableTokenCache(t, f.j, time.Minute)
+	token := signToken(t, f.key, testIssuer)
+
+	if _, err := authenticateToken(f.j, token); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	f.j.tokens.Wait()
+
+	// the same keys fetched again are no rotation
+	wk := f.j.wellknownList[0]
+	if _, err := wk.PostFetch(wk.JwksURI, f.j.JWKS[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := f.j.cachedTokenFor(token); !found {
+		t.Fatal("Expected the token to stay cached when the keys did not change")
+	}
+
+	_, newKey := writeIssuer(t, testIssuer, "test-key-2")
+	public, _ := newKey.PublicKey()
+	rotated := jwk.NewSet()
+	rotated.AddKey(public)
+	if _, err := wk.PostFetch(wk.JwksURI, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := f.j.cachedTokenFor(token); found {
+		t.Error("Expected the cached token to be dropped after a key rotation")
+	}
+}
+
+// TestCacheToken_TTL tests that tokens are not cached beyond their exp or max age
+func TestCacheToken_TTL(t *testing.T) {
+	j := &JWTSupport{skew: 10 * time.Second, maxAge: time.Hour}
+	enableTokenCache(t, j, 5*time.Minute)
+	now := time.Now()
+
+	testCases := []struct {
+		name   string
+		iat    time.Time
+		exp    time.Time
+		maxTTL time.Duration // 0: not cached
+	}{
+		{"cache_ttl", now, now.Add(time.Hour), 5 * time.Minute},
+		{"exp_with_skew", now, now.Add(time.Minute), time.Minute + 10*time.Second},
+		{"max_age_with_skew", now.Add(-59 * time.Minute), now.Add(time.Hour), time.Minute + 10*time.Second},
+		{"expired_within_skew", now, now.Add(-5 * time.Second), 5 * time.Second},
+		{"expired", now, now.Add(-time.Minute), 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := jwt.New()
+			claims.Set(jwt.IssuedAtKey, tc.iat)
+			claims.Set(jwt.ExpirationKey, tc.exp)
+			raw := tc.name
+			j.cacheToken(raw, &cachedToken{}, claims)
+			j.tokens.Wait()
+
+			ttl, found := j.tokens.GetTTL(maphash.String(j.seed, raw))
+			if tc.maxTTL == 0 {
+				if found {
+					t.Errorf("Expected no entry, got ttl %v", ttl)
+				}
+				return
+			}
+			if !found || ttl > tc.maxTTL || ttl < tc.maxTTL-5*time.Second {
+				t.Errorf("Expected ttl up to %v, got %v (found %v)", tc.maxTTL, ttl, found)
+			}
+		})
+	}
+}
+
+// BenchmarkAuthenticate_Cached measures authenticating a token served from the cache
+func BenchmarkAuthenticate_Cached(b *testing.B) {
+	wellKnown, key := writeIssuer(b, testIssuer, "test-key-1")
+	j := &JWTSupport{
+		wellKnowns:  []string{wellKnown},
+		audienceKey: "aud",
+		audiences:   []string{"test-audience"},
+		authKind:    "bearer",
+		seed:        maphash.MakeSeed(),
+		cacheTTL:    time.Minute,
+	}
+	j.tokens, _ = newTokenCache()
+	defer j.tokens.Close()
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+
+	token := signToken(b, key, testIssuer)
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		info := &types.Info{
+			Request: types.RequestInfo{
+				Auth: &types.RequestAuth{Kind: "bearer", Token: token},
+			},
+		}
+		_ = j.Authenticate(info, req)
+	}
+}
@@ -0,0 +1,149 @@
+package jwtsupport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+var (
+	errAudienceMismatch = errors.New("audience mismatch")
+	errClaimMismatch    = errors.New("required claim mismatch")
+	errTokenTooOld      = errors.New("token too old")
+	errNoIssuedAt       = errors.New("no iat claim to check the token age")
+)
+
+// Validation holds the checks done on a token after its signature and
+// audience, in addition to the issuer of its well-known document.
+type Validation struct {
+	RequiredClaims []string      // "claim" must exist, "claim=value" must have the value
+	ClockSkew      time.Duration // leeway for exp, nbf and iat
+	MaxAge         time.Duration // max time since iat, 0 for no limit
+}
+
+// requiredClaim is a parsed entry of Validation.RequiredClaims
+type requiredClaim struct {
+	name  string
+	value string
+	exact bool // false: the claim only has to exist
+}
+
+// parseRequiredClaims splits "claim" and "claim=value" entries
+func parseRequiredClaims(list []string) ([]requiredClaim, error) {
+	var claims []requiredClaim
+	for _, s := range list {
+		name, value, exact := strings.Cut(s, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid required claim %q", s)
+		}
+		claims = append(claims, requiredClaim{name: name, value: strings.TrimSpace(value), exact: exact})
+	}
+	return claims, nil
+}
+
+// matches reports if a claim value is, or contains, the required value. Lists
+// and space-separated strings (like the OAuth2 "scp" claim) may contain it.
+func (c requiredClaim) matches(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return v == c.value || slices.Contains(strings.Fields(v), c.value)
+	case []string:
+		return slices.Contains(v, c.value)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == c.value {
+				return true
+			}
+		}
+	case bool, float64, int64:
+		return fmt.Sprint(v) == c.value
+	}
+	return false
+}
+
+// validator checks the claim on a token
+func (c requiredClaim) validator() jwt.Validator {
+	return jwt.ValidatorFunc(func(_ context.Context, t jwt.Token) jwt.ValidationError {
+		v, ok := t.Get(c.name)
+		if !ok {
+			return jwt.ErrMissingRequiredClaim(c.name)
+		}
+		if c.exact && !c.matches(v) {
+			return jwt.NewValidationError(fmt.Errorf("%w: %q", errClaimMismatch, c.name))
+		}
+		return nil
+	})
+}
+
+// audienceValidator checks that the audience claim has one of the audiences.
+// The "aud" claim may be a list, custom claims must be a string.
+func audienceValidator(key string, audiences []string) jwt.Validator {
+	return jwt.ValidatorFunc(func(_ context.Context, t jwt.Token) jwt.ValidationError {
+		var got []string
+		if key == jwt.AudienceKey {
+			got = t.Audience()
+		} else if v, ok := t.Get(key); ok {
+			if s, ok := v.(string); ok {
+				got = []string{s}
+			}
+		}
+		for _, aud := range got {
+			if slices.Contains(audiences, aud) {
+				return nil
+			}
+		}
+		return jwt.NewValidationError(errAudienceMismatch)
+	})
+}
+
+// maxAgeValidator rejects tokens issued more than maxAge ago
+func maxAgeValidator(maxAge time.Duration) jwt.Validator {
+	return jwt.ValidatorFunc(func(ctx context.Context, t jwt.Token) jwt.ValidationError {
+		iat := t.IssuedAt()
+		if iat.IsZero() {
+			return jwt.NewValidationError(errNoIssuedAt)
+		}
+		now := jwt.ValidationCtxClock(ctx).Now()
+		if now.Sub(iat) > maxAge+jwt.ValidationCtxSkew(ctx) {
+			return jwt.NewValidationError(errTokenTooOld)
+		}
+		return nil
+	})
+}
+
+// failureReason describes why a token was rejected, for the debug log
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, errMalformedToken):
+		return "malformed token"
+	case errors.Is(err, errUnknownIssuer):
+		return "unknown issuer"
+	case errors.Is(err, errUnknownKey):
+		return "unknown key"
+	case errors.Is(err, errInvalidSignature):
+		return "invalid signature"
+	case errors.Is(err, jwt.ErrTokenExpired()):
+		return "token expired"
+	case errors.Is(err, jwt.ErrTokenNotYetValid()):
+		return "token not yet valid"
+	case errors.Is(err, jwt.ErrInvalidIssuedAt()):
+		return "token issued in the future"
+	case errors.Is(err, errTokenTooOld):
+		return "token too old"
+	case errors.Is(err, errNoIssuedAt):
+		return "token age unknown"
+	case errors.Is(err, errAudienceMismatch):
+		return "audience mismatch"
+	case errors.Is(err, jwt.ErrRequiredClaim()):
+		return "required claim missing"
+	case errors.Is(err, errClaimMismatch):
+		return "required claim mismatch"
+	}
+	return "invalid claims"
+}
@@ -0,0 +1,307 @@
+package jwtsupport
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const testIssuer = "https://issuer.example.com"
+
+// validationFixture is a file-based issuer with one RSA key
+type validationFixture struct {
+	j   *JWTSupport
+	key jwk.Key
+}
+
+// writeIssuer writes a well-known document with the given issuer, and its
+// JWKS with one RSA key, returning the well-known URL and the private key
+func writeIssuer(t testing.TB, issuer, kid string) (string, jwk.Key) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key pair: %v", err)
+	}
+	key, err := jwk.FromRaw(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create private JWK: %v", err)
+	}
+	key.Set(jwk.KeyIDKey, kid)
+	key.Set(jwk.AlgorithmKey, jwa.RS256)
+	public, err := key.PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to get public key: %v", err)
+	}
+	set := jwk.NewSet()
+	set.AddKey(public)
+	jwksJSON, _ := json.Marshal(set)
+
+	tmpDir := t.TempDir()
+	jwksPath := filepath.Join(tmpDir, "jwks.json")
+	wellKnownPath := filepath.Join(tmpDir, "well-known.json")
+	wellKnownJSON, _ := json.Marshal(map[string]interface{}{
+		"issuer":   issuer,
+		"jwks_uri": "file://" + jwksPath,
+	})
+	if err := os.WriteFile(jwksPath, jwksJSON, 0644); err != nil {
+		t.Fatalf("Failed to write JWKS file: %v", err)
+	}
+	if err := os.WriteFile(wellKnownPath, wellKnownJSON, 0644); err != nil {
+		t.Fatalf("Failed to write well-known file: %v", err)
+	}
+	return "file://" + wellKnownPath, key
+}
+
+// newValidationFixture writes an issuer and loads it like New does
+func newValidationFixture(t *testing.T, issuer string, v Validation) *validationFixture {
+	t.Helper()
+	wellKnown, key := writeIssuer(t, issuer, "test-key-1")
+
+	claims, err := parseRequiredClaims(v.RequiredClaims)
+	if err != nil {
+		t.Fatalf("Failed to parse required claims: %v", err)
+	}
+	j := &JWTSupport{
+		wellKnowns:  []string{wellKnown},
+		audienceKey: "aud",
+		audiences:   []string{"test-audience"},
+		authKind:    "bearer",
+		claims:      claims,
+		skew:        v.ClockSkew,
+		maxAge:      v.MaxAge,
+	}
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+	if len(j.JWKS) != 1 {
+		t.Fatalf("Expected 1 JWKS entry, got %d", len(j.JWKS))
+	}
+	return &validationFixture{j: j, key: key}
+}
+
+// authenticate signs a token with the claims (on top of valid defaults) and authenticates it
+func (f *validationFixture) authenticate(t *testing.T, claims map[string]interface{}) error {
+	t.Helper()
+	now := time.Now()
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, testIssuer)
+	token.Set(jwt.AudienceKey, "test-audience")
+	token.Set(jwt.SubjectKey, "test-user")
+	token.Set(jwt.IssuedAtKey, now.Unix())
+	token.Set(jwt.ExpirationKey, now.Add(time.Hour).Unix())
+	for k, v := range claims {
+		if v == nil {
+			token.Remove(k)
+			continue
+		}
+		if err := token.Set(k, v); err != nil {
+			t.Fatalf("Failed to set %s: %v", k, err)
+		}
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, f.key))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	info := &types.Info{
+		Request: types.RequestInfo{
+			Auth: &types.RequestAuth{Kind: "bearer", Token: string(signed)},
+		},
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+	return f.j.Authenticate(info, req)
+}
+
+// TestAuthenticate_Issuer tests that tokens must come from the issuer of the well-known document
+func TestAuthenticate_Issuer(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{})
+
+	if err := f.authenticate(t, nil); err != nil {
+		t.Errorf("Expected token from the issuer to be accepted, got %v", err)
+	}
+	if err := f.authenticate(t, map[string]interface{}{"iss": "https://other.example.com"}); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected token from another issuer to be rejected, got %v", err)
+	}
+	if err := f.authenticate(t, map[string]interface{}{"iss": nil}); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected token without issuer to be rejected, got %v", err)
+	}
+}
+
+// TestAuthenticate_NoIssuer tests that a well-known document without issuer is
+// not loaded, so its keys cannot vouch for tokens of any issuer
+func TestAuthenticate_NoIssuer(t *testing.T) {
+	withIssuer, _ := writeIssuer(t, testIssuer, "test-key-1")
+	noIssuer, key := writeIssuer(t, "", "test-key-2")
+	j := &JWTSupport{
+		wellKnowns:  []string{withIssuer, noIssuer},
+		audienceKey: "aud",
+		audiences:   []string{"test-audience"},
+		authKind:    "bearer",
+	}
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+	if len(j.JWKS) != 1 {
+		t.Fatalf("Expected the well-known without issuer to be skipped, got %d JWKS entries", len(j.JWKS))
+	}
+
+	f := &validationFixture{j: j, key: key}
+	if err := f.authenticate(t, map[string]interface{}{"iss": "https://foreign.example.com"}); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected token with a foreign issuer to be rejected, got %v", err)
+	}
+	if err := f.authenticate(t, nil); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected token signed by the skipped source to be rejected, got %v", err)
+	}
+}
+
+// TestAuthenticate_TenantIssuer tests the {tenantid} placeholder of multi-tenant issuers
+func TestAuthenticate_TenantIssuer(t *testing.T) {
+	f := newValidationFixture(t, "https://login.example.com/{tenantid}/v2.0", Validation{})
+
+	tenant := "4a1e0a58-1b7e-4a7f-9b0f-3d4c2e1f0a9b"
+	if err := f.authenticate(t, map[string]interface{}{"iss": "https://login.example.com/" + tenant + "/v2.0", "tid": tenant}); err != nil {
+		t.Errorf("Expected token of the tenant to be accepted, got %v", err)
+	}
+	if err := f.authenticate(t, map[string]interface{}{"iss": "https://login.example.com/" + tenant + "/v2.0", "tid": "another-tenant"}); err == nil {
+		t.Error("Expected token with a tid not matching its issuer to be rejected")
+	}
+}
+
+// TestAuthenticate_RequiredClaims tests required claims by existence and value
+func TestAuthenticate_RequiredClaims(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{
+		RequiredClaims: []string{"azp", "scp=Orders.Read", "roles=admin"},
+	})
+
+	valid := map[string]interface{}{
+		"azp":   "frontend",
+		"scp":   "User.Read Orders.Read",
+		"roles": []string{"reader", "admin"},
+	}
+	if err := f.authenticate(t, valid); err != nil {
+		t.Errorf("Expected token with the required claims to be accepted, got %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		claim string
+		value interface{}
+	}{
+		{"missing_claim", "azp", nil},
+		{"missing_value_claim", "scp", nil},
+		{"wrong_scope", "scp", "User.Read"},
+		{"wrong_role", "roles", []string{"reader"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := map[string]interface{}{}
+			for k, v := range valid {
+				claims[k] = v
+			}
+			claims[tc.claim] = tc.value
+			if err := f.authenticate(t, claims); !errors.Is(err, types.ErrAuthenticationFailed) {
+				t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestAuthenticate_ClockSkew tests that the skew allows recently expired and not yet valid tokens
+func TestAuthenticate_ClockSkew(t *testing.T) {
+	expired := map[string]interface{}{"exp": time.Now().Add(-10 * time.Second).Unix()}
+	early := map[string]interface{}{"nbf": time.Now().Add(10 * time.Second).Unix()}
+
+	strict := newValidationFixture(t, testIssuer, Validation{})
+	if err := strict.authenticate(t, expired); err == nil {
+		t.Error("Expected expired token to be rejected without skew")
+	}
+	if err := strict.authenticate(t, early); err == nil {
+		t.Error("Expected not yet valid token to be rejected without skew")
+	}
+
+	lenient := newValidationFixture(t, testIssuer, Validation{ClockSkew: time.Minute})
+	if err := lenient.authenticate(t, expired); err != nil {
+		t.Errorf("Expected expired token within the skew to be accepted, got %v", err)
+	}
+	if err := lenient.authenticate(t, early); err != nil {
+		t.Errorf("Expected not yet valid token within the skew to be accepted, got %v", err)
+	}
+}
+
+// TestAuthenticate_MaxAge tests that old tokens and tokens without iat are rejected
+func TestAuthenticate_MaxAge(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{MaxAge: time.Hour})
+
+	if err := f.authenticate(t, map[string]interface{}{"iat": time.Now().Add(-30 * time.Minute).Unix()}); err != nil {
+		t.Errorf("Expected recent token to be accepted, got %v", err)
+	}
+	if err := f.authenticate(t, map[string]interface{}{"iat": time.Now().Add(-2 * time.Hour).Unix()}); err == nil {
+		t.Error("Expected old token to be rejected")
+	}
+	if err := f.authenticate(t, map[string]interface{}{"iat": nil}); err == nil {
+		t.Error("Expected token without iat to be rejected")
+	}
+}
+
+// TestFailureReason tests that each check has its own reason
+func TestFailureReason(t *testing.T) {
+	claim := requiredClaim{name: "tid", value: "tenant", exact: true}
+	testCases := []struct {
+		err  error
+		want string
+	}{
+		{errMalformedToken, "malformed token"},
+		{errUnknownIssuer, "unknown issuer"},
+		{errUnknownKey, "unknown key"},
+		{errInvalidSignature, "invalid signature"},
+		{jwt.ErrTokenExpired(), "token expired"},
+		{jwt.ErrTokenNotYetValid(), "token not yet valid"},
+		{jwt.ErrInvalidIssuedAt(), "token issued in the future"},
+		{jwt.NewValidationError(errTokenTooOld), "token too old"},
+		{jwt.NewValidationError(errNoIssuedAt), "token age unknown"},
+		{jwt.NewValidationError(errAudienceMismatch), "audience mismatch"},
+		{jwt.ErrMissingRequiredClaim("tid"), "required claim missing"},
+		{claim.validator().Validate(t.Context(), tokenWith(t, "tid", "other")), "required claim mismatch"},
+		{jwt.NewValidationError(errors.New(`"sub" not satisfied`)), "invalid claims"},
+	}
+	for _, tc := range testCases {
+		if got := failureReason(tc.err); got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.err, tc.want, got)
+		}
+	}
+}
+
+func tokenWith(t *testing.T, key string, value interface{}) jwt.Token {
+	t.Helper()
+	token := jwt.New()
+	if err := token.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestParseRequiredClaims tests the CLAIM and CLAIM=VALUE forms
+func TestParseRequiredClaims(t *testing.T) {
+	claims, err := parseRequiredClaims([]string{"azp", "tid = tenant", "empty="})
+	if err != nil {
+		t.Fatalf("Expected claims, got %v", err)
+	}
+	want := []requiredClaim{{name: "azp"}, {name: "tid", value: "tenant", exact: true}, {name: "empty", exact: true}}
+	for i := range want {
+		if claims[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], claims[i])
+		}
+	}
+	if _, err := parseRequiredClaims([]string{"=value"}); err == nil {
+		t.Error("Expected an error for a claim without name")
+	}
+}
@@ -0,0 +1,167 @@
+package jwtsupport
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets a file be written completely, and the events of one
+// change settle, before the files are reloaded
+const reloadDelay = 500 * time.Millisecond
+
+// kubernetesDataLink is the symlink Kubernetes swaps to update a ConfigMap or
+// secret volume at once, the files in the volume are symlinks through it
+const kubernetesDataLink = "..data"
+
+// watchFiles starts watching the directories of the file-based well-known
+// documents and JWKS, reloading them when they change
+func (j *JWTSupport) watchFiles() {
+	files := j.localFiles()
+	if len(files) == 0 {
+		return
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("jwtsupport: failed to create file watcher, hot-reload disabled", "error", err)
+		return
+	}
+	// watch the directories, so replaced files (e.g. Kubernetes ConfigMap
+	// volumes swapping a symlink) are noticed as well
+	dirs := make(map[string]bool)
+	for file := range files {
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			slog.Warn("jwtsupport: failed to watch directory, hot-reload disabled", "dir", dir, "error", err)
+			w.Close()
+			return
+		}
+	}
+	j.watcher = w
+	go startWatcher(j, files)
+}
+
+// Close stops watching the file-based well-known documents and JWKS
+func (j *JWTSupport) Close() error {
+	if j.watcher == nil {
+		return nil
+	}
+	return j.watcher.Close()
+}
+
+// localFiles returns the paths of the file-based well-known documents and JWKS
+func (j *JWTSupport) localFiles() map[string]bool {
+	files := make(map[string]bool)
+	for _, src := range j.sources.Load().all {
+		if !src.wk.isLocalFile {
+			continue
+		}
+		for _, url := range []string{src.wk.sourceURL, src.wk.JwksURI} {
+			if path, err := fileURLToPath(url); err == nil {
+				files[filepath.Clean(path)] = true
+			}
+		}
+	}
+	return files
+}
+
+// startWatcher reloads the files after changes in their directories, once
+// the events of a change settled.
+// This function is intended to run in its own goroutine.
+func startWatcher(j *JWTSupport, files map[string]bool) {
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-j.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) || !relevant(files, event.Name) {
+				continue
+			}
+			timer.Reset(reloadDelay)
+
+		case <-timer.C:
+			j.reloadFiles()
+
+		case err, ok := <-j.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("jwtsupport: file watcher error", "error", err)
+		}
+	}
+}
+
+// relevant reports if a changed file may affect the loaded files: one of
+// them, or the "..data" link of a Kubernetes ConfigMap volume. The files may
+// be symlinks themselves, so they are followed to tell files from directories.
+func relevant(files map[string]bool, name string) bool {
+	if filepath.Base(name) == kubernetesDataLink {
+		return true
+	}
+	if !files[filepath.Clean(name)] {
+		return false
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return true // removed or dangling, let the reload report it
+	}
+	return stat.Mode().IsRegular()
+}
+
+// reloadFiles re-reads the file-based well-known documents and JWKS, and
+// swaps in those that changed at once. A source that fails to load keeps its
+// last loaded keys.
+func (j *JWTSupport) reloadFiles() {
+	index := j.sources.Load()
+	sources := slices.Clone(index.all)
+	changed := false
+	for i, src := range sources {
+		if !src.wk.isLocalFile {
+			continue
+		}
+		next, err := j.reloadSource(src)
+		if err != nil {
+			slog.Error("jwtsupport: failed to reload, retaining last loaded keys", "url", src.wk.sourceURL, "error", err)
+			metrics.ObserveJWKSReload(src.wk.sourceURL, err)
+			continue
+		}
+		if next == nil {
+			continue
+		}
+		sources[i] = next
+		changed = true
+		src.wk.generation.Add(1) // tokens cached with the old keys are verified again
+		slog.Info("jwtsupport: reloaded well-known and jwks", "url", src.wk.sourceURL, "issuer", next.Issuer(), "keys", next.set.Len())
+		metrics.ObserveJWKSReload(src.wk.sourceURL, nil)
+	}
+	if changed {
+		j.sources.Store(newSourceIndex(sources))
+	}
+}
+
+// reloadSource loads a file-based source again, returning nil if it did not change
+func (j *JWTSupport) reloadSource(src *KeySource) (*KeySource, error) {
+	wk, err := loadWellKnown(src.wk.sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	set, err := loadJWKS(j.cache, wk)
+	if err != nil {
+		return nil, err
+	}
+	if wk.Issuer == src.wk.Issuer && wk.JwksURI == src.wk.JwksURI && wk.fingerprint.Load() == src.wk.fingerprint.Load() {
+		return nil, nil
+	}
+	return &KeySource{wk: wk, cache: j.cache, set: set}, nil
+}
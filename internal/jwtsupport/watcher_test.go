@@ -0,0 +1,167 @@
+package jwtsupport
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// writeKeys writes a JWKS with the public part of the key
+func writeKeys(t *testing.T, path string, key jwk.Key) {
+	t.Helper()
+	public, _ := key.PublicKey()
+	set := jwk.NewSet()
+	set.AddKey(public)
+	data, _ := json.Marshal(set)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write JWKS file: %v", err)
+	}
+}
+
+// TestReloadFiles tests that changed keys are swapped in, and that a broken
+// file keeps the last loaded keys
+func TestReloadFiles(t *testing.T) {
+	f := newValidationFixture(t, testIssuer, Validation{})
+	enableTokenCache(t, f.j, time.Minute)
+	jwksPath, _ := fileURLToPath(f.j.sources.Load().all[0].wk.JwksURI)
+	_, newKey := writeIssuer(t, testIssuer, "test-key-2")
+
+	oldToken := signToken(t, f.key, testIssuer)
+	newToken := signToken(t, newKey, testIssuer)
+	if _, err := authenticateToken(f.j, oldToken); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	f.j.tokens.Wait()
+
+	// unchanged files are no reload
+	before := f.j.sources.Load()
+	f.j.reloadFiles()
+	if f.j.sources.Load() != before {
+		t.Error("Expected the sources to be kept when the files did not change")
+	}
+
+	writeKeys(t, jwksPath, newKey)
+	f.j.reloadFiles()
+	if _, err := authenticateToken(f.j, newToken); err != nil {
+		t.Errorf("Expected the new key to be accepted, got %v", err)
+	}
+	if _, err := authenticateToken(f.j, oldToken); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the cached token of the old key to be rejected, got %v", err)
+	}
+
+	for _, data := range []string{"", "{not json", `{"keys":[{"kty":"unknown"}]}`} {
+		if err := os.WriteFile(jwksPath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f.j.reloadFiles()
+		if _, err := authenticateToken(f.j, newToken); err != nil {
+			t.Errorf("%q: expected the last loaded key to be kept, got %v", data, err)
+		}
+	}
+}
+
+// TestWatchFiles_KubernetesVolume tests a ConfigMap update, which swaps the
+// "..data" symlink the mounted files point through
+func TestWatchFiles_KubernetesVolume(t *testing.T) {
+	dir := t.TempDir()
+	_, key1 := writeIssuer(t, testIssuer, "test-key-1")
+	_, key2 := writeIssuer(t, testIssuer, "test-key-2")
+
+	wellKnownJSON, _ := json.Marshal(map[string]interface{}{
+		"issuer":   testIssuer,
+		"jwks_uri": "file://" + filepath.Join(dir, "jwks.json"),
+	})
+	writeVersion := func(version string, key jwk.Key) {
+		t.Helper()
+		versionDir := filepath.Join(dir, version)
+		if err := os.Mkdir(versionDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(versionDir, "well-known.json"), wellKnownJSON, 0644); err != nil {
+			t.Fatal(err)
+		}
+		writeKeys(t, filepath.Join(versionDir, "jwks.json"), key)
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, kubernetesDataLink)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("..version_1", key1)
+	for _, name := range []string{"well-known.json", "jwks.json"} {
+		if err := os.Symlink(filepath.Join(kubernetesDataLink, name), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := &JWTSupport{
+		wellKnowns:  []string{"file://" + filepath.Join(dir, "well-known.json")},
+		audienceKey: "aud",
+		audiences:   []string{"test-audience"},
+		authKind:    "bearer",
+	}
+	j.LoadWellKnowns()
+	j.LoadJWKS()
+	j.watchFiles()
+	if j.watcher == nil {
+		t.Fatal("Expected the files to be watched")
+	}
+	t.Cleanup(func() { j.Close() })
+
+	if _, err := authenticateToken(j, signToken(t, key1, testIssuer)); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+
+	writeVersion("..version_2", key2)
+	token := signToken(t, key2, testIssuer)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := authenticateToken(j, token)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Keys were not reloaded: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestRelevant tests which changed files trigger a reload
+func TestRelevant(t *testing.T) {
+	dir := t.TempDir()
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a watched file that is itself a symlink, e.g. to a mounted secret
+	linkPath := filepath.Join(dir, "linked.json")
+	if err := os.Symlink(jwksPath, linkPath); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{jwksPath: true, linkPath: true}
+
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{jwksPath, true},
+		{linkPath, true},
+		{filepath.Join(dir, kubernetesDataLink), true},
+		{filepath.Join(dir, "..data_tmp"), false},
+		{filepath.Join(dir, "other.json"), false},
+	}
+	for _, tc := range testCases {
+		if got := relevant(files, tc.name); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", strings.TrimPrefix(tc.name, dir), tc.want, got)
+		}
+	}
+}
@@ -24,6 +24,25 @@ var metrics struct {
 	blockedHeadersExposed      prometheus.Gauge
 	blockedHeadersCaptured     prometheus.Counter
 	requestsWithBlockedHeaders prometheus.Counter
+
+	decisionLogsDropped *prometheus.CounterVec
+	decisionLogsFailed  *prometheus.CounterVec
+
+	policyEvalDuration    *prometheus.HistogramVec
+	policyDecisions       *prometheus.CounterVec
+	policyCompileFailures prometheus.Gauge
+	policyLastReload      prometheus.Gauge
+
+	authTotal        *prometheus.CounterVec
+	jwksRefreshTotal *prometheus.CounterVec
+	jwksKeys         *prometheus.GaugeVec
+	jwksReloadTotal  *prometheus.CounterVec
+	graphCache       *prometheus.CounterVec
+	basicAuthCache   *prometheus.CounterVec
+	introspectCache  *prometheus.CounterVec
+	jwtCache         *prometheus.CounterVec
+	tokenReviewCache *prometheus.CounterVec
+	apiKeys          *prometheus.CounterVec
 }
 
 // New creates a new instance of the metrics
@@ -89,6 +108,133 @@ func New() {
 			Help: "Total number of requests containing X-Restrego-* headers.",
 		},
 	)
+
+	metrics.decisionLogsDropped = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_decision_logs_dropped_total",
+			Help: "Total number of decision log events dropped because a sink buffer was full.",
+		},
+		[]string{"sink"},
+	)
+
+	metrics.decisionLogsFailed = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_decision_logs_failed_total",
+			Help: "Total number of decision log events a sink failed to write.",
+		},
+		[]string{"sink"},
+	)
+
+	metrics.policyEvalDuration = promauto.With(metrics.reg).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "restrego_policy_evaluation_seconds",
+			Help:    "Tracks the time spent evaluating a policy.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12), // 100µs to ~200ms
+		},
+		[]string{"policy"},
+	)
+
+	metrics.policyDecisions = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_policy_decisions_total",
+			Help: "Total number of policy decisions by decision (allow, deny, error).",
+		},
+		[]string{"policy", "decision"},
+	)
+
+	metrics.policyCompileFailures = promauto.With(metrics.reg).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "restrego_policy_compile_failures",
+			Help: "Number of consecutive failed policy compilations since the last successful load.",
+		},
+	)
+
+	metrics.policyLastReload = promauto.With(metrics.reg).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "restrego_policy_last_reload_success_timestamp_seconds",
+			Help: "Unix time of the last successful policy load.",
+		},
+	)
+
+	metrics.authTotal = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_auth_total",
+			Help: "Total number of authentications by provider and outcome (success, anonymous, failed, unavailable).",
+		},
+		[]string{"provider", "outcome"},
+	)
+
+	metrics.jwksRefreshTotal = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_jwks_refresh_total",
+			Help: "Total number of JWKS fetches by result (success, failure).",
+		},
+		[]string{"url", "result"},
+	)
+
+	metrics.jwksKeys = promauto.With(metrics.reg).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "restrego_jwks_keys",
+			Help: "Number of keys in the last fetched JWKS.",
+		},
+		[]string{"url"},
+	)
+
+	metrics.jwksReloadTotal = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_jwks_reload_total",
+			Help: "Total number of reloads of changed file-based well-known and JWKS files by result (success, failure).",
+		},
+		[]string{"url", "result"},
+	)
+
+	metrics.graphCache = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_graph_cache_requests_total",
+			Help: "Total number of Microsoft Graph app lookups by cache result (hit, miss).",
+		},
+		[]string{"result"},
+	)
+
+	metrics.basicAuthCache = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_basic_auth_cache_requests_total",
+			Help: "Total number of basic-auth password checks by cache result (hit, miss); a miss runs bcrypt.",
+		},
+		[]string{"result"},
+	)
+
+	metrics.introspectCache = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_introspection_cache_requests_total",
+			Help: "Total number of token introspection lookups by cache result (hit, miss); a miss calls the introspection endpoint.",
+		},
+		[]string{"result"},
+	)
+
+	metrics.jwtCache = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_jwt_cache_requests_total",
+			Help: "Total number of JWT validations by cache result (hit, miss); a miss verifies the signature.",
+		},
+		[]string{"result"},
+	)
+
+	metrics.tokenReviewCache = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_tokenreview_cache_requests_total",
+			Help: "Total number of Kubernetes token reviews by cache result (hit, miss); a miss calls the TokenReview API.",
+		},
+		[]string{"result"},
+	)
+
+	metrics.apiKeys = promauto.With(metrics.reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "restrego_api_key_requests_total",
+			Help: "Total number of requests with an API key by key name and result (success, expired, unknown).",
+		},
+		[]string{"name", "result"},
+	)
 }
 
 // Handler returns the metrics handler for the /metrics endpoint
@@ -148,3 +294,120 @@ func IncrementBlockedHeadersCaptured(count int) {
 func IncrementRequestsWithBlockedHeaders() {
 	metrics.requestsWithBlockedHeaders.Inc()
 }
+
+// IncrementDecisionLogsDropped counts a decision log event dropped for a sink
+func IncrementDecisionLogsDropped(sink string) {
+	if metrics.decisionLogsDropped != nil {
+		metrics.decisionLogsDropped.WithLabelValues(sink).Inc()
+	}
+}
+
+// IncrementDecisionLogsFailed counts decision log events a sink failed to write
+func IncrementDecisionLogsFailed(sink string, count int) {
+	if metrics.decisionLogsFailed != nil {
+		metrics.decisionLogsFailed.WithLabelValues(sink).Add(float64(count))
+	}
+}
+
+// cacheResult is the label value of a cache lookup
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// ObservePolicyEvaluation records the duration and decision of a policy evaluation
+func ObservePolicyEvaluation(policy, decision string, elapsed time.Duration) {
+	if metrics.policyEvalDuration != nil {
+		metrics.policyEvalDuration.WithLabelValues(policy).Observe(elapsed.Seconds())
+		metrics.policyDecisions.WithLabelValues(policy, decision).Inc()
+	}
+}
+
+// ObservePolicyReload records the result of compiling a new set of policies
+func ObservePolicyReload(err error) {
+	if metrics.policyCompileFailures == nil {
+		return
+	}
+	if err != nil {
+		metrics.policyCompileFailures.Inc()
+		return
+	}
+	metrics.policyCompileFailures.Set(0)
+	metrics.policyLastReload.SetToCurrentTime()
+}
+
+// IncrementAuth counts an authentication outcome for a provider
+func IncrementAuth(provider, outcome string) {
+	if metrics.authTotal != nil {
+		metrics.authTotal.WithLabelValues(provider, outcome).Inc()
+	}
+}
+
+// ObserveJWKSRefresh counts a JWKS fetch, setting the key count when it succeeded
+func ObserveJWKSRefresh(url string, keys int, err error) {
+	if metrics.jwksRefreshTotal == nil {
+		return
+	}
+	if err != nil {
+		metrics.jwksRefreshTotal.WithLabelValues(url, "failure").Inc()
+		return
+	}
+	metrics.jwksRefreshTotal.WithLabelValues(url, "success").Inc()
+	metrics.jwksKeys.WithLabelValues(url).Set(float64(keys))
+}
+
+// ObserveJWKSReload counts a reload of a changed file-based well-known document and its JWKS
+func ObserveJWKSReload(url string, err error) {
+	if metrics.jwksReloadTotal == nil {
+		return
+	}
+	if err != nil {
+		metrics.jwksReloadTotal.WithLabelValues(url, "failure").Inc()
+		return
+	}
+	metrics.jwksReloadTotal.WithLabelValues(url, "success").Inc()
+}
+
+// IncrementGraphCache counts a Microsoft Graph app lookup as a cache hit or miss
+func IncrementGraphCache(hit bool) {
+	if metrics.graphCache != nil {
+		metrics.graphCache.WithLabelValues(cacheResult(hit)).Inc()
+	}
+}
+
+// IncrementIntrospectionCache counts a token introspection as a cache hit or miss
+func IncrementIntrospectionCache(hit bool) {
+	if metrics.introspectCache != nil {
+		metrics.introspectCache.WithLabelValues(cacheResult(hit)).Inc()
+	}
+}
+
+// IncrementJWTCache counts a JWT validation as a cache hit or miss
+func IncrementJWTCache(hit bool) {
+	if metrics.jwtCache != nil {
+		metrics.jwtCache.WithLabelValues(cacheResult(hit)).Inc()
+	}
+}
+
+// IncrementTokenReviewCache counts a Kubernetes token review as a cache hit or miss
+func IncrementTokenReviewCache(hit bool) {
+	if metrics.tokenReviewCache != nil {
+		metrics.tokenReviewCache.WithLabelValues(cacheResult(hit)).Inc()
+	}
+}
+
+// IncrementBasicAuthCache counts a basic-auth password check as a cache hit or miss
+func IncrementBasicAuthCache(hit bool) {
+	if metrics.basicAuthCache != nil {
+		metrics.basicAuthCache.WithLabelValues(cacheResult(hit)).Inc()
+	}
+}
+
+// IncrementAPIKey counts the use of an API key (name is empty for unknown keys)
+func IncrementAPIKey(name, result string) {
+	if metrics.apiKeys != nil {
+		metrics.apiKeys.WithLabelValues(name, result).Inc()
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler()(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestPolicyAndAuthMetrics(t *testing.T) {
+	New()
+
+	ObservePolicyEvaluation("request.rego", "allow", 2*time.Millisecond)
+	ObservePolicyEvaluation("request.rego", "deny", time.Millisecond)
+	ObservePolicyEvaluation("request.rego", "deny", time.Millisecond)
+	IncrementAuth("jwt", "success")
+	IncrementAuth("jwt", "unavailable")
+	ObserveJWKSRefresh("https://idp/keys", 3, nil)
+	ObserveJWKSRefresh("https://idp/keys", 0, errors.New("timeout"))
+	ObserveJWKSReload("file:///config/well-known.json", nil)
+	ObserveJWKSReload("file:///config/well-known.json", errors.New("invalid json"))
+	IncrementGraphCache(true)
+	IncrementGraphCache(false)
+	IncrementBasicAuthCache(true)
+
+	body := scrape(t)
+	for _, want := range []string{
+		`restrego_policy_evaluation_seconds_count{policy="request.rego"} 3`,
+		`restrego_policy_decisions_total{decision="allow",policy="request.rego"} 1`,
+		`restrego_policy_decisions_total{decision="deny",policy="request.rego"} 2`,
+		`restrego_auth_total{outcome="success",provider="jwt"} 1`,
+		`restrego_auth_total{outcome="unavailable",provider="jwt"} 1`,
+		`restrego_jwks_refresh_total{result="success",url="https://idp/keys"} 1`,
+		`restrego_jwks_refresh_total{result="failure",url="https://idp/keys"} 1`,
+		`restrego_jwks_keys{url="https://idp/keys"} 3`,
+		`restrego_jwks_reload_total{result="success",url="file:///config/well-known.json"} 1`,
+		`restrego_jwks_reload_total{result="failure",url="file:///config/well-known.json"} 1`,
+		`restrego_graph_cache_requests_total{result="hit"} 1`,
+		`restrego_graph_cache_requests_total{result="miss"} 1`,
+		`restrego_basic_auth_cache_requests_total{result="hit"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestObservePolicyReload(t *testing.T) {
+	New()
+
+	ObservePolicyReload(errors.New("compile error"))
+	ObservePolicyReload(errors.New("compile error"))
+	if body := scrape(t); !strings.Contains(body, "restrego_policy_compile_failures 2") ||
+		!strings.Contains(body, "restrego_policy_last_reload_success_timestamp_seconds 0") {
+		t.Errorf("expected 2 failures and no successful reload:\n%s", body)
+	}
+
+	ObservePolicyReload(nil)
+	body := scrape(t)
+	if !strings.Contains(body, "restrego_policy_compile_failures 0") {
+		t.Error("expected failures to reset after a successful reload")
+	}
+	if strings.Contains(body, "restrego_policy_last_reload_success_timestamp_seconds 0\n") {
+		t.Error("expected the last reload timestamp to be set")
+	}
+}
@@ -22,3 +22,8 @@ func (b *NoAuthProvider) Authenticate(info *types.Info, _ *http.Request) error {
 	// No authentication performed; info.JWT and info.User remain unset.
 	return nil
 }
+
+// Name implements the optional types.AuthNamer interface.
+func (b *NoAuthProvider) Name() string {
+	return "none"
+}
@@ -5,7 +5,11 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/AB-Lindex/rest-rego/internal/authchain"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (proxy *Proxy) authHandler(next http.Handler) http.Handler {
@@ -13,11 +17,31 @@ func (proxy *Proxy) authHandler(next http.Handler) http.Handler {
 		info := types.GetInfo(r)
 		if info == nil {
 			slog.Error("router: missing request context")
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			proxy.writeError(w, r, http.StatusInternalServerError, "internal error")
 			return
 		}
 
-		err := proxy.auth.Authenticate(info, r)
+		provider := "unknown"
+		if n, ok := proxy.auth.(types.AuthNamer); ok {
+			provider = n.Name()
+		}
+		ctx, span := tracing.Start(r.Context(), "auth "+provider, attribute.String("auth.provider", provider))
+		ar := r.WithContext(ctx)
+		err := proxy.auth.Authenticate(info, ar)
+		r.Body = ar.Body // a provider may have buffered the body to verify it
+		outcome := authOutcome(info, err)
+		span.SetAttributes(attribute.String("auth.outcome", outcome))
+		tracing.End(span, err)
+		if _, chained := proxy.auth.(*authchain.Chain); !chained {
+			// a chain counts the outcome and records the provider deciding it
+			metrics.IncrementAuth(provider, outcome)
+			if outcome == "success" && provider != "unknown" {
+				if info.Request.Auth == nil {
+					info.Request.Auth = &types.RequestAuth{}
+				}
+				info.Request.Auth.Provider = provider
+			}
+		}
 
 		switch {
 		case err == nil:
@@ -25,31 +49,50 @@ func (proxy *Proxy) authHandler(next http.Handler) http.Handler {
 			isAuth := info.JWT != nil || info.User != nil
 			slog.Debug("router: authentication complete",
 				"authenticated", isAuth,
-				"path", r.URL.Path)
+				"path", r.URL.Path,
+				"id", info.Request.ID)
 			next.ServeHTTP(w, r)
 
 		case errors.Is(err, types.ErrAuthenticationFailed):
 			// Invalid credentials in strict mode
 			slog.Warn("router: authentication failed",
 				"path", r.URL.Path,
-				"method", r.Method)
+				"method", r.Method,
+				"id", info.Request.ID)
 			challenge := "Bearer"
 			if c, ok := proxy.auth.(types.AuthChallenger); ok {
 				challenge = c.WWWAuthenticate()
 			}
 			w.Header().Set("WWW-Authenticate", challenge)
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			proxy.writeError(w, r, http.StatusUnauthorized, "invalid credentials")
 
 		case errors.Is(err, types.ErrAuthenticationUnavailable):
 			// System unavailable - fail closed regardless of mode
 			slog.Error("router: authentication system unavailable",
-				"path", r.URL.Path)
-			http.Error(w, "authentication service unavailable", http.StatusServiceUnavailable)
+				"path", r.URL.Path,
+				"id", info.Request.ID)
+			proxy.writeError(w, r, http.StatusServiceUnavailable, "authentication service unavailable")
 
 		default:
 			// Unexpected error
-			slog.Error("router: unexpected authentication error", "error", err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			slog.Error("router: unexpected authentication error", "error", err, "id", info.Request.ID)
+			proxy.writeError(w, r, http.StatusInternalServerError, "internal error")
 		}
 	})
 }
+
+// authOutcome classifies the result of an authentication attempt
+func authOutcome(info *types.Info, err error) string {
+	switch {
+	case err == nil && (info.JWT != nil || info.User != nil):
+		return "success"
+	case err == nil:
+		return "anonymous"
+	case errors.Is(err, types.ErrAuthenticationFailed):
+		return "failed"
+	case errors.Is(err, types.ErrAuthenticationUnavailable):
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
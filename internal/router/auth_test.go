@@ -0,0 +1,158 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/authchain"
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// bufferingAuthProvider reads the body and puts a copy back, like a provider verifying it
+type bufferingAuthProvider struct{ body string }
+
+func (a *bufferingAuthProvider) Authenticate(info *types.Info, r *http.Request) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	a.body = string(b)
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	return nil
+}
+
+func TestAuthHandler_BufferedBodyForwarded(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	portNum, _ := strconv.Atoi(port)
+
+	auth := &bufferingAuthProvider{}
+	proxy := New(auth, &resultValidator{result: map[string]interface{}{"allow": true}}, &config.Fields{
+		BackendScheme: "http",
+		BackendHost:   host,
+		BackendPort:   portNum,
+		RequestRego:   "request.rego",
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(`{"event":"paid"}`)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if auth.body != `{"event":"paid"}` || received != auth.body {
+		t.Errorf("Expected the body to reach the provider and the backend, got %q and %q", auth.body, received)
+	}
+}
+
+// tokenAuthProvider accepts the token "good" and rejects any other
+type tokenAuthProvider struct{ name string }
+
+func (a *tokenAuthProvider) Name() string { return a.name }
+
+func (a *tokenAuthProvider) Authenticate(info *types.Info, r *http.Request) error {
+	if info.Request.Auth == nil || info.Request.Auth.Token != "good" {
+		return types.ErrAuthenticationFailed
+	}
+	info.User = map[string]interface{}{"name": a.name}
+	return nil
+}
+
+// authTotal sums restrego_auth_total over all providers and outcomes
+func authTotal(t *testing.T) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Handler()(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	total := 0
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "restrego_auth_total{") {
+			continue
+		}
+		n, err := strconv.Atoi(line[strings.LastIndex(line, " ")+1:])
+		if err != nil {
+			t.Fatalf("Unexpected metric line %q", line)
+		}
+		total += n
+	}
+	return total
+}
+
+func TestAuthHandler_MetricsCountedOnce(t *testing.T) {
+	metrics.New()
+	t.Cleanup(metrics.New)
+
+	chain := authchain.New(
+		authchain.Entry{Provider: &tokenAuthProvider{name: "jwt"}, Scheme: "bearer"},
+		authchain.Entry{Provider: &tokenAuthProvider{name: "basic"}, Scheme: "basic"},
+	)
+	proxy := New(chain, &resultValidator{result: map[string]interface{}{"allow": true}}, &config.Fields{
+		BackendScheme: "http",
+		BackendHost:   "backend.invalid",
+		BackendPort:   1,
+		AuthHeader:    "Authorization",
+		ForwardAuth:   true,
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	for _, auth := range []string{"Bearer good", "Basic bad", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		proxy.mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := authTotal(t); got != 3 {
+		t.Errorf("Expected 3 authentications counted for 3 requests, got %d", got)
+	}
+}
+
+func TestAuthHandler_SingleProviderRecorded(t *testing.T) {
+	v := &captureValidator{result: map[string]interface{}{"allow": true}}
+	proxy := New(&tokenAuthProvider{name: "jwt"}, v, &config.Fields{
+		BackendScheme: "http",
+		BackendHost:   "backend.invalid",
+		BackendPort:   1,
+		AuthHeader:    "Authorization",
+		ForwardAuth:   true,
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if v.input == nil || v.input.Request.Auth == nil {
+		t.Fatal("Expected the policy input to have request.auth")
+	}
+	if got := v.input.Request.Auth.Provider; got != "jwt" {
+		t.Errorf("Expected input.request.auth.provider to be jwt, got %q", got)
+	}
+}
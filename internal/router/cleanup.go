@@ -15,9 +15,18 @@ type ctxKey int
 const ctxBlockedHeadersKey ctxKey = 1
 
 // CleanupHandler removes incoming headers that shouldn't be there
-// (like any X-Restrego header which is considered spoofing-attempts)
+// (like any X-Restrego header which is considered spoofing-attempts,
+// or forwarding headers not added by a trusted proxy)
 func (proxy *Proxy) CleanupHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// in forward-auth mode without trusted proxies the ingress describes
+		// the request in these headers, and whoever connects is believed
+		forwardAuth := proxy.config != nil && proxy.config.ForwardAuth
+		if len(proxy.trusted) > 0 || !forwardAuth {
+			clientIP := proxy.trusted.resolveClient(r, proxy.clientHeader)
+			r = r.WithContext(context.WithValue(r.Context(), types.CtxClientIPKey, clientIP))
+		}
+
 		// to avoid risk of delete-key-while-looping we add keys to separate list
 		var toClean []string
 		for key := range r.Header {
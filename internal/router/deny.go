@@ -0,0 +1,138 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// policy result fields that shape a denial; 'allow' alone decides if the
+// request is denied
+const (
+	denyStatusKey  = "status"
+	denyHeadersKey = "headers"
+	denyBodyKey    = "body"
+	denyReasonKey  = "reason"
+)
+
+// denyProtectedHeaders are never set from the policy result
+var denyProtectedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// denyResponse is the response sent when the policy denies a request
+type denyResponse struct {
+	status      int
+	header      http.Header
+	reason      string
+	contentType string
+	body        []byte // nil unless the policy provides a body
+}
+
+// newDenyResponse builds the denial from the optional status, headers, body
+// and reason fields of the policy result. Invalid fields are logged and
+// ignored, so a broken field never turns a denial into anything but a denial.
+func newDenyResponse(result map[string]interface{}) *denyResponse {
+	d := &denyResponse{
+		status:      http.StatusForbidden,
+		header:      make(http.Header),
+		reason:      "access denied",
+		contentType: "text/plain; charset=utf-8",
+	}
+
+	if v, ok := result[denyStatusKey]; ok {
+		status, err := toInt(v)
+		switch {
+		case err != nil:
+			slog.Warn("router: policy 'status' is not a number - using 403", "value", v)
+		case status < 400 || status > 599:
+			slog.Warn("router: policy 'status' must be 4xx or 5xx for a denial - using 403", "status", status)
+		default:
+			d.status = status
+		}
+	}
+
+	if v, ok := result[denyHeadersKey]; ok {
+		headers, ok := v.(map[string]interface{})
+		if !ok {
+			slog.Warn("router: policy 'headers' is not an object - ignored", "type", fmt.Sprintf("%T", v))
+		}
+		for k, hv := range headers {
+			key := http.CanonicalHeaderKey(k)
+			if denyProtectedHeaders[key] {
+				slog.Warn("router: policy may not set header - ignored", "header", key)
+				continue
+			}
+			switch val := hv.(type) {
+			case string:
+				d.header.Add(key, val)
+			case []interface{}:
+				for _, x := range val {
+					d.header.Add(key, fmt.Sprint(x))
+				}
+			default:
+				d.header.Add(key, fmt.Sprint(val))
+			}
+		}
+	}
+
+	if reason, ok := result[denyReasonKey].(string); ok && reason != "" {
+		d.reason = reason
+	}
+
+	if v, ok := result[denyBodyKey]; ok && v != nil {
+		switch body := v.(type) {
+		case string:
+			d.body = []byte(body)
+		default:
+			buf, err := json.Marshal(body)
+			if err != nil {
+				slog.Warn("router: policy 'body' cannot be encoded - ignored", "error", err)
+				break
+			}
+			d.body = buf
+			d.contentType = "application/json"
+		}
+	}
+
+	return d
+}
+
+// write sends the denial; without a policy-provided body the reason is sent
+// like any other rest-rego error
+func (d *denyResponse) write(proxy *Proxy, w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range d.header {
+		h[k] = v
+	}
+	if d.body == nil {
+		proxy.writeError(w, r, d.status, d.reason)
+		return
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", d.contentType)
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(d.status)
+	w.Write(d.body)
+}
+
+// toInt converts a number from a policy result (json.Number from rego)
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	case int:
+		return n, nil
+	case string:
+		return strconv.Atoi(n)
+	}
+	return 0, fmt.Errorf("not a number: %T", v)
+}
@@ -0,0 +1,105 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// headers an ingress uses to describe the request it wants authorized,
+// NGINX auth_request (X-Original-*) and Traefik ForwardAuth (X-Forwarded-*)
+var (
+	originalMethodHeaders = []string{"X-Original-Method", "X-Forwarded-Method"}
+	originalURIHeaders    = []string{"X-Original-Uri", "X-Forwarded-Uri"}
+)
+
+// forwardAuthHandler rebuilds the request an ingress asks about, so the auth
+// and policy handlers see the original method, path, host and scheme. Envoy's
+// HTTP ext_authz sends the original request as-is, with an optional path prefix.
+// With trusted proxies only they may ask, anyone else could have any request
+// authorized by describing it.
+func (proxy *Proxy) forwardAuthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(proxy.trusted) > 0 && !proxy.trusted.trustsPeer(r) {
+			slog.Warn("router: forward-auth request from untrusted peer", "remote", r.RemoteAddr, "path", r.URL.Path)
+			proxy.writeError(w, r, http.StatusBadRequest, "untrusted forward-auth peer")
+			return
+		}
+		r2, err := proxy.originalRequest(r)
+		if err != nil {
+			slog.Warn("router: invalid forward-auth request", "error", err, "path", r.URL.Path)
+			proxy.writeError(w, r, http.StatusBadRequest, "invalid original request")
+			return
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// originalRequest returns a copy of r describing the original request
+func (proxy *Proxy) originalRequest(r *http.Request) (*http.Request, error) {
+	ctx := r.Context()
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+		scheme = strings.ToLower(proto)
+	}
+	ctx = context.WithValue(ctx, types.CtxSchemeKey, scheme)
+
+	r2 := r.Clone(ctx)
+	if method := headerValue(r.Header, originalMethodHeaders); method != "" {
+		r2.Method = strings.ToUpper(method)
+	}
+
+	if uri := headerValue(r.Header, originalURIHeaders); uri != "" {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil || !strings.HasPrefix(uri, "/") {
+			return nil, fmt.Errorf("invalid original uri %q", uri)
+		}
+		r2.URL = u
+		r2.RequestURI = uri
+	} else if prefix := proxy.config.ForwardAuthPrefix; prefix != "" {
+		r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		r2.URL.RawPath = ""
+		r2.RequestURI = r2.URL.RequestURI()
+	}
+
+	if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+		r2.Host = host
+	}
+
+	// these describe the original request, they were not part of it
+	for _, key := range append(originalMethodHeaders, originalURIHeaders...) {
+		r2.Header.Del(key)
+	}
+	return r2, nil
+}
+
+// allowResponse answers an allowed authorization request, passing the policy
+// result to the ingress as X-Restrego-* response headers
+func (proxy *Proxy) allowResponse(w http.ResponseWriter, r *http.Request) {
+	setResultHeaders(w.Header(), types.GetInfo(r))
+	w.WriteHeader(http.StatusOK)
+}
+
+// headerValue returns the first of the headers that is set
+func headerValue(h http.Header, keys []string) string {
+	for _, key := range keys {
+		if v := strings.TrimSpace(h.Get(key)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// firstValue returns the first entry of a comma-separated header value
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
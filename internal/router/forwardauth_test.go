@@ -0,0 +1,213 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// captureValidator returns a fixed result and keeps the input it was given
+type captureValidator struct {
+	result interface{}
+	input  *types.Info
+}
+
+func (v *captureValidator) Validate(name string, input interface{}) (interface{}, error) {
+	v.input, _ = input.(*types.Info)
+	return v.result, nil
+}
+
+func newForwardAuthProxy(t *testing.T, v types.Validator, prefix string) *Proxy {
+	t.Helper()
+	// the backend is never contacted in forward-auth mode
+	proxy := New(&errorAuthProvider{}, v, &config.Fields{
+		BackendScheme:     "http",
+		BackendHost:       "backend.invalid",
+		BackendPort:       1,
+		ForwardAuth:       true,
+		ForwardAuthPrefix: prefix,
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+	return proxy
+}
+
+func TestForwardAuth_OriginalRequest(t *testing.T) {
+	testCases := []struct {
+		name       string
+		prefix     string
+		method     string
+		target     string
+		headers    map[string]string
+		wantMethod string
+		wantPath   []string
+		wantQuery  string
+		wantHost   string
+		wantScheme string
+	}{
+		{
+			name:   "nginx auth_request",
+			method: http.MethodGet, target: "/auth",
+			headers: map[string]string{
+				"X-Original-Uri":    "/api/orders/1?expand=items",
+				"X-Original-Method": "delete",
+				"X-Forwarded-Host":  "shop.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			wantMethod: http.MethodDelete, wantPath: []string{"api", "orders", "1"}, wantQuery: "expand=items",
+			wantHost: "shop.example.com", wantScheme: "https",
+		},
+		{
+			name:   "traefik forwardauth",
+			method: http.MethodGet, target: "/",
+			headers: map[string]string{
+				"X-Forwarded-Method": "POST",
+				"X-Forwarded-Uri":    "/api/items",
+				"X-Forwarded-Host":   "api.example.com",
+				"X-Forwarded-Proto":  "https,http",
+			},
+			wantMethod: http.MethodPost, wantPath: []string{"api", "items"},
+			wantHost: "api.example.com", wantScheme: "https",
+		},
+		{
+			name:   "envoy ext_authz with path prefix",
+			prefix: "/authz",
+			method: http.MethodPut, target: "/authz/api/items/7?dry=1",
+			wantMethod: http.MethodPut, wantPath: []string{"api", "items", "7"}, wantQuery: "dry=1",
+			wantHost: "example.com", wantScheme: "http",
+		},
+		{
+			name:   "envoy ext_authz without prefix",
+			method: http.MethodGet, target: "/api/items",
+			wantMethod: http.MethodGet, wantPath: []string{"api", "items"},
+			wantHost: "example.com", wantScheme: "http",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &captureValidator{result: map[string]interface{}{"allow": true, "user_role": "admin"}}
+			proxy := newForwardAuthProxy(t, v, tc.prefix)
+
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			for k, val := range tc.headers {
+				req.Header.Set(k, val)
+			}
+			w := httptest.NewRecorder()
+			proxy.mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("X-Restrego-User-Role"); got != "admin" {
+				t.Errorf("Expected result header on the response, got %q", got)
+			}
+
+			in := v.input.Request
+			if in.Method != tc.wantMethod {
+				t.Errorf("Expected method %s, got %s", tc.wantMethod, in.Method)
+			}
+			if len(in.Path) != len(tc.wantPath) {
+				t.Fatalf("Expected path %v, got %v", tc.wantPath, in.Path)
+			}
+			for i := range tc.wantPath {
+				if in.Path[i] != tc.wantPath[i] {
+					t.Errorf("Expected path %v, got %v", tc.wantPath, in.Path)
+				}
+			}
+			if in.RawQuery != tc.wantQuery {
+				t.Errorf("Expected query %q, got %q", tc.wantQuery, in.RawQuery)
+			}
+			if in.Host != tc.wantHost {
+				t.Errorf("Expected host %q, got %q", tc.wantHost, in.Host)
+			}
+			if in.Scheme != tc.wantScheme {
+				t.Errorf("Expected scheme %q, got %q", tc.wantScheme, in.Scheme)
+			}
+			for _, h := range []string{"X-Original-Uri", "X-Original-Method", "X-Forwarded-Uri", "X-Forwarded-Method"} {
+				if _, ok := in.Headers[h]; ok {
+					t.Errorf("Expected %s not to be part of the original request", h)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardAuth_Deny(t *testing.T) {
+	proxy := newForwardAuthProxy(t, &resultValidator{result: map[string]interface{}{
+		"allow":   false,
+		"status":  401,
+		"headers": map[string]interface{}{"WWW-Authenticate": `Bearer realm="shop"`},
+	}}, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("X-Original-Uri", "/admin")
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="shop"` {
+		t.Errorf("Expected policy header on the denial, got %q", got)
+	}
+}
+
+func TestForwardAuth_InvalidURI(t *testing.T) {
+	proxy := newForwardAuthProxy(t, &resultValidator{result: map[string]interface{}{"allow": true}}, "")
+
+	for _, uri := range []string{"http://evil.example.com/x", "api/items", "/%zz"} {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.Header.Set("X-Original-Uri", uri)
+		w := httptest.NewRecorder()
+		proxy.mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", uri, w.Code)
+		}
+	}
+}
+
+func TestForwardAuth_UntrustedPeer(t *testing.T) {
+	v := &captureValidator{result: map[string]interface{}{"allow": true}}
+	proxy := New(&errorAuthProvider{}, v, &config.Fields{
+		BackendScheme:  "http",
+		BackendHost:    "backend.invalid",
+		BackendPort:    1,
+		ForwardAuth:    true,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	newRequest := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Original-Uri", "/admin")
+		req.Header.Set("X-Original-Method", "DELETE")
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, newRequest("203.0.113.7:1234"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an untrusted peer, got %d", w.Code)
+	}
+	if v.input != nil {
+		t.Error("Expected the policy not to be evaluated for an untrusted peer")
+	}
+
+	w = httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, newRequest("10.0.0.1:1234"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for the ingress, got %d", w.Code)
+	}
+	if in := v.input.Request; in.Method != http.MethodDelete || len(in.Path) != 1 || in.Path[0] != "admin" {
+		t.Errorf("Expected the original request from the ingress, got %s %v", in.Method, in.Path)
+	}
+}
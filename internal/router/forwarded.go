@@ -0,0 +1,204 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the networks whose forwarding headers are believed
+type trustedProxies []netip.Prefix
+
+// forwardingHeaders describe the request as a proxy in front of us saw it,
+// along with any X-Original-* header (NGINX auth_request)
+var forwardingHeaders = []string{
+	"X-Forwarded-For", "Forwarded", "X-Forwarded-Host", "X-Forwarded-Proto",
+	"X-Forwarded-Method", "X-Forwarded-Uri",
+}
+
+// parseTrustedProxies parses CIDRs or single IP addresses
+func parseTrustedProxies(list []string) (trustedProxies, error) {
+	var t trustedProxies
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+			}
+			t = append(t, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		t = append(t, prefix.Masked())
+	}
+	return t, nil
+}
+
+func (t trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range t {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsPeer reports whether the peer connecting with r is a trusted proxy
+func (t trustedProxies) trustsPeer(r *http.Request) bool {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	return err == nil && t.contains(peer.Addr())
+}
+
+// resolveClient determines the real client address of the request and
+// removes forwarding entries that were not added by a trusted proxy. The
+// forwarding headers are only believed when the connecting peer is trusted;
+// clientHeader (X-Forwarded-For or Forwarded) is then walked from the right,
+// skipping trusted proxies, and the first untrusted address is the client.
+// Everything left of it could have been sent by the client itself and is
+// dropped. The other header is removed, as a proxy maintaining one usually
+// passes the client's value of the other on untouched. With no trusted
+// proxies every peer is untrusted.
+func (t trustedProxies) resolveClient(r *http.Request, clientHeader string) string {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !t.contains(peer.Addr()) {
+		for _, key := range forwardingHeaders {
+			r.Header.Del(key)
+		}
+		for key := range r.Header {
+			if strings.HasPrefix(key, "X-Original-") {
+				r.Header.Del(key)
+			}
+		}
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return peer.Addr().Unmap().String()
+	}
+
+	client := peer.Addr().Unmap()
+	if clientHeader == "Forwarded" {
+		r.Header.Del("X-Forwarded-For")
+		if fromForwarded, ok := t.trimChain(r.Header, "Forwarded", splitForwarded, parseForwardedElement); ok {
+			client = fromForwarded
+		}
+	} else {
+		r.Header.Del("Forwarded")
+		if fromXFF, ok := t.trimChain(r.Header, "X-Forwarded-For", splitList, parseForwardedFor); ok {
+			client = fromXFF
+		}
+	}
+	return client.String()
+}
+
+// trimChain walks the hops in header from the right, rewrites the header to
+// only contain the client and the trusted proxies after it, and returns the
+// client address.
+func (t trustedProxies) trimChain(h http.Header, header string, split func([]string) []string, parse func(string) (netip.Addr, bool)) (netip.Addr, bool) {
+	hops := split(h.Values(header))
+	if len(hops) == 0 {
+		return netip.Addr{}, false
+	}
+
+	var client netip.Addr
+	keep := 0
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parse(hops[i])
+		if !ok {
+			// unknown or obfuscated hop: nothing beyond it can be verified,
+			// so the last trusted proxy is the best known client
+			keep = i + 1
+			break
+		}
+		client = addr
+		keep = i
+		if !t.contains(addr) {
+			break
+		}
+	}
+
+	if keep >= len(hops) {
+		h.Del(header)
+	} else {
+		h.Set(header, strings.Join(hops[keep:], ", "))
+	}
+	return client, client.IsValid()
+}
+
+// splitList splits comma-separated header values
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// splitForwarded splits RFC 7239 header values into elements, respecting
+// quoted strings
+func splitForwarded(values []string) []string {
+	var out []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	for _, v := range values {
+		start, quoted := 0, false
+		for i := 0; i < len(v); i++ {
+			switch {
+			case v[i] == '"':
+				quoted = !quoted
+			case v[i] == '\\' && quoted:
+				i++
+			case v[i] == ',' && !quoted:
+				add(v[start:i])
+				start = i + 1
+			}
+		}
+		add(v[start:])
+	}
+	return out
+}
+
+// parseForwardedFor parses an X-Forwarded-For entry (an IP, optionally with port)
+func parseForwardedFor(s string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// parseForwardedElement returns the address in the for= parameter of an
+// RFC 7239 element
+func parseForwardedElement(element string) (netip.Addr, bool) {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !strings.EqualFold(key, "for") {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		if strings.HasPrefix(value, "[") {
+			if end := strings.Index(value, "]"); end > 0 {
+				value = value[1:end]
+			}
+		}
+		return parseForwardedFor(value)
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,237 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.5", " ", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trusted) != 3 {
+		t.Fatalf("Expected 3 prefixes, got %d", len(trusted))
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0"} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestResolveClient(t *testing.T) {
+	trusted, _ := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+
+	testCases := []struct {
+		name          string
+		remoteAddr    string
+		header        string
+		xff           []string
+		forwarded     []string
+		wantClient    string
+		wantXFF       string
+		wantForwarded string
+	}{
+		{
+			name:       "untrusted peer strips headers",
+			remoteAddr: "203.0.113.7:1234",
+			xff:        []string{"1.2.3.4"},
+			forwarded:  []string{"for=1.2.3.4"},
+			wantClient: "203.0.113.7",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:1234",
+			wantClient: "10.0.0.1",
+		},
+		{
+			name:       "single proxy",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.10"},
+			wantClient: "198.51.100.10",
+			wantXFF:    "198.51.100.10",
+		},
+		{
+			name:       "spoofed entries left of the client are removed",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"6.6.6.6, 198.51.100.10", "10.1.1.1"},
+			wantClient: "198.51.100.10",
+			wantXFF:    "198.51.100.10, 10.1.1.1",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"10.2.2.2, 10.1.1.1"},
+			wantClient: "10.2.2.2",
+			wantXFF:    "10.2.2.2, 10.1.1.1",
+		},
+		{
+			name:       "garbage stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.10, garbage, 10.1.1.1"},
+			wantClient: "10.1.1.1",
+			wantXFF:    "10.1.1.1",
+		},
+		{
+			name:       "garbage as last hop",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"garbage"},
+			wantClient: "10.0.0.1",
+		},
+		{
+			name:          "forwarded header with ipv6 and port",
+			remoteAddr:    "[2001:db8::1]:443",
+			header:        "Forwarded",
+			forwarded:     []string{`for=6.6.6.6, for="[2a01:4f8:cafe::17]:4711";proto=https, for=10.0.0.5;by=10.0.0.1`},
+			wantClient:    "2a01:4f8:cafe::17",
+			wantForwarded: `for="[2a01:4f8:cafe::17]:4711";proto=https, for=10.0.0.5;by=10.0.0.1`,
+		},
+		{
+			name:       "client's forwarded is ignored and removed",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"203.0.113.9"},
+			forwarded:  []string{"for=192.0.2.66"},
+			wantClient: "203.0.113.9",
+			wantXFF:    "203.0.113.9",
+		},
+		{
+			name:          "client's x-forwarded-for is ignored and removed",
+			remoteAddr:    "10.0.0.1:1234",
+			header:        "Forwarded",
+			xff:           []string{"192.0.2.66"},
+			forwarded:     []string{"for=198.51.100.20", "for=10.0.0.2"},
+			wantClient:    "198.51.100.20",
+			wantForwarded: "for=198.51.100.20, for=10.0.0.2",
+		},
+		{
+			name:          "obfuscated forwarded identifier",
+			remoteAddr:    "10.0.0.1:1234",
+			header:        "Forwarded",
+			forwarded:     []string{"for=_hidden, for=10.0.0.2"},
+			wantClient:    "10.0.0.2",
+			wantForwarded: "for=10.0.0.2",
+		},
+		{
+			name:          "quoted comma in forwarded",
+			remoteAddr:    "10.0.0.1:1234",
+			header:        "Forwarded",
+			forwarded:     []string{`for=198.51.100.30;host="a,b"`},
+			wantClient:    "198.51.100.30",
+			wantForwarded: `for=198.51.100.30;host="a,b"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			for _, v := range tc.forwarded {
+				req.Header.Add("Forwarded", v)
+			}
+
+			header := tc.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			if got := trusted.resolveClient(req, header); got != tc.wantClient {
+				t.Errorf("Expected client %q, got %q", tc.wantClient, got)
+			}
+			if got := req.Header.Get("X-Forwarded-For"); got != tc.wantXFF {
+				t.Errorf("Expected X-Forwarded-For %q, got %q", tc.wantXFF, got)
+			}
+			if got := req.Header.Get("Forwarded"); got != tc.wantForwarded {
+				t.Errorf("Expected Forwarded %q, got %q", tc.wantForwarded, got)
+			}
+		})
+	}
+}
+
+func TestResolveClient_UntrustedStripsOriginalRequest(t *testing.T) {
+	trusted, _ := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	for _, key := range []string{"X-Forwarded-Method", "X-Forwarded-Uri", "X-Original-Method", "X-Original-Uri", "X-Original-Url"} {
+		req.Header.Set(key, "spoofed")
+	}
+	req.Header.Set("X-Other", "kept")
+
+	trusted.resolveClient(req, "X-Forwarded-For")
+	for key := range req.Header {
+		if key != "X-Other" {
+			t.Errorf("Expected %s to be removed", key)
+		}
+	}
+	if req.Header.Get("X-Other") != "kept" {
+		t.Error("Expected other headers to be kept")
+	}
+}
+
+func TestCleanupHandler_ClientIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		trusted    []string
+		wantClient string
+		wantXFF    string
+	}{
+		{"no trusted proxies strips headers", nil, "10.0.0.1", ""},
+		{"trusted ingress", []string{"10.0.0.0/8"}, "198.51.100.10", "198.51.100.10"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := New(&errorAuthProvider{}, &resultValidator{}, &config.Fields{
+				BackendScheme:  "http",
+				BackendHost:    "localhost",
+				BackendPort:    8080,
+				TrustedProxies: tc.trusted,
+			})
+			if proxy == nil {
+				t.Fatal("Failed to create proxy")
+			}
+
+			var info *types.Info
+			var xff string
+			handler := proxy.CleanupHandler(proxy.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				info = types.GetInfo(r)
+				xff = r.Header.Get("X-Forwarded-For")
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "6.6.6.6, 198.51.100.10")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if info.Request.ClientIP != tc.wantClient {
+				t.Errorf("Expected client_ip %q, got %q", tc.wantClient, info.Request.ClientIP)
+			}
+			if info.Request.RemoteAddr != "10.0.0.1" {
+				t.Errorf("Expected remote_addr to stay the peer, got %q", info.Request.RemoteAddr)
+			}
+			if xff != tc.wantXFF {
+				t.Errorf("Expected X-Forwarded-For %q, got %q", tc.wantXFF, xff)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidTrustedProxy(t *testing.T) {
+	proxy := New(&errorAuthProvider{}, &resultValidator{}, &config.Fields{
+		BackendScheme:  "http",
+		BackendHost:    "localhost",
+		BackendPort:    8080,
+		TrustedProxies: []string{"nonsense"},
+	})
+	if proxy != nil {
+		t.Error("Expected nil proxy for invalid trusted proxy")
+	}
+}
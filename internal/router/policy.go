@@ -1,11 +1,16 @@
 package router
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
@@ -13,19 +18,26 @@ func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
 		info := types.GetInfo(r)
 		if info == nil {
 			slog.Error("router: missing request context")
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			proxy.writeError(w, r, http.StatusInternalServerError, "internal error")
 			return
 		}
 
+		ctx, span := tracing.Start(r.Context(), "policy", attribute.String("rego.file", proxy.requestName))
+		now := time.Now()
+		result, err := proxy.validate(ctx, info)
+		decision := decisionOf(result, err)
+		metrics.ObservePolicyEvaluation(proxy.requestName, decision, time.Since(now))
+		span.SetAttributes(attribute.String("rego.decision", decision))
+		tracing.End(span, err)
+
 		// Explicit error handling - fail closed on evaluation errors
-		result, err := proxy.validator.Validate(proxy.requestName, info)
 		if err != nil {
 			slog.Error("router: policy evaluation failed",
 				"error", err,
 				"path", r.URL.Path,
 				"method", r.Method,
 				"id", info.Request.ID)
-			http.Error(w, "policy evaluation error", http.StatusInternalServerError)
+			proxy.writeError(w, r, http.StatusInternalServerError, "policy evaluation error")
 			return // EXPLICIT FAIL CLOSED
 		}
 
@@ -34,16 +46,17 @@ func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
 		if !ok {
 			slog.Error("router: invalid policy result type",
 				"type", fmt.Sprintf("%T", result),
-				"path", r.URL.Path)
-			http.Error(w, "invalid policy result", http.StatusInternalServerError)
+				"path", r.URL.Path,
+				"id", info.Request.ID)
+			proxy.writeError(w, r, http.StatusInternalServerError, "invalid policy result")
 			return
 		}
 
 		// Validate that 'allow' field exists and is boolean - PREVENTS FAIL-OPEN
 		allowValue, allowExists := resultMap["allow"]
 		if !allowExists {
-			slog.Error("router: policy result missing 'allow' field", "path", r.URL.Path)
-			http.Error(w, "invalid policy result", http.StatusInternalServerError)
+			slog.Error("router: policy result missing 'allow' field", "path", r.URL.Path, "id", info.Request.ID)
+			proxy.writeError(w, r, http.StatusInternalServerError, "invalid policy result")
 			return
 		}
 
@@ -51,8 +64,9 @@ func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
 		if !isBool {
 			slog.Error("router: policy 'allow' field is not boolean",
 				"type", fmt.Sprintf("%T", allowValue),
-				"path", r.URL.Path)
-			http.Error(w, "invalid policy result", http.StatusInternalServerError)
+				"path", r.URL.Path,
+				"id", info.Request.ID)
+			proxy.writeError(w, r, http.StatusInternalServerError, "invalid policy result")
 			return
 		}
 
@@ -60,11 +74,13 @@ func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
 
 		// Explicit deny check - fail closed by default
 		if !allowBool {
+			deny := newDenyResponse(resultMap)
 			slog.Info("router: access denied by policy",
 				"path", r.URL.Path,
 				"method", r.Method,
+				"status", deny.status,
 				"id", info.Request.ID)
-			http.Error(w, "access denied", http.StatusForbidden)
+			deny.write(proxy, w, r)
 			return
 		}
 
@@ -77,3 +93,27 @@ func (proxy *Proxy) policyHandler(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// validate evaluates the request policy, passing the context on to validators supporting it
+func (proxy *Proxy) validate(ctx context.Context, info *types.Info) (interface{}, error) {
+	if v, ok := proxy.validator.(types.ContextValidator); ok {
+		return v.ValidateContext(ctx, proxy.requestName, info)
+	}
+	return proxy.validator.Validate(proxy.requestName, info)
+}
+
+// decisionOf classifies a policy result as allow, deny or error
+func decisionOf(result interface{}, err error) string {
+	if err != nil {
+		return "error"
+	}
+	if m, ok := result.(map[string]interface{}); ok {
+		if allow, ok := m["allow"].(bool); ok {
+			if allow {
+				return "allow"
+			}
+			return "deny"
+		}
+	}
+	return "error"
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// resultValidator returns a fixed policy result
+type resultValidator struct {
+	result interface{}
+}
+
+func (v *resultValidator) Validate(name string, input interface{}) (interface{}, error) {
+	return v.result, nil
+}
+
+func runPolicy(t *testing.T, result map[string]interface{}) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	proxy := &Proxy{requestName: "request.rego", validator: &resultValidator{result: result}}
+
+	called := false
+	handler := proxy.policyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	req = types.NewInfo(req, "Authorization", 0).RequestWithInfo(req)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w, called
+}
+
+func TestPolicyHandler_DenyResponse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		result      map[string]interface{}
+		wantStatus  int
+		wantBody    string
+		wantType    string
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "default denial",
+			result:     map[string]interface{}{"allow": false},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied\n",
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "not found with reason",
+			result:     map[string]interface{}{"allow": false, "status": json.Number("404"), "reason": "not found"},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "not found\n",
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name: "rate limited with headers",
+			result: map[string]interface{}{
+				"allow":   false,
+				"status":  json.Number("429"),
+				"headers": map[string]interface{}{"retry-after": "30", "X-Limit": []interface{}{"a", "b"}},
+			},
+			wantStatus:  http.StatusTooManyRequests,
+			wantBody:    "access denied\n",
+			wantType:    "text/plain; charset=utf-8",
+			wantHeaders: map[string]string{"Retry-After": "30", "X-Limit": "a"},
+		},
+		{
+			name: "challenge with json body",
+			result: map[string]interface{}{
+				"allow":   false,
+				"status":  json.Number("401"),
+				"headers": map[string]interface{}{"WWW-Authenticate": `Bearer scope="orders:write"`},
+				"body":    map[string]interface{}{"missing": "orders:write"},
+			},
+			wantStatus:  http.StatusUnauthorized,
+			wantBody:    `{"missing":"orders:write"}`,
+			wantType:    "application/json",
+			wantHeaders: map[string]string{"WWW-Authenticate": `Bearer scope="orders:write"`},
+		},
+		{
+			name: "string body with own content type",
+			result: map[string]interface{}{
+				"allow":   false,
+				"body":    "<p>denied</p>",
+				"headers": map[string]interface{}{"Content-Type": "text/html"},
+			},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "<p>denied</p>",
+			wantType:   "text/html",
+		},
+		{
+			name:       "success status is not a denial",
+			result:     map[string]interface{}{"allow": false, "status": json.Number("200")},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied\n",
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name:       "invalid status",
+			result:     map[string]interface{}{"allow": false, "status": true},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied\n",
+			wantType:   "text/plain; charset=utf-8",
+		},
+		{
+			name: "protected headers ignored",
+			result: map[string]interface{}{
+				"allow":   false,
+				"headers": map[string]interface{}{"Content-Length": "1"},
+			},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied\n",
+			wantType:   "text/plain; charset=utf-8",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, called := runPolicy(t, tc.result)
+			if called {
+				t.Fatal("Expected request to be denied")
+			}
+			if w.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, w.Code)
+			}
+			if w.Body.String() != tc.wantBody {
+				t.Errorf("Expected body %q, got %q", tc.wantBody, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tc.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tc.wantType, ct)
+			}
+			for k, v := range tc.wantHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("Expected header %s=%q, got %q", k, v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyHandler_DenyFieldsIgnoredWhenAllowed(t *testing.T) {
+	w, called := runPolicy(t, map[string]interface{}{"allow": true, "status": json.Number("404")})
+	if !called {
+		t.Fatal("Expected request to be allowed")
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestSetResultHeaders_SkipsDenyFields(t *testing.T) {
+	h := http.Header{}
+	setResultHeaders(h, &types.Info{Result: map[string]interface{}{
+		"allow":     true,
+		"tenant_id": "acme",
+		"status":    json.Number("404"),
+		"headers":   map[string]interface{}{"Retry-After": "30"},
+		"body":      "<p>denied</p>",
+	}})
+
+	if got := h.Get(headerPrefix + "tenant-id"); got != "acme" {
+		t.Errorf("Expected tenant-id header, got %q", got)
+	}
+	for _, k := range []string{"status", "headers", "body"} {
+		if got := h.Get(headerPrefix + k); got != "" {
+			t.Errorf("Expected no %s header, got %q", k, got)
+		}
+	}
+}
+
+func TestPolicyHandler_AllowStillRequired(t *testing.T) {
+	w, called := runPolicy(t, map[string]interface{}{"status": json.Number("404")})
+	if called {
+		t.Fatal("Expected request without 'allow' to be rejected")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+}
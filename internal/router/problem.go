@@ -0,0 +1,94 @@
+package router
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 problem details object
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError sends an error generated by rest-rego itself. With problem-json
+// enabled, and a client accepting JSON, it is rendered as RFC 7807 problem
+// details; otherwise as plain text like http.Error.
+func (proxy *Proxy) writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if proxy.config == nil || !proxy.config.ProblemJSON || !acceptsJSON(r.Header.Values("Accept")) {
+		http.Error(w, detail, status)
+		return
+	}
+
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+	if info := types.GetInfo(r); info != nil {
+		p.RequestID = info.Request.ID
+	}
+
+	buf, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, detail, status)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", problemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// acceptsJSON reports if a client with the given Accept headers takes a JSON
+// response. A missing Accept header accepts anything; otherwise JSON must not
+// be ranked below text/plain.
+func acceptsJSON(accept []string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+
+	jsonQ, textQ := -1.0, -1.0
+	for _, header := range accept {
+		for _, entry := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			switch mediaType {
+			case problemContentType, "application/json":
+				jsonQ = max(jsonQ, q)
+			case "application/*":
+				jsonQ = max(jsonQ, q)
+			case "*/*":
+				jsonQ = max(jsonQ, q)
+				textQ = max(textQ, q)
+			case "text/plain", "text/*":
+				textQ = max(textQ, q)
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ >= textQ
+}
@@ -0,0 +1,159 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+type errorAuthProvider struct {
+	err error
+}
+
+func (a *errorAuthProvider) Authenticate(info *types.Info, r *http.Request) error {
+	return a.err
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	testCases := []struct {
+		accept []string
+		want   bool
+	}{
+		{nil, true},
+		{[]string{"*/*"}, true},
+		{[]string{"application/json"}, true},
+		{[]string{"application/problem+json"}, true},
+		{[]string{"text/plain"}, false},
+		{[]string{"text/html"}, false},
+		{[]string{"text/plain, application/json;q=0.5"}, false},
+		{[]string{"text/plain;q=0.5, application/json"}, true},
+		{[]string{"text/html", "application/*;q=0.8"}, true},
+		{[]string{"application/json;q=0"}, false},
+	}
+	for _, tc := range testCases {
+		if got := acceptsJSON(tc.accept); got != tc.want {
+			t.Errorf("acceptsJSON(%q) = %v, want %v", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestWriteError_ProblemJSON(t *testing.T) {
+	testCases := []struct {
+		name       string
+		auth       error
+		result     map[string]interface{}
+		accept     string
+		wantStatus int
+		wantDetail string
+	}{
+		{"auth failed", types.ErrAuthenticationFailed, nil, "", http.StatusUnauthorized, "invalid credentials"},
+		{"auth unavailable", types.ErrAuthenticationUnavailable, nil, "application/json", http.StatusServiceUnavailable, "authentication service unavailable"},
+		{"auth unexpected", errors.New("boom"), nil, "*/*", http.StatusInternalServerError, "internal error"},
+		{"policy deny", nil, map[string]interface{}{"allow": false}, "", http.StatusForbidden, "access denied"},
+		{"policy deny with reason", nil, map[string]interface{}{"allow": false, "status": json.Number("404"), "reason": "no such order"}, "", http.StatusNotFound, "no such order"},
+		{"invalid policy result", nil, map[string]interface{}{}, "", http.StatusInternalServerError, "invalid policy result"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := &Proxy{
+				requestName: "request.rego",
+				auth:        &errorAuthProvider{err: tc.auth},
+				validator:   &resultValidator{result: tc.result},
+				config:      &config.Fields{ProblemJSON: true},
+			}
+			handler := proxy.authHandler(proxy.policyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("Expected request to be stopped")
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			info := types.NewInfo(req, "Authorization", 0)
+			info.Request.ID = "req-123"
+			req = info.RequestWithInfo(req)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Fatalf("Expected Content-Type %q, got %q", problemContentType, ct)
+			}
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("Invalid problem body %q: %v", w.Body.String(), err)
+			}
+			want := problem{
+				Type:      "about:blank",
+				Title:     http.StatusText(tc.wantStatus),
+				Status:    tc.wantStatus,
+				Detail:    tc.wantDetail,
+				Instance:  "/orders/1",
+				RequestID: "req-123",
+			}
+			if p != want {
+				t.Errorf("Expected %+v, got %+v", want, p)
+			}
+		})
+	}
+}
+
+func TestWriteError_PlainText(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cfg    *config.Fields
+		accept string
+	}{
+		{"disabled", &config.Fields{}, "application/json"},
+		{"client prefers text", &config.Fields{ProblemJSON: true}, "text/plain"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := &Proxy{config: tc.cfg}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			proxy.writeError(w, req, http.StatusBadGateway, "bad gateway")
+
+			if w.Code != http.StatusBadGateway {
+				t.Errorf("Expected status 502, got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("Expected plain text, got %q", ct)
+			}
+			if w.Body.String() != "bad gateway\n" {
+				t.Errorf("Expected plain body, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWriteError_PolicyBodyWins(t *testing.T) {
+	proxy := &Proxy{
+		requestName: "request.rego",
+		validator: &resultValidator{result: map[string]interface{}{
+			"allow": false,
+			"body":  map[string]interface{}{"error": "custom"},
+		}},
+		config: &config.Fields{ProblemJSON: true},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = types.NewInfo(req, "Authorization", 0).RequestWithInfo(req)
+	w := httptest.NewRecorder()
+	proxy.policyHandler(http.NotFoundHandler()).ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected policy body as application/json, got %q", ct)
+	}
+	if w.Body.String() != `{"error":"custom"}` {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+}
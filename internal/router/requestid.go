@@ -0,0 +1,33 @@
+package router
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// validRequestID limits incoming request ids to a safe length and charset, so
+// they can be logged and forwarded without escaping
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=@-]{1,128}$`)
+
+// requestID returns the id of the request: the incoming id if present and
+// valid, otherwise a new UUIDv7
+func (proxy *Proxy) requestID(r *http.Request) string {
+	header := proxy.config.RequestIDHeader
+	if header != "" {
+		if id := r.Header.Get(header); id != "" {
+			if validRequestID.MatchString(id) {
+				return id
+			}
+			slog.Warn("router: invalid request id replaced", "header", header, "length", len(id))
+		}
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
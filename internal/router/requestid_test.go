@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		name      string
+		incoming  string
+		wantKept  bool
+		headerCfg string
+	}{
+		{"missing id is generated", "", false, "X-Request-Id"},
+		{"valid id is kept", "abc-123_DEF.456", true, "X-Request-Id"},
+		{"uuid is kept", "0190c3a2-7b1e-7c4d-9a51-3c0f4f1f2f7a", true, "X-Request-Id"},
+		{"id with spaces is replaced", "abc 123", false, "X-Request-Id"},
+		{"id with control characters is replaced", "abc\x00", false, "X-Request-Id"},
+		{"too long id is replaced", string(make([]byte, 129)), false, "X-Request-Id"},
+		{"custom header", "abc", true, "X-Correlation-Id"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := &Proxy{authKey: "Authorization", config: &config.Fields{RequestIDHeader: tc.headerCfg}}
+
+			var info *types.Info
+			var forwarded string
+			handler := proxy.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				info = types.GetInfo(r)
+				forwarded = r.Header.Get(tc.headerCfg)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				req.Header[tc.headerCfg] = []string{tc.incoming}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := info.Request.ID
+			if tc.wantKept {
+				if id != tc.incoming {
+					t.Errorf("Expected id %q to be kept, got %q", tc.incoming, id)
+				}
+			} else {
+				parsed, err := uuid.Parse(id)
+				if err != nil || parsed.Version() != 7 {
+					t.Errorf("Expected generated UUIDv7, got %q", id)
+				}
+			}
+			if forwarded != id {
+				t.Errorf("Expected id forwarded to backend, got %q", forwarded)
+			}
+			if got := w.Header().Get(tc.headerCfg); got != id {
+				t.Errorf("Expected id echoed on response, got %q", got)
+			}
+			if info.Request.Headers[tc.headerCfg] != id {
+				t.Errorf("Expected policy headers to carry the id, got %v", info.Request.Headers[tc.headerCfg])
+			}
+		})
+	}
+}
+
+func TestRequestID_BackendEchoNotDuplicated(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a backend echoing the id it received
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	portNum, _ := strconv.Atoi(port)
+
+	proxy := New(&errorAuthProvider{}, &resultValidator{result: map[string]interface{}{"allow": true}}, &config.Fields{
+		BackendScheme:   "http",
+		BackendHost:     host,
+		BackendPort:     portNum,
+		RequestIDHeader: "X-Request-Id",
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Values("X-Request-Id"); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("Expected exactly one request id on the response, got %v", got)
+	}
+}
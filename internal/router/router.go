@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/AB-Lindex/rest-rego/internal/config"
 	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tlsconfig"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
 
 	"github.com/go-chi/chi/v5"
@@ -37,8 +40,37 @@ func New(auth types.AuthProvider, validator types.Validator, cfg *config.Fields)
 		slog.Error("router: invalid backend URL", "error", err, "backend", proxy.backendURL)
 		return nil
 	}
+	proxy.trusted, err = parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		slog.Error("router: invalid trusted proxies", "error", err)
+		return nil
+	}
+	proxy.clientHeader = "X-Forwarded-For"
+	if strings.EqualFold(cfg.ClientIPHeader, "forwarded") {
+		proxy.clientHeader = "Forwarded"
+	}
+	if len(proxy.trusted) > 0 {
+		slog.Info("router: trusting forwarding headers", "proxies", cfg.TrustedProxies, "header", proxy.clientHeader)
+	} else if cfg.ForwardAuth {
+		slog.Warn("router: forward-auth without trusted proxies, the forwarding headers of any caller are believed")
+	}
+	if cfg.TLSCertFile != "" {
+		proxy.tls, err = tlsconfig.New(tlsconfig.Config{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+			ClientAuth:   cfg.TLSClientAuth,
+			MinVersion:   cfg.TLSMinVersion,
+			CipherSuites: cfg.TLSCipherSuites,
+		})
+		if err != nil {
+			slog.Error("router: invalid tls configuration", "error", err)
+			return nil
+		}
+	}
+
 	proxy.backend = httputil.NewSingleHostReverseProxy(remote)
-	proxy.backend.Transport = &http.Transport{
+	proxy.backend.Transport = tracing.Transport(&http.Transport{
 		// Connection pooling
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 100,
@@ -58,6 +90,14 @@ func New(auth types.AuthProvider, validator types.Validator, cfg *config.Fields)
 		// Prevent connection reuse issues
 		DisableKeepAlives:  false,
 		DisableCompression: false,
+	})
+
+	// the request id is already on the response, don't add the backend's echo of it
+	if cfg.RequestIDHeader != "" {
+		proxy.backend.ModifyResponse = func(resp *http.Response) error {
+			resp.Header.Del(cfg.RequestIDHeader)
+			return nil
+		}
 	}
 
 	// Add error handler for backend failures
@@ -65,22 +105,41 @@ func New(auth types.AuthProvider, validator types.Validator, cfg *config.Fields)
 		slog.Error("router: backend proxy error",
 			"error", err,
 			"backend", proxy.backendURL,
-			"path", r.URL.Path)
-		http.Error(w, "bad gateway", http.StatusBadGateway)
+			"path", r.URL.Path,
+			"id", requestID(r))
+		proxy.writeError(w, r, http.StatusBadGateway, "bad gateway")
 	}
 
 	// proxy.backend.Director = nil
 	// proxy.backend.Rewrite = proxy.Rewriter
 
 	proxy.mux = chi.NewRouter()
+	proxy.mux.Use(proxy.CleanupHandler) // cleanup before any other processing
+	if cfg.ForwardAuth {
+		// the ingress asks about a request, rebuild it before anything looks at it
+		proxy.mux.Use(proxy.forwardAuthHandler)
+	}
 	proxy.mux.Use(
-		proxy.CleanupHandler, // cleanup before any other processing
 		proxy.WrapHandler,
 		metrics.Wrap,
 		proxy.authHandler,
 		proxy.policyHandler,
 	)
-	proxy.mux.Handle("/*", proxy)
+	if cfg.ForwardAuth {
+		slog.Info("router: forward-auth mode, answering authorization requests without proxying")
+		proxy.mux.Handle("/*", http.HandlerFunc(proxy.allowResponse))
+	} else {
+		proxy.mux.Handle("/*", proxy)
+	}
+
+	// checks from the ext_authz gRPC server describe the original request already
+	proxy.checker = chi.Chain(
+		proxy.CleanupHandler,
+		proxy.WrapHandler,
+		metrics.Wrap,
+		proxy.authHandler,
+		proxy.policyHandler,
+	).HandlerFunc(proxy.allowResponse)
 
 	proxy.auth = auth
 
@@ -89,6 +148,30 @@ func New(auth types.AuthProvider, validator types.Validator, cfg *config.Fields)
 	return proxy
 }
 
+// CheckHandler returns the auth and policy chain answering authorization
+// checks: an allowed request gets a 200 with the X-Restrego-* result headers,
+// a denied one the same response as in proxy mode.
+func (proxy *Proxy) CheckHandler() http.Handler {
+	return proxy.checker
+}
+
+// TLSConfig returns the TLS config of the proxy listener, reloading its
+// certificate and client CAs, or nil when it serves plain HTTP
+func (proxy *Proxy) TLSConfig() *tls.Config {
+	if proxy.tls == nil {
+		return nil
+	}
+	return proxy.tls.TLSConfig()
+}
+
+// requestID returns the id of the request, for logging
+func requestID(r *http.Request) string {
+	if info := types.GetInfo(r); info != nil {
+		return info.Request.ID
+	}
+	return ""
+}
+
 // ListenAndServe starts the server (in background)
 func (proxy *Proxy) ListenAndServe() {
 	proxy.server = &http.Server{
@@ -104,9 +187,18 @@ func (proxy *Proxy) ListenAndServe() {
 		// Maximum header size to prevent memory exhaustion
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
+	if proxy.tls != nil {
+		proxy.server.TLSConfig = proxy.tls.TLSConfig()
+	}
 	go func() {
-		slog.Info("router: starting server", "addr", proxy.listenAddr)
-		err := proxy.server.ListenAndServe()
+		var err error
+		if proxy.tls != nil {
+			slog.Info("router: starting tls server", "addr", proxy.listenAddr, "client-ca", proxy.config.TLSClientCAFile)
+			err = proxy.server.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("router: starting server", "addr", proxy.listenAddr)
+			err = proxy.server.ListenAndServe()
+		}
 		if err != nil {
 			if err == http.ErrServerClosed {
 				slog.Info("router: server closed")
@@ -126,40 +218,52 @@ func (proxy *Proxy) Close() {
 		defer cancel()
 		proxy.server.Shutdown(ctx)
 	}
+	if proxy.tls != nil {
+		proxy.tls.Close()
+	}
 }
 
 // ServeHTTP is the main handler
 func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	setResultHeaders(r.Header, types.GetInfo(r))
+	proxy.backend.ServeHTTP(w, r)
+}
 
-	info := types.GetInfo(r)
-	if info != nil {
-		if resultMap, ok := info.Result.(map[string]interface{}); ok {
-			for k, o := range resultMap {
-				var txt string
-				switch v := o.(type) {
-				case string:
-					txt = v
-				case []string:
-					txt = strings.Join(v, ",")
-				case []interface{}:
-					var buf strings.Builder
-					for i, x := range v {
-						if i > 0 {
-							buf.WriteString(",")
-						}
-						buf.WriteString(fmt.Sprint(x))
-					}
-					txt = buf.String()
-				default:
-					txt = fmt.Sprint(v)
-				}
-				if len(txt) > 0 {
-					key := strings.ReplaceAll(k, "_", "-")
-					r.Header.Set(headerPrefix+key, txt)
+// setResultHeaders adds the fields of the policy result as X-Restrego-* headers,
+// except the fields shaping a denial
+func setResultHeaders(h http.Header, info *types.Info) {
+	if info == nil {
+		return
+	}
+	resultMap, ok := info.Result.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, o := range resultMap {
+		if k == denyStatusKey || k == denyHeadersKey || k == denyBodyKey {
+			continue
+		}
+		var txt string
+		switch v := o.(type) {
+		case string:
+			txt = v
+		case []string:
+			txt = strings.Join(v, ",")
+		case []interface{}:
+			var buf strings.Builder
+			for i, x := range v {
+				if i > 0 {
+					buf.WriteString(",")
 				}
+				buf.WriteString(fmt.Sprint(x))
 			}
+			txt = buf.String()
+		default:
+			txt = fmt.Sprint(v)
+		}
+		if len(txt) > 0 {
+			key := strings.ReplaceAll(k, "_", "-")
+			h.Set(headerPrefix+key, txt)
 		}
 	}
-
-	proxy.backend.ServeHTTP(w, r)
 }
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AB-Lindex/rest-rego/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// namedAuthProvider is an anonymous-only provider implementing types.AuthNamer
+type namedAuthProvider struct{ errorAuthProvider }
+
+func (a *namedAuthProvider) Name() string { return "test" }
+
+// withRecorder installs a tracer provider recording all spans for the test
+func withRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		// the globals can't be reset to their defaults, use equivalent no-ops
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return rec
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing_Spans(t *testing.T) {
+	rec := withRecorder(t)
+
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	portNum, _ := strconv.Atoi(port)
+
+	proxy := New(&namedAuthProvider{}, &resultValidator{result: map[string]interface{}{"allow": true}}, &config.Fields{
+		BackendScheme: "http",
+		BackendHost:   host,
+		BackendPort:   portNum,
+		RequestRego:   "request.rego",
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	const incomingTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.Header.Set("Traceparent", "00-"+incomingTrace+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	proxy.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	for _, name := range []string{"GET", "auth test", "policy", "HTTP GET"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected span %q, got %v", name, spans)
+		}
+		if got := s.SpanContext().TraceID().String(); got != incomingTrace {
+			t.Errorf("Span %q: expected trace %s, got %s", name, incomingTrace, got)
+		}
+	}
+
+	server := spans["GET"]
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected server span continuing the incoming trace, got kind %v parent %v", server.SpanKind(), server.Parent().SpanID())
+	}
+	if got := spanAttr(server, "http.response.status_code"); got != "200" {
+		t.Errorf("Expected status 200 on server span, got %q", got)
+	}
+	if got := spanAttr(spans["auth test"], "auth.outcome"); got != "anonymous" {
+		t.Errorf("Expected auth outcome anonymous, got %q", got)
+	}
+	if got := spanAttr(spans["policy"], "rego.decision"); got != "allow" {
+		t.Errorf("Expected decision allow, got %q", got)
+	}
+	if got := spanAttr(spans["policy"], "rego.file"); got != "request.rego" {
+		t.Errorf("Expected policy file request.rego, got %q", got)
+	}
+
+	// the backend sees the client span of rest-rego as its parent
+	client := spans["HTTP GET"]
+	want := "00-" + incomingTrace + "-" + client.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("Expected backend traceparent %q, got %q", want, traceparent)
+	}
+}
+
+func TestTracing_DenyAndError(t *testing.T) {
+	rec := withRecorder(t)
+
+	testCases := []struct {
+		name     string
+		result   map[string]interface{}
+		decision string
+	}{
+		{"deny", map[string]interface{}{"allow": false}, "deny"},
+		{"missing allow", map[string]interface{}{}, "error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec.Reset()
+			runPolicy(t, tc.result)
+
+			var found bool
+			for _, s := range rec.Ended() {
+				if s.Name() == "policy" {
+					found = true
+					if got := spanAttr(s, "rego.decision"); got != tc.decision {
+						t.Errorf("Expected decision %s, got %q", tc.decision, got)
+					}
+				}
+			}
+			if !found {
+				t.Error("Expected a policy span")
+			}
+		})
+	}
+}
+
+func TestTracing_DisabledPassesTraceparent(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	portNum, _ := strconv.Atoi(port)
+
+	proxy := New(&errorAuthProvider{}, &resultValidator{result: map[string]interface{}{"allow": true}}, &config.Fields{
+		BackendScheme: "http",
+		BackendHost:   host,
+		BackendPort:   portNum,
+	})
+	if proxy == nil {
+		t.Fatal("Failed to create proxy")
+	}
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Traceparent", incoming)
+	proxy.mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.EqualFold(traceparent, incoming) {
+		t.Errorf("Expected traceparent passed through unchanged, got %q", traceparent)
+	}
+}
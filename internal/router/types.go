@@ -5,6 +5,7 @@ import (
 	"net/http/httputil"
 
 	"github.com/AB-Lindex/rest-rego/internal/config"
+	"github.com/AB-Lindex/rest-rego/internal/tlsconfig"
 	"github.com/AB-Lindex/rest-rego/internal/types"
 	"github.com/go-chi/chi/v5"
 )
@@ -13,14 +14,18 @@ const headerPrefix = "X-Restrego-"
 
 // Proxy is the main router and proxy-handler
 type Proxy struct {
-	listenAddr  string
-	requestName string
-	mux         *chi.Mux
-	server      *http.Server
-	auth        types.AuthProvider
-	validator   types.Validator
-	backendURL  string
-	backend     *httputil.ReverseProxy
-	authKey     string
-	config      *config.Fields
+	listenAddr   string
+	requestName  string
+	mux          *chi.Mux
+	checker      http.Handler
+	server       *http.Server
+	auth         types.AuthProvider
+	validator    types.Validator
+	backendURL   string
+	backend      *httputil.ReverseProxy
+	authKey      string
+	config       *config.Fields
+	trusted      trustedProxies
+	clientHeader string
+	tls          *tlsconfig.Reloader
 }
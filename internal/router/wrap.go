@@ -6,7 +6,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
 	"github.com/AB-Lindex/rest-rego/internal/types"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
 )
 
 // responseTracker wraps a ResponseWriter to capture status and bytes written
@@ -48,16 +51,35 @@ func (proxy *Proxy) WrapHandler(next http.Handler) http.Handler {
 		now := time.Now()
 		w2 := newResponseTracker(w)
 
+		ctx, span := tracing.StartRequest(r)
+		r = r.WithContext(ctx)
+
+		// the request id is forwarded to the backend and echoed to the client
+		id := proxy.requestID(r)
+		if header := proxy.config.RequestIDHeader; header != "" {
+			r.Header.Set(header, id)
+			w2.Header().Set(header, id)
+		}
+
 		info := types.NewInfo(r, proxy.authKey, proxy.config.URLMetricsLevel)
+		info.Request.ID = id
 		r2 := info.RequestWithInfo(r)
+		span.SetAttributes(semconv.ClientAddress(info.Request.ClientIP), attribute.String("request.id", id))
 
 		next.ServeHTTP(w2, r2)
+		tracing.EndRequest(span, w2.status)
 
-		slog.Info(fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+		attrs := []any{
 			"status", w2.status,
 			"duration", time.Since(now),
 			"size", w2.size,
+			"client", info.Request.ClientIP,
 			"id", info.Request.ID,
-		)
+			"principal", info.Request.Principal,
+		}
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			attrs = append(attrs, "trace", traceID)
+		}
+		slog.Info(fmt.Sprintf("%s %s", r.Method, r.URL.Path), attrs...)
 	})
 }
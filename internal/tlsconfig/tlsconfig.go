@@ -0,0 +1,175 @@
+// Package tlsconfig provides the TLS configuration of the proxy listener,
+// reloading the certificate, key and client CA bundle when the files change.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Config describes the listener certificate and client verification
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string   // verify client certificates against this bundle
+	ClientAuth   string   // "require" or "optional" (only with ClientCAFile)
+	MinVersion   string   // "1.2" or "1.3"
+	CipherSuites []string // names as in crypto/tls, TLS 1.2 only
+}
+
+// Reloader serves the current certificate and client CAs
+type Reloader struct {
+	cfg       Config
+	base      *tls.Config
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+	watcher   *fsnotify.Watcher
+	files     map[string]bool
+}
+
+// New loads the files and starts watching them for changes
+func New(cfg Config) (*Reloader, error) {
+	base, err := baseConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reloader{
+		cfg:   cfg,
+		base:  base,
+		files: make(map[string]bool),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	r.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("tlsconfig: failed to create file watcher, hot-reload disabled", "error", err)
+		return r, nil
+	}
+
+	// watch the directories, so replaced files (e.g. Kubernetes secret
+	// volumes swapping a symlink) are noticed as well
+	dirs := make(map[string]bool)
+	for _, file := range []string{cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		r.files[filepath.Clean(file)] = true
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := r.watcher.Add(dir); err != nil {
+			slog.Warn("tlsconfig: failed to watch directory, hot-reload disabled", "dir", dir, "error", err)
+			r.watcher.Close()
+			r.watcher = nil
+			return r, nil
+		}
+	}
+	go startWatcher(r)
+
+	return r, nil
+}
+
+// TLSConfig returns the config for the listener, always using the latest
+// loaded certificate and client CAs
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := r.base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert.Load()}
+		c.ClientCAs = r.clientCAs.Load()
+		return c, nil
+	}
+	return cfg
+}
+
+// Close stops watching the files
+func (r *Reloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Close()
+}
+
+// load reads the certificate, key and client CAs, replacing the current
+// ones only if all could be loaded
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client ca: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load client ca: no certificates in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	return nil
+}
+
+// baseConfig converts the settings to a tls.Config without certificates
+func baseConfig(cfg Config) (*tls.Config, error) {
+	c := &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	switch cfg.MinVersion {
+	case "", "1.2":
+		c.MinVersion = tls.VersionTLS12
+	case "1.3":
+		c.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported minimum tls version %q", cfg.MinVersion)
+	}
+
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+	if len(c.CipherSuites) > 0 && c.MinVersion == tls.VersionTLS13 {
+		slog.Warn("tlsconfig: cipher suites are ignored with TLS 1.3")
+	}
+
+	if cfg.ClientCAFile != "" {
+		switch cfg.ClientAuth {
+		case "", "require":
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unsupported client auth %q", cfg.ClientAuth)
+		}
+	}
+	return c, nil
+}
+
+// cipherSuite returns the id of a secure cipher suite
+func cipherSuite(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+	return 0, false
+}
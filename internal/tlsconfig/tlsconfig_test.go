@@ -0,0 +1,262 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert creates a certificate signed by parent (self-signed if nil)
+func newCert(t *testing.T, cn string, parent *testCert, isCA bool, uris ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCert(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setup writes a server certificate and client CA, returning the config
+func setup(t *testing.T, ca, server *testCert) Config {
+	dir := t.TempDir()
+	cfg := Config{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	writeFile(t, cfg.CertFile, server.certPEM())
+	writeFile(t, cfg.KeyFile, server.keyPEM(t))
+	writeFile(t, cfg.ClientCAFile, ca.certPEM())
+	return cfg
+}
+
+// startServer serves the peer's verified certificate subject
+func startServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(req.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func client(roots *x509.CertPool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: certs,
+	}}}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCert(t, "ca", nil, true)
+	server := newCert(t, "server", ca, false)
+	workload := newCert(t, "frontend", ca, false, "spiffe://cluster.local/ns/shop/sa/frontend")
+	stranger := newCert(t, "stranger", newCert(t, "other-ca", nil, true), false)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	for _, tc := range []struct {
+		name       string
+		clientAuth string
+		certs      []tls.Certificate
+		wantErr    bool
+		wantCN     string
+	}{
+		{name: "verified client", certs: []tls.Certificate{workload.tlsCert(t)}, wantCN: "frontend"},
+		{name: "missing client certificate", wantErr: true},
+		{name: "untrusted client certificate", certs: []tls.Certificate{stranger.tlsCert(t)}, wantErr: true},
+		{name: "optional without certificate", clientAuth: "optional"},
+		{name: "optional with certificate", clientAuth: "optional", certs: []tls.Certificate{workload.tlsCert(t)}, wantCN: "frontend"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := setup(t, ca, server)
+			cfg.ClientAuth = tc.clientAuth
+			srv := startServer(t, cfg)
+
+			resp, err := client(roots, tc.certs...).Get(srv.URL)
+			if tc.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Expected the handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, 64)
+			n, _ := resp.Body.Read(buf)
+			if got := string(buf[:n]); got != tc.wantCN {
+				t.Errorf("Expected verified client %q, got %q", tc.wantCN, got)
+			}
+		})
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	ca := newCert(t, "ca", nil, true)
+	cfg := setup(t, ca, newCert(t, "server", ca, false))
+	cfg.ClientCAFile = ""
+	cfg.MinVersion = "1.3"
+	srv := startServer(t, cfg)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		MaxVersion: tls.VersionTLS12,
+	}}}
+	if resp, err := c.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected a TLS 1.2 client to be rejected")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca := newCert(t, "ca", nil, true)
+	cfg := setup(t, ca, newCert(t, "server-1", ca, false))
+	cfg.ClientCAFile = ""
+	srv := startServer(t, cfg)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	servedCN := func() string {
+		c := client(roots)
+		c.Transport.(*http.Transport).DisableKeepAlives = true
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if cn := servedCN(); cn != "server-1" {
+		t.Fatalf("Expected server-1, got %s", cn)
+	}
+
+	// an invalid key keeps the current certificate
+	writeFile(t, cfg.KeyFile, []byte("garbage"))
+	time.Sleep(100 * time.Millisecond)
+	if cn := servedCN(); cn != "server-1" {
+		t.Fatalf("Expected last valid certificate, got %s", cn)
+	}
+
+	next := newCert(t, "server-2", ca, false)
+	writeFile(t, cfg.CertFile, next.certPEM())
+	writeFile(t, cfg.KeyFile, next.keyPEM(t))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCN() != "server-2" {
+		if time.Now().After(deadline) {
+			t.Fatal("Certificate was not reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	ca := newCert(t, "ca", nil, true)
+	valid := setup(t, ca, newCert(t, "server", ca, false))
+
+	for name, modify := range map[string]func(*Config){
+		"min version":  func(c *Config) { c.MinVersion = "1.1" },
+		"cipher suite": func(c *Config) { c.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"} },
+		"client auth":  func(c *Config) { c.ClientAuth = "sometimes" },
+		"missing cert": func(c *Config) { c.CertFile = filepath.Join(t.TempDir(), "missing.crt") },
+		"client ca":    func(c *Config) { c.ClientCAFile = valid.KeyFile },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			modify(&cfg)
+			if r, err := New(cfg); err == nil {
+				r.Close()
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tlsconfig
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// startWatcher reloads the files on changes in their directories.
+// On a load error the current certificate and CAs are retained unchanged.
+// This function is intended to run in its own goroutine.
+func startWatcher(r *Reloader) {
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) || !r.relevant(event.Name) {
+				continue
+			}
+			if err := r.load(); err != nil {
+				slog.Error("tlsconfig: failed to reload certificates, retaining last valid set",
+					"file", event.Name, "error", err)
+				continue
+			}
+			slog.Info("tlsconfig: reloaded certificates", "file", event.Name)
+
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("tlsconfig: file watcher error", "error", err)
+		}
+	}
+}
+
+// relevant reports if a changed file may affect the loaded files: one of
+// them, or the "..data" link of a Kubernetes secret volume
+func (r *Reloader) relevant(name string) bool {
+	return r.files[filepath.Clean(name)] || strings.HasPrefix(filepath.Base(name), "..")
+}
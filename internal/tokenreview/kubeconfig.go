@@ -0,0 +1,225 @@
+package tokenreview
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service-account credentials
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// tokenRefresh is how often a token file is re-read, projected tokens are rotated by the kubelet
+const tokenRefresh = time.Minute
+
+// restConfig is how to reach the Kubernetes API server
+type restConfig struct {
+	host      string
+	tlsConfig *tls.Config
+	token     *fileToken // nil with a static token or client certificates
+	static    string
+}
+
+// bearer returns the token authenticating rest-rego to the API server, if any
+func (c *restConfig) bearer() (string, error) {
+	if c.token != nil {
+		return c.token.get()
+	}
+	return c.static, nil
+}
+
+// fileToken is a token read from a file, refreshed periodically
+type fileToken struct {
+	path   string
+	mu     sync.Mutex
+	token  string
+	readAt time.Time
+}
+
+func (t *fileToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Since(t.readAt) < tokenRefresh {
+		return t.token, nil
+	}
+	b, err := os.ReadFile(t.path) // #nosec G304 — path comes from operator-controlled config
+	if err != nil {
+		if t.token != "" {
+			return t.token, nil // keep using the last token, it may still be valid
+		}
+		return "", fmt.Errorf("cannot read token file: %w", err)
+	}
+	t.token = strings.TrimSpace(string(b))
+	t.readAt = time.Now()
+	return t.token, nil
+}
+
+// loadConfig reads a kubeconfig, or the in-cluster config if the path is empty
+func loadConfig(kubeconfig string) (*restConfig, error) {
+	if kubeconfig != "" {
+		return loadKubeconfig(kubeconfig)
+	}
+	return inClusterConfig(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"), serviceAccountDir)
+}
+
+// inClusterConfig uses the pod's service account to reach the API server
+func inClusterConfig(host, port, dir string) (*restConfig, error) {
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster (KUBERNETES_SERVICE_HOST/PORT not set), set a kubeconfig")
+	}
+	pool, err := loadCAs(filepath.Join(dir, "ca.crt"), nil)
+	if err != nil {
+		return nil, err
+	}
+	token := &fileToken{path: filepath.Join(dir, "token")}
+	if _, err := token.get(); err != nil {
+		return nil, err
+	}
+	return &restConfig{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tlsConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		token:     token,
+	}, nil
+}
+
+// kubeconfig is the subset of the kubectl config file format that is supported
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Contexts       []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Clusters []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData []byte `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+			TLSServerName            string `json:"tls-server-name"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string      `json:"token"`
+			TokenFile             string      `json:"tokenFile"`
+			ClientCertificate     string      `json:"client-certificate"`
+			ClientKey             string      `json:"client-key"`
+			ClientCertificateData []byte      `json:"client-certificate-data"`
+			ClientKeyData         []byte      `json:"client-key-data"`
+			Exec                  interface{} `json:"exec"`
+			AuthProvider          interface{} `json:"auth-provider"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// loadKubeconfig reads the current context of a kubeconfig file. Relative
+// paths in the file are relative to its directory. Exec and auth-provider
+// plugins are not supported.
+func loadKubeconfig(path string) (*restConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 — path comes from operator-controlled config
+	if err != nil {
+		return nil, fmt.Errorf("cannot read kubeconfig %q: %w", path, err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %q: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("kubeconfig %q: current-context %q not found", path, kc.CurrentContext)
+	}
+
+	cfg := &restConfig{tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12}}
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		cfg.host = strings.TrimSuffix(c.Cluster.Server, "/")
+		cfg.tlsConfig.ServerName = c.Cluster.TLSServerName
+		cfg.tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify // #nosec G402 — explicitly configured in the kubeconfig
+		if c.Cluster.CertificateAuthority != "" || len(c.Cluster.CertificateAuthorityData) > 0 {
+			if cfg.tlsConfig.RootCAs, err = loadCAs(resolve(c.Cluster.CertificateAuthority), c.Cluster.CertificateAuthorityData); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.host == "" {
+		return nil, fmt.Errorf("kubeconfig %q: cluster %q not found or without server", path, clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("kubeconfig %q: exec and auth-provider credentials are not supported, use a token or client certificate", path)
+		}
+		cfg.static = u.User.Token
+		if u.User.TokenFile != "" {
+			cfg.token = &fileToken{path: resolve(u.User.TokenFile)}
+		}
+
+		certPEM, keyPEM := u.User.ClientCertificateData, u.User.ClientKeyData
+		if u.User.ClientCertificate != "" {
+			if certPEM, err = os.ReadFile(resolve(u.User.ClientCertificate)); err != nil {
+				return nil, fmt.Errorf("cannot read client certificate: %w", err)
+			}
+		}
+		if u.User.ClientKey != "" {
+			if keyPEM, err = os.ReadFile(resolve(u.User.ClientKey)); err != nil {
+				return nil, fmt.Errorf("cannot read client key: %w", err)
+			}
+		}
+		if len(certPEM) > 0 {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate: %w", err)
+			}
+			cfg.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return cfg, nil
+}
+
+// loadCAs returns a pool of the certificates in a PEM file or data
+func loadCAs(file string, data []byte) (*x509.CertPool, error) {
+	if file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil { // #nosec G304 — path comes from operator-controlled config
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no CA certificates found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,287 @@
+package tokenreview
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/metrics"
+	"github.com/AB-Lindex/rest-rego/internal/tracing"
+	"github.com/AB-Lindex/rest-rego/internal/types"
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// maxResponseSize limits the TokenReview response that is read
+const maxResponseSize = 1 << 20
+
+// serviceAccountPrefix starts the user name of service-account tokens
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Config describes how tokens are reviewed
+type Config struct {
+	Kubeconfig  string // empty for the in-cluster config
+	AuthHeader  string
+	Audiences   []string      // if set, the token must be issued for one of these
+	CacheTTL    time.Duration // max time a positive review is cached (never beyond the token's exp)
+	NegativeTTL time.Duration // time a negative review is cached
+	Timeout     time.Duration
+	Permissive  bool
+}
+
+// User is the identity of a reviewed token, available to policies as input.user
+type User struct {
+	Username       string              `json:"username"`
+	UID            string              `json:"uid,omitempty"`
+	Groups         []string            `json:"groups"`
+	Extra          map[string][]string `json:"extra,omitempty"`
+	Namespace      string              `json:"namespace,omitempty"`
+	ServiceAccount string              `json:"service_account,omitempty"`
+}
+
+// entry is a cached review, user is nil for unauthenticated tokens
+type entry struct {
+	digest [sha256.Size]byte // of the token, to rule out cache key collisions
+	user   *User
+}
+
+// tokenReview is an authentication.k8s.io/v1 TokenReview
+type tokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string              `json:"username"`
+			UID      string              `json:"uid"`
+			Groups   []string            `json:"groups"`
+			Extra    map[string][]string `json:"extra"`
+		} `json:"user"`
+		Audiences []string `json:"audiences"`
+		Error     string   `json:"error"`
+	} `json:"status"`
+}
+
+// TokenReviewProvider authenticates Kubernetes service-account tokens by
+// asking the API server's TokenReview API.
+type TokenReviewProvider struct {
+	cfg    Config
+	api    *restConfig
+	client *http.Client
+	cache  *ristretto.Cache[uint64, *entry]
+	seed   maphash.Seed
+}
+
+// New creates a TokenReviewProvider.
+// Returns nil if the API server configuration cannot be loaded.
+func New(cfg Config) *TokenReviewProvider {
+	api, err := loadConfig(cfg.Kubeconfig)
+	if err != nil {
+		slog.Error("tokenreview: invalid kubernetes configuration", "error", err)
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = api.tlsConfig
+	p := &TokenReviewProvider{
+		cfg: cfg,
+		api: api,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(transport),
+		},
+		seed: maphash.MakeSeed(),
+	}
+
+	cache, err := ristretto.NewCache(&ristretto.Config[uint64, *entry]{
+		NumCounters: 100000, // number of keys to track frequency of.
+		MaxCost:     10000,  // maximum cost of cache (no-of-entries since we use cost=1).
+		BufferItems: 64,     // number of keys per Get buffer.
+	})
+	if err != nil {
+		slog.Warn("tokenreview: failed to create cache, every request will be reviewed", "error", err)
+	}
+	p.cache = cache
+
+	slog.Info("tokenreview: creating auth provider", "server", api.host, "audiences", cfg.Audiences)
+	return p
+}
+
+// Authenticate implements types.AuthProvider.
+// No bearer token → anonymous.
+// Token not authenticated or for another audience → ErrAuthenticationFailed (anonymous in permissive mode).
+// API server failure → ErrAuthenticationUnavailable, even in permissive mode.
+func (p *TokenReviewProvider) Authenticate(info *types.Info, r *http.Request) error {
+	token, ok := info.GetBearerToken(r, p.cfg.AuthHeader)
+	if len(token) == 0 || !ok {
+		slog.Debug("tokenreview: no bearer token, treating as anonymous")
+		return nil
+	}
+
+	e, err := p.lookup(r.Context(), string(token))
+	if err != nil {
+		slog.Error("tokenreview: api server unavailable", "server", p.api.host, "error", err)
+		return types.ErrAuthenticationUnavailable
+	}
+	if e.user == nil {
+		return p.reject("unauthenticated token")
+	}
+
+	info.User = e.user
+	info.Request.Principal = e.user.Username
+	slog.Debug("tokenreview: authentication successful", "principal", info.Request.Principal)
+	return nil
+}
+
+// lookup returns the cached review of a token, or reviews it
+func (p *TokenReviewProvider) lookup(ctx context.Context, token string) (*entry, error) {
+	key := maphash.String(p.seed, token)
+	digest := sha256.Sum256([]byte(token))
+	if p.cache != nil {
+		e, found := p.cache.Get(key)
+		// another token with the same cache key is a miss
+		found = found && e.digest == digest
+		metrics.IncrementTokenReviewCache(found)
+		if found {
+			return e, nil
+		}
+	}
+
+	user, err := p.review(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{digest: digest, user: user}
+	ttl := p.cfg.NegativeTTL
+	if user != nil {
+		ttl = p.cfg.CacheTTL
+		if exp, ok := expiry(token); ok {
+			ttl = min(ttl, time.Until(exp))
+		}
+	}
+	if p.cache != nil && ttl > 0 {
+		p.cache.SetWithTTL(key, e, 1, ttl)
+	}
+	return e, nil
+}
+
+// review posts a TokenReview, returning the user when the token is
+// authenticated for one of the audiences, nil otherwise
+func (p *TokenReviewProvider) review(ctx context.Context, token string) (*User, error) {
+	tr := tokenReview{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"}
+	tr.Spec.Token = token
+	tr.Spec.Audiences = p.cfg.Audiences
+	body, err := json.Marshal(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.api.host+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	bearer, err := p.api.bearer()
+	if err != nil {
+		return nil, err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("unexpected status %d, rest-rego needs the system:auth-delegator cluster role", resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var result tokenReview
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	status := result.Status
+	if !status.Authenticated {
+		slog.Debug("tokenreview: token not authenticated", "error", status.Error)
+		return nil, nil
+	}
+	// the API server only fills in the audiences it accepted, check them as clients must
+	if len(p.cfg.Audiences) > 0 && !slices.ContainsFunc(status.Audiences, func(aud string) bool {
+		return slices.Contains(p.cfg.Audiences, aud)
+	}) {
+		slog.Debug("tokenreview: audience mismatch", "expected", p.cfg.Audiences, "got", status.Audiences)
+		return nil, nil
+	}
+
+	user := &User{
+		Username: status.User.Username,
+		UID:      status.User.UID,
+		Groups:   status.User.Groups,
+		Extra:    status.User.Extra,
+	}
+	if user.Groups == nil {
+		user.Groups = []string{}
+	}
+	if sa, ok := strings.CutPrefix(user.Username, serviceAccountPrefix); ok {
+		user.Namespace, user.ServiceAccount, _ = strings.Cut(sa, ":")
+	}
+	return user, nil
+}
+
+// reject returns ErrAuthenticationFailed in strict mode, or nil (anonymous) in permissive mode.
+func (p *TokenReviewProvider) reject(reason string) error {
+	if !p.cfg.Permissive {
+		slog.Warn("tokenreview: token rejected", "reason", reason)
+		return types.ErrAuthenticationFailed
+	}
+	slog.Debug(fmt.Sprintf("tokenreview: treating %s as anonymous (permissive mode)", reason))
+	return nil
+}
+
+// Name implements the optional types.AuthNamer interface.
+func (p *TokenReviewProvider) Name() string {
+	return "kubernetes"
+}
+
+// expiry returns the "exp" claim of a JWT without verifying it, the API
+// server has done that. Legacy service-account tokens have no expiry.
+func expiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,306 @@
+package tokenreview
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AB-Lindex/rest-rego/internal/types"
+)
+
+// --- test fixtures ---
+
+const apiToken = "rest-rego-sa-token"
+
+// fakeAPIServer answers TokenReviews for the tokens it knows
+type fakeAPIServer struct {
+	*httptest.Server
+	calls           atomic.Int32
+	status          int
+	ignoreAudiences bool // answer like for a token issued for another audience
+}
+
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	t.Helper()
+	f := &fakeAPIServer{status: http.StatusCreated}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authentication.k8s.io/v1/tokenreviews" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+apiToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f.status != http.StatusCreated {
+			w.WriteHeader(f.status)
+			return
+		}
+
+		var tr tokenReview
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil || tr.Kind != "TokenReview" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{"apiVersion": "authentication.k8s.io/v1", "kind": "TokenReview"}
+		switch {
+		case strings.HasPrefix(tr.Spec.Token, "frontend"):
+			resp["status"] = map[string]interface{}{
+				"authenticated": true,
+				"user": map[string]interface{}{
+					"username": "system:serviceaccount:shop:frontend",
+					"uid":      "4a1e0a58-1b7e-4a7f-9b0f-3d4c2e1f0a9b",
+					"groups":   []string{"system:serviceaccounts", "system:serviceaccounts:shop"},
+					"extra":    map[string][]string{"authentication.kubernetes.io/pod-name": {"frontend-7d9f"}},
+				},
+				"audiences": tr.Spec.Audiences,
+			}
+			if f.ignoreAudiences {
+				resp["status"].(map[string]interface{})["audiences"] = []string{}
+			}
+		default:
+			resp["status"] = map[string]interface{}{"authenticated": false, "error": "invalid bearer token"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// kubeconfig writes a kubeconfig for the fake API server
+func (f *fakeAPIServer) kubeconfig(t *testing.T) string {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+  - name: test
+    context: {cluster: fake, user: rest-rego}
+clusters:
+  - name: fake
+    cluster:
+      server: %s
+      certificate-authority-data: %s
+users:
+  - name: rest-rego
+    user:
+      token: %s
+`, f.URL, base64.StdEncoding.EncodeToString(ca), apiToken)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestProvider(t *testing.T, f *fakeAPIServer, audiences []string, permissive bool) *TokenReviewProvider {
+	t.Helper()
+	p := New(Config{
+		Kubeconfig:  f.kubeconfig(t),
+		AuthHeader:  "Authorization",
+		Audiences:   audiences,
+		CacheTTL:    time.Minute,
+		NegativeTTL: time.Minute,
+		Timeout:     time.Second,
+		Permissive:  permissive,
+	})
+	if p == nil {
+		t.Fatal("Failed to create provider")
+	}
+	return p
+}
+
+func bearerRequest(token string) (*types.Info, *http.Request) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return types.NewInfo(r, "Authorization", 0), r
+}
+
+// jwt returns an unsigned token with the given expiry, enough for expiry()
+func jwt(exp time.Time) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		enc.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".sig"
+}
+
+// --- Authenticate tests ---
+
+func TestAuthenticate_ServiceAccount(t *testing.T) {
+	f := newFakeAPIServer(t)
+	p := newTestProvider(t, f, nil, false)
+
+	info, r := bearerRequest("frontend-token")
+	if err := p.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	user, ok := info.User.(*User)
+	if !ok {
+		t.Fatalf("Expected a user, got %T", info.User)
+	}
+	if user.Namespace != "shop" || user.ServiceAccount != "frontend" || user.UID == "" || len(user.Groups) != 2 {
+		t.Errorf("Unexpected user %+v", user)
+	}
+	if user.Extra["authentication.kubernetes.io/pod-name"][0] != "frontend-7d9f" {
+		t.Errorf("Expected extra fields, got %v", user.Extra)
+	}
+	if info.Request.Principal != "system:serviceaccount:shop:frontend" {
+		t.Errorf("Unexpected principal %q", info.Request.Principal)
+	}
+}
+
+func TestAuthenticate_Cached(t *testing.T) {
+	f := newFakeAPIServer(t)
+	p := newTestProvider(t, f, nil, false)
+
+	for range 3 {
+		info, r := bearerRequest("frontend-token")
+		if err := p.Authenticate(info, r); err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		p.cache.Wait()
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Errorf("Expected one review, got %d", n)
+	}
+}
+
+func TestLookup_CacheKeyCollision(t *testing.T) {
+	f := newFakeAPIServer(t)
+	p := newTestProvider(t, f, nil, false)
+
+	info, r := bearerRequest("frontend-token")
+	if err := p.Authenticate(info, r); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	p.cache.Wait()
+	e, found := p.cache.Get(maphash.String(p.seed, "frontend-token"))
+	if !found {
+		t.Fatal("Expected the review to be cached")
+	}
+
+	// store the frontend's review under another token's cache key, as a collision would
+	p.cache.Set(maphash.String(p.seed, "forged-token"), e, 1)
+	p.cache.Wait()
+	info, r = bearerRequest("forged-token")
+	if err := p.Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected the other token to be reviewed and rejected, got %v", err)
+	}
+}
+
+func TestAuthenticate_NoToken_Anonymous(t *testing.T) {
+	f := newFakeAPIServer(t)
+	info, r := bearerRequest("")
+	if err := newTestProvider(t, f, nil, false).Authenticate(info, r); err != nil || info.User != nil {
+		t.Errorf("Expected anonymous, got %v %v", info.User, err)
+	}
+	if f.calls.Load() != 0 {
+		t.Error("Expected no review without a token")
+	}
+}
+
+func TestAuthenticate_Rejected(t *testing.T) {
+	f := newFakeAPIServer(t)
+
+	info, r := bearerRequest("stolen-token")
+	if err := newTestProvider(t, f, nil, false).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+
+	info, r = bearerRequest("stolen-token")
+	if err := newTestProvider(t, f, nil, true).Authenticate(info, r); err != nil || info.User != nil {
+		t.Errorf("Expected anonymous in permissive mode, got %v %v", info.User, err)
+	}
+}
+
+func TestAuthenticate_Audience(t *testing.T) {
+	f := newFakeAPIServer(t)
+
+	info, r := bearerRequest("frontend-token")
+	if err := newTestProvider(t, f, []string{"rest-rego"}, false).Authenticate(info, r); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+
+	// the API server returns the audiences it accepted, none if the token is for another
+	f.ignoreAudiences = true
+	info, r = bearerRequest("frontend-token")
+	if err := newTestProvider(t, f, []string{"rest-rego"}, false).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed for a token without the audience, got %v", err)
+	}
+}
+
+func TestAuthenticate_Unavailable(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusInternalServerError} {
+		f := newFakeAPIServer(t)
+		f.status = status
+		info, r := bearerRequest("frontend-token")
+		// fails closed, also in permissive mode
+		if err := newTestProvider(t, f, nil, true).Authenticate(info, r); !errors.Is(err, types.ErrAuthenticationUnavailable) {
+			t.Errorf("%d: expected ErrAuthenticationUnavailable, got %v", status, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	if got, ok := expiry(jwt(exp)); !ok || !got.Equal(exp) {
+		t.Errorf("Expected %v, got %v %v", exp, got, ok)
+	}
+	if _, ok := expiry("opaque-legacy-token"); ok {
+		t.Error("Expected no expiry for a non-JWT token")
+	}
+}
+
+// --- config tests ---
+
+func TestInClusterConfig(t *testing.T) {
+	f := newFakeAPIServer(t)
+	dir := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw})
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0600)
+	os.WriteFile(filepath.Join(dir, "token"), []byte(apiToken+"\n"), 0600)
+
+	cfg, err := inClusterConfig("10.96.0.1", "443", dir)
+	if err != nil {
+		t.Fatalf("Expected config, got %v", err)
+	}
+	if cfg.host != "https://10.96.0.1:443" {
+		t.Errorf("Unexpected host %q", cfg.host)
+	}
+	if token, _ := cfg.bearer(); token != apiToken {
+		t.Errorf("Expected the token from the file, got %q", token)
+	}
+
+	if _, err := inClusterConfig("", "", dir); err == nil {
+		t.Error("Expected an error outside a cluster")
+	}
+}
+
+func TestLoadKubeconfig_ExecUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	os.WriteFile(path, []byte(`
+current-context: eks
+contexts: [{name: eks, context: {cluster: eks, user: eks}}]
+clusters: [{name: eks, cluster: {server: "https://eks.example.com"}}]
+users: [{name: eks, user: {exec: {command: aws}}}]
+`), 0600)
+	if _, err := loadKubeconfig(path); err == nil || !strings.Contains(err.Error(), "exec") {
+		t.Errorf("Expected exec credentials to be rejected, got %v", err)
+	}
+}
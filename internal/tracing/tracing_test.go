@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetup_InvalidConfig(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  Config
+	}{
+		{"endpoint without host", Config{Endpoint: "otel-collector:4318"}},
+		{"unknown protocol", Config{Endpoint: "http://otel-collector:4318", Protocol: "thrift"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Setup(context.Background(), tc.cfg); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"context"
 	"encoding/base64"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -11,6 +12,9 @@ type ctxKey int
 
 const ctxInfoKey ctxKey = 0
 const CtxBlockedHeadersKey ctxKey = 1
+const CtxClientIPKey ctxKey = 2
+const CtxSchemeKey ctxKey = 3
+const CtxSourceKey ctxKey = 4
 
 // Info is the request information
 type Info struct {
@@ -31,6 +35,23 @@ type RequestInfo struct {
 	Auth           *RequestAuth           `json:"auth"`
 	Size           int64                  `json:"size"`
 	ID             string                 `json:"id,omitempty"`
+	Principal      string                 `json:"principal,omitempty"`
+	Query          map[string][]string    `json:"query"`
+	RawQuery       string                 `json:"raw_query"`
+	Host           string                 `json:"host"`
+	Scheme         string                 `json:"scheme"`
+	RemoteAddr     string                 `json:"remote_addr"`
+	ClientIP       string                 `json:"client_ip"`
+	Protocol       string                 `json:"protocol"`
+	Source         *RequestSource         `json:"source,omitempty"`
+	TLS            *RequestTLS            `json:"tls,omitempty"`
+}
+
+// RequestSource is the peer that sent the request, as reported by Envoy
+type RequestSource struct {
+	Principal string            `json:"principal,omitempty"` // e.g. a SPIFFE id from mTLS
+	Service   string            `json:"service,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
 }
 
 type RequestAuth struct {
@@ -38,6 +59,7 @@ type RequestAuth struct {
 	Token    string `json:"token,omitempty"`
 	User     string `json:"user,omitempty"`
 	Password string `json:"password,omitempty"`
+	Provider string `json:"provider,omitempty"` // provider that authenticated the request
 }
 
 // type JWTInfo struct {
@@ -53,6 +75,19 @@ func GetBlockedHeaders(r *http.Request) map[string]interface{} {
 	return nil
 }
 
+// GetClientIP retrieves the client IP resolved from trusted proxy headers
+func GetClientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(CtxClientIPKey).(string)
+	return ip
+}
+
+// GetScheme retrieves the scheme of the original request when it was
+// reconstructed from forwarding headers
+func GetScheme(r *http.Request) string {
+	scheme, _ := r.Context().Value(CtxSchemeKey).(string)
+	return scheme
+}
+
 // TruncateURLForMetrics returns a truncated form of the request URL for use as a Prometheus label.
 // level < 0: full path (original r.URL.Path)
 // level == 0: suppress path detail, return "/"
@@ -73,6 +108,24 @@ func NewInfo(r *http.Request, authKey string, urlMetricsLevel int) *Info {
 	i.Request.Method = r.Method
 	i.Request.Path = strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
 	i.Request.Size = r.ContentLength
+	i.Request.Query = r.URL.Query()
+	i.Request.RawQuery = r.URL.RawQuery
+	i.Request.Host = r.Host
+	i.Request.Scheme = "http"
+	if r.TLS != nil {
+		i.Request.Scheme = "https"
+	}
+	if scheme := GetScheme(r); scheme != "" {
+		i.Request.Scheme = scheme
+	}
+	i.Request.Source, _ = r.Context().Value(CtxSourceKey).(*RequestSource)
+	i.Request.TLS = newRequestTLS(r.TLS)
+	i.Request.RemoteAddr = remoteIP(r.RemoteAddr)
+	i.Request.ClientIP = GetClientIP(r)
+	if i.Request.ClientIP == "" {
+		i.Request.ClientIP = i.Request.RemoteAddr
+	}
+	i.Request.Protocol = r.Proto
 	i.URL = TruncateURLForMetrics(r.URL.Path, i.Request.Path, urlMetricsLevel)
 
 	i.Request.Headers = make(map[string]interface{})
@@ -115,6 +168,20 @@ func NewInfo(r *http.Request, authKey string, urlMetricsLevel int) *Info {
 	return i
 }
 
+// RequestID returns the id of the request, so decision logs can be
+// correlated with request logs
+func (info *Info) RequestID() string {
+	return info.Request.ID
+}
+
+// remoteIP strips the port from a RemoteAddr, so policies can match on the IP
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
 // RequestWithInfo adds the Info to the request context
 func (info *Info) RequestWithInfo(r *http.Request) *http.Request {
 	ctx := context.WithValue(r.Context(), ctxInfoKey, info)
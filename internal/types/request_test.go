@@ -2,12 +2,21 @@ package types
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewInfo(t *testing.T) {
@@ -752,6 +761,130 @@ func TestNewInfo_EdgeCases(t *testing.T) {
 	})
 }
 
+func TestNewInfo_RequestDetails(t *testing.T) {
+	t.Run("Plain HTTP request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://api.example.com:8181/orders?include=secrets&tag=a&tag=b", nil)
+		req.RemoteAddr = "10.1.2.3:54321"
+
+		info := NewInfo(req, "Authorization", 0)
+
+		expectedQuery := map[string][]string{"include": {"secrets"}, "tag": {"a", "b"}}
+		if !reflect.DeepEqual(info.Request.Query, expectedQuery) {
+			t.Errorf("Expected query %v, got %v", expectedQuery, info.Request.Query)
+		}
+		if info.Request.RawQuery != "include=secrets&tag=a&tag=b" {
+			t.Errorf("Expected raw query, got %q", info.Request.RawQuery)
+		}
+		if info.Request.Host != "api.example.com:8181" {
+			t.Errorf("Expected host api.example.com:8181, got %q", info.Request.Host)
+		}
+		if info.Request.Scheme != "http" {
+			t.Errorf("Expected scheme http, got %q", info.Request.Scheme)
+		}
+		if info.Request.RemoteAddr != "10.1.2.3" {
+			t.Errorf("Expected remote addr 10.1.2.3, got %q", info.Request.RemoteAddr)
+		}
+		if info.Request.Protocol != "HTTP/1.1" {
+			t.Errorf("Expected protocol HTTP/1.1, got %q", info.Request.Protocol)
+		}
+	})
+
+	t.Run("TLS request and IPv6 client", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://api.example.com/", nil)
+		req.RemoteAddr = "[2001:db8::1]:443"
+
+		info := NewInfo(req, "Authorization", 0)
+
+		if info.Request.Scheme != "https" {
+			t.Errorf("Expected scheme https, got %q", info.Request.Scheme)
+		}
+		if info.Request.RemoteAddr != "2001:db8::1" {
+			t.Errorf("Expected remote addr 2001:db8::1, got %q", info.Request.RemoteAddr)
+		}
+		if info.Request.Query == nil {
+			t.Error("Expected empty query map, got nil")
+		}
+	})
+
+	t.Run("JSON field names", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/x?a=1", nil)
+		buf, err := json.Marshal(NewInfo(req, "Authorization", 0).Request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{`"query":{"a":["1"]}`, `"raw_query":"a=1"`, `"host":`, `"scheme":"http"`, `"remote_addr":`, `"protocol":"HTTP/1.1"`} {
+			if !contains(string(buf), field) {
+				t.Errorf("Expected %s in %s", field, buf)
+			}
+		}
+	})
+}
+
+func TestNewInfo_TLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/shop/sa/frontend")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(4711),
+		Subject:      pkix.Name{CommonName: "frontend", Organization: []string{"Shop"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"frontend.shop.svc"},
+		URIs:         []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	t.Run("Without TLS", func(t *testing.T) {
+		info := NewInfo(httptest.NewRequest("GET", "/", nil), "Authorization", 0)
+		if info.Request.TLS != nil {
+			t.Errorf("Expected no tls details, got %+v", info.Request.TLS)
+		}
+	})
+
+	t.Run("Unverified client certificate is not exposed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://api.example.com/", nil)
+		req.TLS.CipherSuite = tls.TLS_AES_128_GCM_SHA256
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+
+		info := NewInfo(req, "Authorization", 0)
+		if info.Request.TLS == nil || info.Request.TLS.Version != "TLS 1.2" || info.Request.TLS.CipherSuite != "TLS_AES_128_GCM_SHA256" {
+			t.Fatalf("Unexpected tls details %+v", info.Request.TLS)
+		}
+		if info.Request.TLS.Client != nil {
+			t.Errorf("Expected no client details without verification, got %+v", info.Request.TLS.Client)
+		}
+	})
+
+	t.Run("Verified client certificate", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://api.example.com/", nil)
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+
+		c := NewInfo(req, "Authorization", 0).Request.TLS.Client
+		if c == nil {
+			t.Fatal("Expected client details")
+		}
+		if c.SPIFFEID != "spiffe://cluster.local/ns/shop/sa/frontend" {
+			t.Errorf("Expected spiffe id, got %q", c.SPIFFEID)
+		}
+		if c.Subject != "CN=frontend,O=Shop" || c.Serial != "4711" {
+			t.Errorf("Unexpected subject/serial %q %q", c.Subject, c.Serial)
+		}
+		if !reflect.DeepEqual(c.DNSNames, []string{"frontend.shop.svc"}) {
+			t.Errorf("Unexpected dns names %v", c.DNSNames)
+		}
+		if len(c.Fingerprint) != 64 {
+			t.Errorf("Expected SHA-256 fingerprint, got %q", c.Fingerprint)
+		}
+	})
+}
+
 func TestNewInfo_BlockedHeaders(t *testing.T) {
 	testCases := []struct {
 		name                   string
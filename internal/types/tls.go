@@ -0,0 +1,73 @@
+package types
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
+// RequestTLS describes the TLS connection the request arrived on
+type RequestTLS struct {
+	Version     string      `json:"version"`
+	CipherSuite string      `json:"cipher_suite"`
+	ServerName  string      `json:"server_name,omitempty"`
+	Client      *ClientCert `json:"client,omitempty"` // only if the client certificate was verified
+}
+
+// ClientCert is the identity in a verified client certificate
+type ClientCert struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	SPIFFEID    string    `json:"spiffe_id,omitempty"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	URIs        []string  `json:"uris,omitempty"`
+	Emails      []string  `json:"emails,omitempty"`
+	IPs         []string  `json:"ips,omitempty"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the DER certificate, hex
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// newRequestTLS returns the TLS details of a connection, nil without TLS
+func newRequestTLS(cs *tls.ConnectionState) *RequestTLS {
+	if cs == nil {
+		return nil
+	}
+	t := &RequestTLS{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:  cs.ServerName,
+	}
+	if len(cs.VerifiedChains) > 0 && len(cs.VerifiedChains[0]) > 0 {
+		t.Client = NewClientCert(cs.VerifiedChains[0][0])
+	}
+	return t
+}
+
+// NewClientCert extracts the identity of a certificate
+func NewClientCert(cert *x509.Certificate) *ClientCert {
+	sum := sha256.Sum256(cert.Raw)
+	c := &ClientCert{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.String(),
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+		if u.Scheme == "spiffe" && c.SPIFFEID == "" {
+			c.SPIFFEID = u.String()
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPs = append(c.IPs, ip.String())
+	}
+	return c
+}
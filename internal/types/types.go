@@ -1,6 +1,9 @@
 package types
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // AuthProvider is the interface for the authentication provider
 type AuthProvider interface {
@@ -17,3 +20,15 @@ type Validator interface {
 type AuthChallenger interface {
 	WWWAuthenticate() string
 }
+
+// AuthNamer is optionally implemented by AuthProviders to name themselves
+// in traces and metrics (e.g. "jwt", "basic").
+type AuthNamer interface {
+	Name() string
+}
+
+// ContextValidator is optionally implemented by Validators that can use the
+// request context, e.g. to trace the evaluation.
+type ContextValidator interface {
+	ValidateContext(ctx context.Context, name string, input interface{}) (interface{}, error)
+}
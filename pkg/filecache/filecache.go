@@ -24,7 +24,7 @@ type File struct {
 
 type Cache struct {
 	folder    string
-	pattern   string
+	patterns  []string
 	files     map[string]*File
 	mtx       sync.Mutex
 	watcher   *fsnotify.Watcher
@@ -32,7 +32,8 @@ type Cache struct {
 	queue     *delayedCallbacks
 }
 
-func New(folder, pattern string) (*Cache, error) {
+// New creates a cache for the files in folder matching any of the patterns.
+func New(folder string, patterns ...string) (*Cache, error) {
 	stat, err := os.Lstat(folder)
 	if err != nil {
 		return nil, err
@@ -50,9 +51,9 @@ func New(folder, pattern string) (*Cache, error) {
 	}
 
 	c := &Cache{
-		folder:  folder,
-		pattern: pattern,
-		watcher: w,
+		folder:   folder,
+		patterns: patterns,
+		watcher:  w,
 	}
 
 	c.queue = newDelayedCallbacks(callbackDelay, c.doCallbacks)
@@ -63,21 +64,34 @@ func (c *Cache) AddCallback(fn func(string)) {
 	c.callbacks = append(c.callbacks, fn)
 }
 
-func (c *Cache) Watch() {
-	slog.Info("filecache: loading folder", "folder", c.folder)
+// Names returns the files currently in the folder matching the patterns.
+func (c *Cache) Names() ([]string, error) {
 	files, err := os.ReadDir(c.folder)
 	if err != nil {
-		slog.Error("filecache: read folder error", "error", err)
-		return
+		return nil, err
 	}
+	var names []string
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
 		name := file.Name()
-		if match, err := path.Match(c.pattern, name); !match || err != nil {
+		if !c.matches(name) {
 			continue
 		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *Cache) Watch() {
+	slog.Info("filecache: loading folder", "folder", c.folder)
+	names, err := c.Names()
+	if err != nil {
+		slog.Error("filecache: read folder error", "error", err)
+		return
+	}
+	for _, name := range names {
 		c.doCallbacks(name)
 	}
 
@@ -179,14 +193,21 @@ func (c *Cache) Get(name string) ([]byte, bool, error) {
 }
 
 func (c *Cache) shouldProcess(fullPath, name string) bool {
+	return ShouldProcess(fullPath, name, c.patterns...)
+}
+
+// ShouldProcess reports if a changed file in a watched folder is one to load:
+// it matches one of the patterns, is not hidden (like the "..data" entries of
+// Kubernetes ConfigMap volumes) and is a regular file, not a directory or
+// symlink. A file that cannot be stat'ed is left to the caller to handle.
+func ShouldProcess(fullPath, name string, patterns ...string) bool {
 	// Filter out Kubernetes ConfigMap internal paths
 	if strings.HasPrefix(name, ".") {
 		return false
 	}
 
 	// Check if it matches the pattern
-	match, err := path.Match(c.pattern, name)
-	if err != nil || !match {
+	if !matches(patterns, name) {
 		return false
 	}
 
@@ -201,6 +222,21 @@ func (c *Cache) shouldProcess(fullPath, name string) bool {
 	return stat.Mode().IsRegular()
 }
 
+// matches reports whether name matches any of the configured patterns.
+func (c *Cache) matches(name string) bool {
+	return matches(c.patterns, name)
+}
+
+// matches reports whether name matches any of the patterns.
+func matches(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if match, err := path.Match(pattern, name); match && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Cache) readFile(folder, name string) (*File, bool, error) {
 	fname := path.Join(folder, name)
 	data, err := os.ReadFile(fname)
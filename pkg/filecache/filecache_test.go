@@ -161,3 +161,36 @@ func TestCache_shouldProcess(t *testing.T) {
 		})
 	}
 }
+
+func TestCache_Names(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, name := range []string{"request.rego", "lib.rego", "data.json", "data.yaml", "other.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "dir.rego"), 0755); err != nil {
+		t.Fatalf("failed to create subdirectory: %v", err)
+	}
+
+	c, err := New(tmpDir, "*.rego", "data.json", "data.yaml")
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	got, err := c.Names()
+	if err != nil {
+		t.Fatalf("Names() failed: %v", err)
+	}
+	want := []string{"data.json", "data.yaml", "lib.rego", "request.rego"}
+	if len(got) != len(want) {
+		t.Fatalf("Names() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Names()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package regocache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Decision describes a single policy evaluation.
+type Decision struct {
+	Policy    string        // policy file name
+	Package   string        // package the policy file belongs to
+	Revision  string        // revision of the active policy bundle, if known
+	RequestID string        // from the input's RequestID() method, if it has one
+	Input     interface{}   // input given to the policy
+	Result    interface{}   // result of the evaluation (nil on error)
+	Err       error         // evaluation error, if any
+	Duration  time.Duration // time spent evaluating the policy
+	Time      time.Time     // when the evaluation started
+}
+
+// DecisionLogger receives every decision made by the RegoCache. LogDecision is
+// called synchronously on the request path and must not block; the input is
+// still owned by the caller once it returns.
+type DecisionLogger interface {
+	LogDecision(Decision)
+}
+
+// SetDecisionLogger sets the logger receiving all decisions (nil disables).
+func (r *RegoCache) SetDecisionLogger(l DecisionLogger) {
+	r.logger = l
+}
+
+// record masks the decision and passes it to the decision logger and, in
+// debug mode, prints it. Nothing unmasked is ever logged: if masking fails the
+// input and result are left out.
+func (r *RegoCache) record(d Decision) {
+	if r.logger == nil && !debug {
+		return
+	}
+
+	if v, ok := d.Input.(interface{ RequestID() string }); ok {
+		d.RequestID = v.RequestID()
+	}
+
+	r.mtx.Lock()
+	maskRule := r.maskRule
+	d.Revision = r.revision
+	r.mtx.Unlock()
+
+	input, result, err := r.maskDecision(maskRule, d.Input, d.Result)
+	if err != nil {
+		slog.Error("rego: masking failed, input and result not logged", "policy", d.Policy, "error", err)
+		input, result = nil, nil
+	}
+	d.Input, d.Result = input, result
+
+	if r.logger != nil {
+		r.logger.LogDecision(d)
+	}
+
+	if debug && d.Result != nil {
+		buf1, _ := json.MarshalIndent(d.Input, "", "  ")
+		buf2, _ := json.MarshalIndent(d.Result, "", "  ")
+
+		w := strings.Builder{}
+		w.WriteString("input:\n")
+		w.Write(buf1)
+		w.WriteString("\nresult:\n")
+		w.Write(buf2)
+		w.WriteByte('\n')
+		fmt.Fprint(os.Stdout, w.String())
+	}
+}
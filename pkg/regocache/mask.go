@@ -0,0 +1,228 @@
+package regocache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// maskQuery is the optional rule returning extra masking operations, in the
+// same format as OPA's decision log masking.
+const maskQuery = "data.system.log.mask"
+
+// maskOp is a single masking operation on the decision document
+// {"input": ..., "result": ...}.
+type maskOp struct {
+	op    string // "remove", "hash" or "upsert"
+	path  []string
+	value interface{}
+}
+
+// Mask removes or hashes fields before the policy input and result are logged,
+// either to the decision log or the debug output.
+type Mask struct {
+	ops []maskOp
+}
+
+// NewMask creates a Mask from JSON pointers relative to the policy input, such
+// as /request/auth/token. Fields in remove are deleted, fields in hash are
+// replaced with the hex SHA-256 of their value.
+func NewMask(remove, hash []string) (*Mask, error) {
+	m := &Mask{}
+	for _, list := range []struct {
+		op       string
+		pointers []string
+	}{{"remove", remove}, {"hash", hash}} {
+		for _, ptr := range list.pointers {
+			path, err := parsePointer(ptr)
+			if err != nil {
+				return nil, err
+			}
+			m.ops = append(m.ops, maskOp{op: list.op, path: append([]string{"input"}, path...)})
+		}
+	}
+	return m, nil
+}
+
+// SetMask sets the masking applied to logged decisions and debug output.
+func (r *RegoCache) SetMask(m *Mask) {
+	r.mask = m
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if !strings.HasPrefix(ptr, "/") || len(ptr) < 2 {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// maskDecision returns masked copies of input and result. The configured
+// pointers are applied first, then the operations from the mask rule, if the
+// active policies define one. Without either nothing is copied.
+func (r *RegoCache) maskDecision(maskRule *rego.PreparedEvalQuery, input, result interface{}) (interface{}, interface{}, error) {
+	if (r.mask == nil || len(r.mask.ops) == 0) && maskRule == nil {
+		return input, result, nil
+	}
+
+	doc, err := deepCopy(map[string]interface{}{"input": input, "result": result})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ops []maskOp
+	if r.mask != nil {
+		ops = append(ops, r.mask.ops...)
+	}
+	if maskRule != nil {
+		ruleOps, err := evalMaskRule(maskRule, input, result)
+		if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, ruleOps...)
+	}
+
+	for _, op := range ops {
+		doc = applyMask(doc, op.path, op)
+	}
+
+	m := doc.(map[string]interface{})
+	return m["input"], m["result"], nil
+}
+
+// evalMaskRule evaluates data.system.log.mask, which must return a set of
+// JSON pointers (removed) or {"op": "remove"|"upsert", "path": ..., "value": ...}
+// objects. Pointers start with /input or /result.
+func evalMaskRule(q *rego.PreparedEvalQuery, input, result interface{}) ([]maskOp, error) {
+	rs, err := q.Eval(context.Background(), rego.EvalInput(map[string]interface{}{"input": input, "result": result}))
+	if err != nil {
+		return nil, fmt.Errorf("mask rule: %w", err)
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, nil
+	}
+	items, ok := rs[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("mask rule: expected a set, got %T", rs[0].Expressions[0].Value)
+	}
+
+	ops := make([]maskOp, 0, len(items))
+	for _, item := range items {
+		op := maskOp{op: "remove"}
+		var ptr string
+		switch v := item.(type) {
+		case string:
+			ptr = v
+		case map[string]interface{}:
+			ptr, _ = v["path"].(string)
+			if s, ok := v["op"].(string); ok {
+				op.op = s
+			}
+			op.value = v["value"]
+		default:
+			return nil, fmt.Errorf("mask rule: unexpected entry %v", item)
+		}
+		if op.op != "remove" && op.op != "upsert" {
+			return nil, fmt.Errorf("mask rule: unsupported op %q", op.op)
+		}
+		if op.path, err = parsePointer(ptr); err != nil {
+			return nil, fmt.Errorf("mask rule: %w", err)
+		}
+		if op.path[0] != "input" && op.path[0] != "result" {
+			return nil, fmt.Errorf("mask rule: pointer %q must start with /input or /result", ptr)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// apply returns the replacement for a masked value, or false to remove it.
+func (op maskOp) apply(v interface{}) (interface{}, bool) {
+	switch op.op {
+	case "hash":
+		return hashValue(v), true
+	case "upsert":
+		return op.value, true
+	}
+	return nil, false
+}
+
+func hashValue(v interface{}) string {
+	var buf []byte
+	if s, ok := v.(string); ok {
+		buf = []byte(s)
+	} else {
+		buf, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// applyMask walks path in node and replaces (or removes) the value it points
+// to. Missing paths are ignored, except that upsert adds a missing object key.
+func applyMask(node interface{}, path []string, op maskOp) interface{} {
+	key := path[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, found := n[key]
+		if len(path) == 1 {
+			if !found && op.op != "upsert" {
+				return n
+			}
+			if v, keep := op.apply(child); keep {
+				n[key] = v
+			} else {
+				delete(n, key)
+			}
+			return n
+		}
+		if found {
+			n[key] = applyMask(child, path[1:], op)
+		}
+		return n
+
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(n) {
+			return n
+		}
+		if len(path) == 1 {
+			if v, keep := op.apply(n[i]); keep {
+				n[i] = v
+				return n
+			}
+			return slices.Delete(n, i, i+1)
+		}
+		n[i] = applyMask(n[i], path[1:], op)
+		return n
+	}
+	return node
+}
+
+// deepCopy converts v to plain JSON values, so masking never touches the
+// caller's data.
+func deepCopy(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
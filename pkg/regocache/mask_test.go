@@ -0,0 +1,171 @@
+package regocache
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// decisionRecorder captures logged decisions
+type decisionRecorder struct {
+	decisions []Decision
+}
+
+func (d *decisionRecorder) LogDecision(dec Decision) {
+	d.decisions = append(d.decisions, dec)
+}
+
+func (d *decisionRecorder) lastInput(t *testing.T) string {
+	t.Helper()
+	if len(d.decisions) == 0 {
+		t.Fatal("no decision logged")
+	}
+	buf, _ := json.Marshal(d.decisions[len(d.decisions)-1].Input)
+	return string(buf)
+}
+
+const maskTestPolicy = `package policies
+
+default allow := true
+`
+
+func maskTestInput() map[string]any {
+	return map[string]any{
+		"request": map[string]any{
+			"auth":    map[string]any{"kind": "bearer", "token": "secret-token"},
+			"headers": map[string]any{"Cookie": "session=abc", "Accept": "*/*"},
+			"path":    []any{"api", "users"},
+		},
+	}
+}
+
+func TestNewMask_invalidPointer(t *testing.T) {
+	for _, ptr := range []string{"", "/", "request/auth"} {
+		if _, err := NewMask([]string{ptr}, nil); err == nil {
+			t.Errorf("expected error for pointer %q", ptr)
+		}
+	}
+}
+
+func TestMask_configuredPointers(t *testing.T) {
+	tmpDir := t.TempDir()
+	writePolicy(t, tmpDir, "request.rego", maskTestPolicy)
+	rc := newTestCache(t, tmpDir, "request.rego")
+
+	mask, err := NewMask(
+		[]string{"/request/auth/token", "/request/path/0", "/request/missing/field"},
+		[]string{"/request/headers/Cookie"},
+	)
+	if err != nil {
+		t.Fatalf("NewMask() failed: %v", err)
+	}
+	rc.SetMask(mask)
+	rec := &decisionRecorder{}
+	rc.SetDecisionLogger(rec)
+
+	input := maskTestInput()
+	if _, err := rc.Validate("request.rego", input); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+
+	want := `{"request":{"auth":{"kind":"bearer"},"headers":{"Accept":"*/*","Cookie":"` + hashValue("session=abc") + `"},"path":["users"]}}`
+	if got := rec.lastInput(t); got != want {
+		t.Errorf("masked input:\n got %s\nwant %s", got, want)
+	}
+
+	// the caller's input is never modified
+	if input["request"].(map[string]any)["auth"].(map[string]any)["token"] != "secret-token" {
+		t.Error("expected original input to be untouched")
+	}
+}
+
+func TestMask_regoRule(t *testing.T) {
+	tmpDir := t.TempDir()
+	writePolicy(t, tmpDir, "request.rego", maskTestPolicy)
+	writePolicy(t, tmpDir, "mask.rego", `package system.log
+
+mask contains "/input/request/auth/token"
+
+mask contains {"op": "upsert", "path": "/input/request/headers/Cookie", "value": "**REDACTED**"} if {
+	input.input.request.headers.Cookie
+}
+
+mask contains "/result/allow"
+`)
+	rc := newTestCache(t, tmpDir, "request.rego")
+	rec := &decisionRecorder{}
+	rc.SetDecisionLogger(rec)
+
+	result, err := rc.Validate("request.rego", maskTestInput())
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if result.(map[string]any)["allow"] != true {
+		t.Error("masking must not change the result returned to the caller")
+	}
+
+	want := `{"request":{"auth":{"kind":"bearer"},"headers":{"Accept":"*/*","Cookie":"**REDACTED**"},"path":["api","users"]}}`
+	if got := rec.lastInput(t); got != want {
+		t.Errorf("masked input:\n got %s\nwant %s", got, want)
+	}
+	if logged := rec.decisions[0].Result.(map[string]any); logged["allow"] != nil {
+		t.Errorf("expected allow to be masked from logged result, got %v", logged)
+	}
+}
+
+func TestMask_invalidRuleOutputDropsInput(t *testing.T) {
+	tmpDir := t.TempDir()
+	writePolicy(t, tmpDir, "request.rego", maskTestPolicy)
+	writePolicy(t, tmpDir, "mask.rego", `package system.log
+
+mask contains "/request/auth/token"
+`)
+	rc := newTestCache(t, tmpDir, "request.rego")
+	rec := &decisionRecorder{}
+	rc.SetDecisionLogger(rec)
+
+	if _, err := rc.Validate("request.rego", maskTestInput()); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if d := rec.decisions[0]; d.Input != nil || d.Result != nil {
+		t.Errorf("expected input and result to be left out when masking fails, got %+v", d)
+	}
+}
+
+// idInput is an input carrying a request id
+type idInput map[string]any
+
+func (i idInput) RequestID() string { return "req-42" }
+
+func TestRecord_requestID(t *testing.T) {
+	tmpDir := t.TempDir()
+	writePolicy(t, tmpDir, "request.rego", maskTestPolicy)
+	rc := newTestCache(t, tmpDir, "request.rego")
+	rec := &decisionRecorder{}
+	rc.SetDecisionLogger(rec)
+
+	if _, err := rc.Validate("request.rego", idInput{"a": 1}); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if id := rec.decisions[0].RequestID; id != "req-42" {
+		t.Errorf("expected request id req-42, got %q", id)
+	}
+}
+
+func TestMask_emptyMaskDoesNotCopy(t *testing.T) {
+	rc := &RegoCache{}
+	mask, err := NewMask(nil, nil)
+	if err != nil {
+		t.Fatalf("NewMask() failed: %v", err)
+	}
+	rc.SetMask(mask)
+
+	in := maskTestInput()
+	got, _, err := rc.maskDecision(nil, in, nil)
+	if err != nil {
+		t.Fatalf("maskDecision() error: %v", err)
+	}
+	if m, ok := got.(map[string]any); !ok || reflect.ValueOf(m).Pointer() != reflect.ValueOf(in).Pointer() {
+		t.Error("expected the input to be passed on without a copy")
+	}
+}
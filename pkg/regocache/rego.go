@@ -1,41 +1,71 @@
 package regocache
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/AB-Lindex/rest-rego/pkg/filecache"
 	"github.com/ninlil/envsubst"
 
+	"github.com/open-policy-agent/opa/v1/ast"
 	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
 	"github.com/open-policy-agent/opa/v1/topdown/print"
+	"github.com/open-policy-agent/opa/v1/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var debug bool
 
+const tracerName = "github.com/AB-Lindex/rest-rego/pkg/regocache"
+
+// dataFiles are loaded as data documents (merged into the root of data.*)
+// instead of being compiled as policy modules, following the OPA bundle layout.
+var dataFiles = []string{"data.json", "data.yaml", "data.yml"}
+
+// policy is a compiled policy file ready for evaluation
+type policy struct {
+	query *rego.PreparedEvalQuery
+	pkg   string
+}
+
 type RegoCache struct {
-	cache *filecache.Cache
-	regos map[string]*rego.PreparedEvalQuery
-	mtx   sync.Mutex
-	ready string
+	cache    *filecache.Cache // nil when policies come from a remote bundle
+	remote   *remote
+	regos    map[string]*policy
+	revision string
+	mtx      sync.Mutex
+	ready    string
+	logger   DecisionLogger
+	mask     *Mask
+	maskRule *rego.PreparedEvalQuery // data.system.log.mask, if defined
+	onReload func(err error)
+
+	// loadMtx serializes bundle compilation, files is the set of files
+	// making up the last compiled (or attempted) bundle
+	loadMtx sync.Mutex
+	files   []string
 }
 
 func New(folder, pattern string, debugFlag bool, readyName string) (*RegoCache, error) {
 	debug = debugFlag
 
-	c, err := filecache.New(folder, pattern)
+	c, err := filecache.New(folder, append([]string{pattern}, dataFiles...)...)
 	if err != nil {
 		return nil, err
 	}
 	return &RegoCache{
 		cache: c,
-		regos: make(map[string]*rego.PreparedEvalQuery),
+		regos: make(map[string]*policy),
 		ready: readyName,
 	}, nil
 }
@@ -51,78 +81,244 @@ func (r *RegoCache) Ready() bool {
 }
 
 func (r *RegoCache) Close() {
+	if r.remote != nil {
+		r.remote.close()
+		return
+	}
 	r.cache.Close()
 }
 
 func (r *RegoCache) Watch() {
+	if r.remote != nil {
+		r.remote.watch(r)
+		return
+	}
 	r.cache.AddCallback(r.Callback)
 	r.cache.Watch()
 }
 
 func (r *RegoCache) Callback(name string) {
 	slog.Info("rego: file update detected - reload", "file", name)
-	_, err := r.GetRego(name)
-	if err != nil {
-		slog.Error("rego: reload failed", "file", name, "error", err)
+	if err := r.reload(); err != nil {
+		slog.Error("rego: reload failed, keeping last valid policies", "file", name, "error", err)
 	}
 }
 
+// GetRego returns the prepared query for the policy file name, compiling the
+// policy bundle if the file is not known yet.
 func (r *RegoCache) GetRego(name string) (*rego.PreparedEvalQuery, error) {
-	data, dirty, err := r.cache.Get(name)
+	p, err := r.getPolicy(name)
 	if err != nil {
-		slog.Error("rego: get-cache error", "error", err)
 		return nil, err
 	}
+	return p.query, nil
+}
+
+func (r *RegoCache) getPolicy(name string) (*policy, error) {
+	r.mtx.Lock()
+	p, found := r.regos[name]
+	r.mtx.Unlock()
+	if found {
+		return p, nil
+	}
+
+	if r.cache != nil {
+		if err := r.reload(); err != nil {
+			return nil, err
+		}
+	}
 
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
+	p, found = r.regos[name]
+	if !found {
+		return nil, fmt.Errorf("policy %q not found", name)
+	}
+	return p, nil
+}
+
+// reload compiles every policy module and data document in the folder as a
+// single bundle. The new set of queries replaces the old one only when the
+// whole bundle compiles, so a broken file never takes down working policies.
+func (r *RegoCache) reload() error {
+	r.loadMtx.Lock()
+	defer r.loadMtx.Unlock()
+
+	names, err := r.cache.Names()
+	if err != nil {
+		slog.Error("rego: list-files error", "error", err)
+		return err
+	}
+
+	changed := !slices.Equal(names, r.files)
+	modules := make(map[string]*ast.Module)
+	data := make(map[string]any)
+
+	for _, name := range names {
+		content, updated, err := r.cache.Get(name)
+		if err != nil {
+			slog.Error("rego: get-cache error", "file", name, "error", err)
+			return err
+		}
+		changed = changed || updated
+		if content == nil {
+			continue
+		}
+
+		if slices.Contains(dataFiles, name) {
+			if err := mergeData(data, name, content); err != nil {
+				slog.Error("rego: data load failed", "file", name, "error", err)
+				return r.reloaded(err)
+			}
+			continue
+		}
+
+		module, err := parseModule(name, content)
+		if err != nil {
+			return r.reloaded(err)
+		}
+		modules[name] = module
+	}
+
+	if !changed {
+		return nil
+	}
+	r.files = names
+
+	return r.reloaded(r.activate(modules, data, ""))
+}
+
+// SetReloadHook sets a function called with the result of every attempt to
+// compile a new set of policies (nil disables).
+func (r *RegoCache) SetReloadHook(fn func(err error)) {
+	r.onReload = fn
+}
+
+// reloaded reports the result of a compilation to the reload hook and returns it.
+func (r *RegoCache) reloaded(err error) error {
+	if r.onReload != nil {
+		r.onReload(err)
+	}
+	return err
+}
 
-	if data == nil {
-		slog.Info("rego: deleting policy", "file", name)
-		delete(r.regos, name)
-		return nil, nil
+// activate compiles the modules together with the data documents and, if
+// everything compiles, replaces the active policies in one step.
+func (r *RegoCache) activate(modules map[string]*ast.Module, data map[string]any, revision string) error {
+	compiler := ast.NewCompiler().WithEnablePrintStatements(debug)
+	compiler.Compile(modules)
+	if compiler.Failed() {
+		slog.Error("rego: compile error", "error", compiler.Errors)
+		return compiler.Errors
 	}
 
-	query, found := r.regos[name]
-	if found && !dirty {
-		return query, nil
+	store := inmem.NewFromObject(data)
+	regos := make(map[string]*policy, len(modules))
+	var maskRule *rego.PreparedEvalQuery
+	for name, module := range modules {
+		pkg := module.Package.Path.String()
+		if pkg == "data.system.log" && maskRule == nil {
+			q, err := prepareQuery(compiler, store, maskQuery)
+			if err != nil {
+				slog.Error("rego: mask-rule prepare error", "file", name, "error", err)
+				return err
+			}
+			maskRule = q
+		}
+		slog.Info("rego: compiling policy", "file", name, "package", strings.TrimPrefix(pkg, "data."))
+
+		q, err := prepare(compiler, store, pkg)
+		if err != nil {
+			slog.Error("rego: rego-prepare error", "file", name, "error", err)
+			return err
+		}
+		regos[name] = &policy{query: q, pkg: strings.TrimPrefix(pkg, "data.")}
+	}
+
+	r.mtx.Lock()
+	for name := range r.regos {
+		if _, ok := regos[name]; !ok {
+			slog.Info("rego: deleting policy", "file", name)
+		}
 	}
+	r.regos = regos
+	r.maskRule = maskRule
+	r.revision = revision
+	r.mtx.Unlock()
+
+	slog.Info("rego: policy bundle loaded", "modules", len(modules), "data", len(data), "revision", revision)
+	return nil
+}
 
-	expanded, err := envsubst.ConvertBytes(data, envsubst.Getenv)
+// Revision returns the revision of the active policy bundle, if known.
+func (r *RegoCache) Revision() string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.revision
+}
+
+// parseModule expands environment variables in a policy file and parses it.
+func parseModule(name string, content []byte) (*ast.Module, error) {
+	expanded, err := envsubst.ConvertBytes(content, envsubst.Getenv)
 	if err != nil {
 		slog.Error("rego: env expansion failed", "file", name, "error", err)
 		return nil, err
 	}
+	return parseRawModule(name, expanded)
+}
 
-	var pkg string
-	for line := range bytes.Lines(expanded) {
-		if bytes.HasPrefix(line, []byte("package ")) {
-			pkg = string(bytes.TrimSpace(bytes.TrimPrefix(line, []byte("package "))))
-			break
-		}
+// parseRawModule parses a module as-is, without env expansion
+func parseRawModule(name string, content []byte) (*ast.Module, error) {
+	module, err := ast.ParseModuleWithOpts(name, string(content), ast.ParserOptions{})
+	if err != nil {
+		slog.Error("rego: parse error", "file", name, "error", err)
+		return nil, err
 	}
-	if pkg == "" {
+	if module == nil {
 		slog.Error("rego: package not found", "file", name)
 		return nil, fmt.Errorf("package not found")
 	}
+	return module, nil
+}
 
-	slog.Info("rego: compiling policy", "file", name, "package", pkg)
-
-	question := fmt.Sprint("x = data.", pkg)
+func prepare(compiler *ast.Compiler, store storage.Store, pkg string) (*rego.PreparedEvalQuery, error) {
+	return prepareQuery(compiler, store, fmt.Sprint("x = ", pkg))
+}
 
+func prepareQuery(compiler *ast.Compiler, store storage.Store, query string) (*rego.PreparedEvalQuery, error) {
 	q, err := rego.New(
-		rego.Query(question),
-		rego.Module(name, string(expanded)),
+		rego.Query(query),
+		rego.Compiler(compiler),
+		rego.Store(store),
 		rego.EnablePrintStatements(debug),
 	).PrepareForEval(context.Background())
 	if err != nil {
-		slog.Error("rego: rego-prepare error", "error", err)
 		return nil, err
 	}
+	return &q, nil
+}
 
-	query = &q
-	r.regos[name] = query
-	return query, nil
+// mergeData parses a JSON or YAML data document and merges its top-level keys
+// into data. A key defined by more than one data file is an error.
+func mergeData(data map[string]any, name string, content []byte) error {
+	var doc any
+	if err := util.Unmarshal(content, &doc); err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: data document must be an object", name)
+	}
+	for k, v := range obj {
+		if _, exists := data[k]; exists {
+			return fmt.Errorf("%s: data key %q already defined", name, k)
+		}
+		data[k] = v
+	}
+	return nil
 }
 
 func (r *RegoCache) Print(ctx print.Context, msg string) error {
@@ -131,40 +327,51 @@ func (r *RegoCache) Print(ctx print.Context, msg string) error {
 }
 
 func (r *RegoCache) Validate(name string, input interface{}) (interface{}, error) {
-	query, err := r.GetRego(name)
+	return r.ValidateContext(context.Background(), name, input)
+}
+
+// ValidateContext evaluates the policy like Validate, tracing the evaluation
+// as a child of any span in ctx.
+func (r *RegoCache) ValidateContext(ctx context.Context, name string, input interface{}) (interface{}, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "rego.eval", trace.WithAttributes(attribute.String("rego.policy", name)))
+	defer span.End()
+
+	p, err := r.getPolicy(name)
 	if err != nil {
 		slog.Error("rego: get-rego error", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		r.record(Decision{Policy: name, Input: input, Err: err, Time: time.Now()})
 		return nil, err
 	}
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("rego.package", p.pkg))
+		if rev := r.Revision(); rev != "" {
+			span.SetAttributes(attribute.String("rego.revision", rev))
+		}
+	}
 
-	rs, err := query.Eval(context.Background(), rego.EvalInput(input), rego.EvalPrintHook(r), rego.EvalInstrument(false))
+	now := time.Now()
+	rs, err := p.query.Eval(ctx, rego.EvalInput(input), rego.EvalPrintHook(r), rego.EvalInstrument(false))
+	elapsed := time.Since(now)
 	if err != nil {
 		slog.Error("rego: eval error", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		r.record(Decision{Policy: name, Package: p.pkg, Input: input, Err: err, Duration: elapsed, Time: now})
 		return nil, err
 	}
 
-	if len(rs) == 0 {
-		return nil, nil
+	var result interface{}
+	if len(rs) > 0 {
+		result = rs[0].Bindings["x"]
 	}
-	result := rs[0].Bindings["x"]
-
-	if debug {
-		buf1, _ := json.MarshalIndent(input, "", "  ")
-		buf2, _ := json.MarshalIndent(result, "", "  ")
-
-		w := strings.Builder{}
-		w.WriteString("input:\n")
-		w.Write(buf1)
-		w.WriteString("\nresult:\n")
-		w.Write(buf2)
-		w.WriteByte('\n')
-		fmt.Fprint(os.Stdout, w.String())
-	}
-
+	r.record(Decision{Policy: name, Package: p.pkg, Input: input, Result: result, Duration: elapsed, Time: now})
 	return result, nil
 }
 
 func (r *RegoCache) Info() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
 	fmt.Println("RegoCache - status")
 	for k := range r.regos {
 		fmt.Println("  -", k)
@@ -1,9 +1,18 @@
 package regocache
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func writePolicy(t testing.TB, dir, name, content string) {
@@ -175,6 +184,182 @@ default allow := true
 	}
 }
 
+func TestBundle_sharedLibraryAndData(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, "helpers.rego", `package lib.helpers
+
+is_admin(user) if {
+	data.roles.admins[_] == user
+}
+`)
+	writePolicy(t, tmpDir, policyFile, `package testpkg
+
+import data.lib.helpers
+
+default allow := false
+
+allow if {
+	helpers.is_admin(input.user)
+}
+
+limit := data.limits.default
+`)
+	writePolicy(t, tmpDir, "data.json", `{"roles": {"admins": ["alice"]}}`)
+	writePolicy(t, tmpDir, "data.yaml", "limits:\n  default: 10\n")
+	rc := newTestCache(t, tmpDir, policyFile)
+
+	result, err := rc.Validate(policyFile, map[string]any{"user": "alice"})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	m, ok := result.(map[string]any)
+	if !ok {
+		t.Fatalf("result is not a map: %T", result)
+	}
+	if m["allow"] != true {
+		t.Errorf("expected allow=true for admin from data.json, got %v", m["allow"])
+	}
+	if fmt.Sprint(m["limit"]) != "10" {
+		t.Errorf("expected limit=10 from data.yaml, got %v", m["limit"])
+	}
+
+	result, err = rc.Validate(policyFile, map[string]any{"user": "bob"})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if m := result.(map[string]any); m["allow"] != false {
+		t.Errorf("expected allow=false for non-admin, got %v", m["allow"])
+	}
+}
+
+func TestBundle_multiFilePackage(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, policyFile, `package testpkg
+
+default allow := false
+
+allow if {
+	input.method == "GET"
+}
+`)
+	writePolicy(t, tmpDir, "extra.rego", `package testpkg
+
+reason := "read-only"
+`)
+	rc := newTestCache(t, tmpDir, policyFile)
+
+	result, err := rc.Validate(policyFile, map[string]any{"method": "GET"})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	m := result.(map[string]any)
+	if m["allow"] != true || m["reason"] != "read-only" {
+		t.Errorf("expected rules from both files, got %v", m)
+	}
+}
+
+func TestBundle_brokenReloadKeepsLastKnownGood(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, policyFile, `package testpkg
+
+default allow := true
+`)
+	rc := newTestCache(t, tmpDir, policyFile)
+	if !rc.Ready() {
+		t.Fatal("expected cache to be ready")
+	}
+
+	// a helper that does not compile must not unload the working policy
+	writePolicy(t, tmpDir, "broken.rego", `package lib
+
+x := undefined_function(1)
+`)
+	if err := rc.reload(); err == nil {
+		t.Fatal("expected reload of broken bundle to fail")
+	}
+	result, err := rc.Validate(policyFile, map[string]any{})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if m := result.(map[string]any); m["allow"] != true {
+		t.Errorf("expected last known good policy, got %v", m)
+	}
+
+	// fixing the helper recompiles the whole bundle
+	writePolicy(t, tmpDir, "broken.rego", `package lib
+
+x := 1
+`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rc.mtx.Lock()
+		_, loaded := rc.regos["broken.rego"]
+		rc.mtx.Unlock()
+		if loaded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected fixed file to be picked up by the watcher")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReloadHook(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, policyFile, `package testpkg
+
+default allow := true
+`)
+	rc, err := New(tmpDir, "*.rego", false, policyFile)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { rc.Close() })
+
+	var results []error
+	rc.SetReloadHook(func(err error) { results = append(results, err) })
+
+	if err := rc.reload(); err != nil {
+		t.Fatalf("reload() error: %v", err)
+	}
+	writePolicy(t, tmpDir, "broken.rego", `package lib
+
+x := undefined_function(1)
+`)
+	if err := rc.reload(); err == nil {
+		t.Fatal("expected reload of broken bundle to fail")
+	}
+
+	if len(results) != 2 || results[0] != nil || results[1] == nil {
+		t.Errorf("expected a success and a failure to be reported, got %v", results)
+	}
+}
+
+func TestBundle_invalidData(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, policyFile, `package testpkg
+
+default allow := true
+`)
+	writePolicy(t, tmpDir, "data.json", `["not", "an", "object"]`)
+	rc := newTestCache(t, tmpDir, policyFile)
+
+	if rc.Ready() {
+		t.Error("expected cache not to be ready with an invalid data document")
+	}
+}
+
 // BenchmarkValidate measures per-evaluation allocations in the OPA policy path.
 //
 // Investigation: OPA's PreparedEvalQuery.Eval is suspected to accumulate internal
@@ -291,3 +476,39 @@ allow if {
 		}
 	}
 }
+
+func TestValidateContext_span(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	tmpDir := t.TempDir()
+	const policyFile = "request.rego"
+	writePolicy(t, tmpDir, policyFile, `package traced
+
+allow := true
+`)
+	rc := newTestCache(t, tmpDir, policyFile)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	if _, err := rc.ValidateContext(ctx, policyFile, map[string]any{}); err != nil {
+		t.Fatalf("ValidateContext() error: %v", err)
+	}
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 2 || spans[0].Name() != "rego.eval" {
+		t.Fatalf("expected rego.eval and parent span, got %v", spans)
+	}
+	eval := spans[0]
+	if eval.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected rego.eval to be a child of the caller's span")
+	}
+	attrs := map[attribute.Key]string{}
+	for _, kv := range eval.Attributes() {
+		attrs[kv.Key] = kv.Value.Emit()
+	}
+	if attrs["rego.policy"] != policyFile || attrs["rego.package"] != "traced" {
+		t.Errorf("unexpected span attributes: %v", attrs)
+	}
+}
@@ -0,0 +1,183 @@
+package regocache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+)
+
+const remoteTimeout = 30 * time.Second
+
+// RemoteConfig configures downloading policies as an OPA bundle over HTTP.
+type RemoteConfig struct {
+	URL          string
+	PollInterval time.Duration
+
+	// MaxSize limits the bundle tarball, and each file in it, in bytes.
+	MaxSize int64
+
+	// PublicKeyFile holds the PEM public key (or HMAC secret) used to verify
+	// the bundle signature. Empty disables signature verification.
+	PublicKeyFile string
+	KeyID         string
+	Algorithm     string
+}
+
+// remote polls an HTTP endpoint for OPA bundle tarballs, using ETags to avoid
+// re-downloading unchanged bundles.
+type remote struct {
+	cfg    RemoteConfig
+	client *http.Client
+	verify *bundle.VerificationConfig
+	etag   string
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewRemote creates a RegoCache that loads its policies from a remote OPA bundle.
+func NewRemote(cfg RemoteConfig, debugFlag bool, readyName string) (*RegoCache, error) {
+	debug = debugFlag
+
+	rm := &remote{
+		cfg:    cfg,
+		client: &http.Client{Timeout: remoteTimeout},
+		stop:   make(chan struct{}),
+	}
+
+	if cfg.PublicKeyFile != "" {
+		key, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("bundle public key: %w", err)
+		}
+		rm.verify = bundle.NewVerificationConfig(map[string]*bundle.KeyConfig{
+			cfg.KeyID: {Key: string(key), Algorithm: cfg.Algorithm},
+		}, cfg.KeyID, "", nil)
+	} else {
+		slog.Warn("rego: no bundle public key configured - bundle signatures will not be verified")
+	}
+
+	return &RegoCache{
+		remote: rm,
+		regos:  make(map[string]*policy),
+		ready:  readyName,
+	}, nil
+}
+
+// watch loads the bundle once and then keeps polling in the background.
+func (rm *remote) watch(r *RegoCache) {
+	slog.Info("rego: loading remote bundle", "url", rm.cfg.URL, "interval", rm.cfg.PollInterval)
+	rm.poll(r)
+
+	go func() {
+		ticker := time.NewTicker(rm.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rm.stop:
+				return
+			case <-ticker.C:
+				rm.poll(r)
+			}
+		}
+	}()
+}
+
+func (rm *remote) close() {
+	rm.once.Do(func() { close(rm.stop) })
+}
+
+// poll downloads the bundle if it changed and activates it. On any error the
+// previously active bundle is kept.
+func (rm *remote) poll(r *RegoCache) {
+	b, err := rm.download()
+	if err != nil {
+		slog.Error("rego: bundle download failed, keeping last valid policies", "url", rm.cfg.URL, "error", err)
+		return
+	}
+	if b == nil {
+		slog.Debug("rego: bundle not modified", "url", rm.cfg.URL, "etag", rm.etag)
+		return
+	}
+
+	// modules are used as signed: env expansion would fill in the sidecar's
+	// secrets and run a policy that differs from the published one
+	modules := make(map[string]*ast.Module, len(b.Modules))
+	for _, mf := range b.Modules {
+		name := strings.TrimPrefix(mf.Path, "/")
+		module, err := parseRawModule(name, mf.Raw)
+		if err != nil {
+			r.reloaded(err)
+			slog.Error("rego: bundle rejected, keeping last valid policies", "url", rm.cfg.URL, "error", err)
+			return
+		}
+		modules[name] = module
+	}
+
+	r.loadMtx.Lock()
+	err = r.reloaded(r.activate(modules, b.Data, b.Manifest.Revision))
+	r.loadMtx.Unlock()
+	if err != nil {
+		slog.Error("rego: bundle rejected, keeping last valid policies", "url", rm.cfg.URL, "error", err)
+		return
+	}
+
+	// only remember the etag once the bundle is active, so a rejected
+	// bundle is retried on the next poll
+	rm.etag = b.Etag
+}
+
+// download fetches the bundle, returning nil when the server reports it unchanged.
+func (rm *remote) download() (*bundle.Bundle, error) {
+	req, err := http.NewRequest(http.MethodGet, rm.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rm.etag != "" {
+		req.Header.Set("If-None-Match", rm.etag)
+	}
+
+	resp, err := rm.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, rm.cfg.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > rm.cfg.MaxSize {
+		return nil, fmt.Errorf("bundle larger than %d bytes", rm.cfg.MaxSize)
+	}
+
+	reader := bundle.NewCustomReader(bundle.NewTarballLoaderWithBaseURL(bytes.NewReader(body), rm.cfg.URL)).
+		WithSizeLimitBytes(rm.cfg.MaxSize).
+		WithBundleEtag(resp.Header.Get("ETag"))
+	if rm.verify != nil {
+		reader = reader.WithBundleVerificationConfig(rm.verify)
+	}
+
+	b, err := reader.Read()
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid bundle"), err)
+	}
+	return &b, nil
+}
@@ -0,0 +1,243 @@
+package regocache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/bundle"
+)
+
+// bundleServer serves an OPA bundle tarball with ETag support.
+type bundleServer struct {
+	*httptest.Server
+	mtx       sync.Mutex
+	body      []byte
+	etag      string
+	downloads int
+	notMod    int
+}
+
+func newBundleServer(t *testing.T) *bundleServer {
+	t.Helper()
+	bs := &bundleServer{}
+	bs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs.mtx.Lock()
+		defer bs.mtx.Unlock()
+		if r.Header.Get("If-None-Match") == bs.etag {
+			bs.notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		bs.downloads++
+		w.Header().Set("ETag", bs.etag)
+		w.Write(bs.body)
+	}))
+	t.Cleanup(bs.Close)
+	return bs
+}
+
+func (bs *bundleServer) publish(body []byte, etag string) {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	bs.body = body
+	bs.etag = etag
+}
+
+func buildBundle(t *testing.T, revision, policy string, data map[string]any, signingKey []byte) []byte {
+	t.Helper()
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: revision},
+		Data:     data,
+		Modules: []bundle.ModuleFile{{
+			URL:  "/request.rego",
+			Path: "/request.rego",
+			Raw:  []byte(policy),
+		}},
+	}
+	b.Manifest.Init()
+	if signingKey != nil {
+		if err := b.GenerateSignature(bundle.NewSigningConfig(string(signingKey), "RS256", ""), "default", false); err != nil {
+			t.Fatalf("failed to sign bundle: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := bundle.NewWriter(&buf).Write(b); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// rsaKeyPair returns PEM encoded private and the path of a PEM public key file.
+func rsaKeyPair(t *testing.T) ([]byte, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	private := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	path := filepath.Join(t.TempDir(), "bundle.pub")
+	if err := os.WriteFile(path, public, 0644); err != nil {
+		t.Fatalf("failed to write public key: %v", err)
+	}
+	return private, path
+}
+
+func newRemoteCache(t *testing.T, url, publicKeyFile string) *RegoCache {
+	t.Helper()
+	rc, err := NewRemote(RemoteConfig{
+		URL:           url,
+		PollInterval:  time.Hour, // tests poll explicitly
+		MaxSize:       1 << 20,
+		PublicKeyFile: publicKeyFile,
+		KeyID:         "default",
+		Algorithm:     "RS256",
+	}, false, "request.rego")
+	if err != nil {
+		t.Fatalf("NewRemote() failed: %v", err)
+	}
+	t.Cleanup(rc.Close)
+	rc.Watch()
+	return rc
+}
+
+const remotePolicy = `package testpkg
+
+default allow := false
+
+allow if {
+	data.users[_] == input.user
+}
+`
+
+func TestRemote_loadAndETag(t *testing.T) {
+	bs := newBundleServer(t)
+	bs.publish(buildBundle(t, "rev-1", remotePolicy, map[string]any{"users": []any{"alice"}}, nil), `"v1"`)
+
+	rc := newRemoteCache(t, bs.URL, "")
+	if !rc.Ready() {
+		t.Fatal("expected remote bundle to be loaded")
+	}
+	if rc.Revision() != "rev-1" {
+		t.Errorf("expected revision rev-1, got %q", rc.Revision())
+	}
+
+	result, err := rc.Validate("request.rego", map[string]any{"user": "alice"})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if m := result.(map[string]any); m["allow"] != true {
+		t.Errorf("expected allow=true, got %v", m)
+	}
+
+	// unchanged bundle is not downloaded again
+	rc.remote.poll(rc)
+	if bs.downloads != 1 || bs.notMod != 1 {
+		t.Errorf("expected 1 download and 1 not-modified, got %d and %d", bs.downloads, bs.notMod)
+	}
+
+	// a new bundle replaces the active policies
+	bs.publish(buildBundle(t, "rev-2", remotePolicy, map[string]any{"users": []any{"bob"}}, nil), `"v2"`)
+	rc.remote.poll(rc)
+	if rc.Revision() != "rev-2" {
+		t.Errorf("expected revision rev-2, got %q", rc.Revision())
+	}
+	result, _ = rc.Validate("request.rego", map[string]any{"user": "alice"})
+	if m := result.(map[string]any); m["allow"] != false {
+		t.Errorf("expected allow=false after update, got %v", m)
+	}
+}
+
+func TestRemote_brokenBundleKeepsLastKnownGood(t *testing.T) {
+	bs := newBundleServer(t)
+	bs.publish(buildBundle(t, "rev-1", remotePolicy, map[string]any{"users": []any{"alice"}}, nil), `"v1"`)
+	rc := newRemoteCache(t, bs.URL, "")
+
+	bs.publish(buildBundle(t, "rev-2", "package testpkg\n\nallow := undefined_function(1)\n", nil, nil), `"v2"`)
+	rc.remote.poll(rc)
+	if rc.Revision() != "rev-1" {
+		t.Errorf("expected revision rev-1 to stay active, got %q", rc.Revision())
+	}
+
+	bs.publish([]byte("not a tarball"), `"v3"`)
+	rc.remote.poll(rc)
+	if rc.Revision() != "rev-1" {
+		t.Errorf("expected revision rev-1 to stay active, got %q", rc.Revision())
+	}
+	if !rc.Ready() {
+		t.Error("expected cache to stay ready")
+	}
+}
+
+func TestRemote_signatureVerification(t *testing.T) {
+	private, publicFile := rsaKeyPair(t)
+	otherPrivate, _ := rsaKeyPair(t)
+	data := map[string]any{"users": []any{"alice"}}
+
+	testCases := []struct {
+		name      string
+		body      []byte
+		wantReady bool
+	}{
+		{"valid signature", buildBundle(t, "rev-1", remotePolicy, data, private), true},
+		{"unsigned bundle", buildBundle(t, "rev-1", remotePolicy, data, nil), false},
+		{"wrong signing key", buildBundle(t, "rev-1", remotePolicy, data, otherPrivate), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bs := newBundleServer(t)
+			bs.publish(tc.body, `"v1"`)
+			rc := newRemoteCache(t, bs.URL, publicFile)
+			if rc.Ready() != tc.wantReady {
+				t.Errorf("Ready() = %v, want %v", rc.Ready(), tc.wantReady)
+			}
+		})
+	}
+}
+
+func TestRemote_noEnvExpansion(t *testing.T) {
+	t.Setenv("REMOTE_SECRET", "leaked")
+	policy := "package testpkg\n\nsecret := \"$(REMOTE_SECRET)\"\n"
+
+	bs := newBundleServer(t)
+	bs.publish(buildBundle(t, "rev-1", policy, map[string]any{}, nil), `"v1"`)
+	rc := newRemoteCache(t, bs.URL, "")
+
+	result, err := rc.Validate("request.rego", map[string]any{})
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if m := result.(map[string]any); m["secret"] != "$(REMOTE_SECRET)" {
+		t.Errorf("expected the module to be used as published, got %v", m["secret"])
+	}
+}
+
+func TestRemote_maxSize(t *testing.T) {
+	// random data does not compress, so the tarball itself exceeds the limit
+	blob := make([]byte, 1<<20)
+	rand.Read(blob)
+
+	bs := newBundleServer(t)
+	bs.publish(buildBundle(t, "rev-1", remotePolicy, map[string]any{"blob": hex.EncodeToString(blob)}, nil), `"v1"`)
+
+	rc := newRemoteCache(t, bs.URL, "")
+	if rc.Ready() {
+		t.Error("expected a bundle over the size limit to be rejected")
+	}
+}